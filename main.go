@@ -1,16 +1,21 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
+	"strings"
 	"templ/configelements"
+	"templ/output"
 	"templ/repository"
 	"templ/templatedirectories"
 	"templ/templates"
+	"time"
 
 	"golang.org/x/term"
 )
@@ -20,15 +25,57 @@ func init() {
 }
 
 func main() {
+	// Commands have flags of their own.
+	if len(os.Args) > 1 && os.Args[1] == "render" {
+		renderCommand(os.Args[2:])
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "regen" {
+		regenCommand(os.Args[2:])
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "upgrade" {
+		upgradeCommand(os.Args[2:])
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "diff" {
+		diffCommand(os.Args[2:])
+		return
+	}
+
 	list := flag.Bool("l", false, "list available templates and exit.")
 	update := flag.Bool("u", false, "iterate over template repositories, calling git update.")
 	url := flag.String("f", "", "clone/fetch a git repository from a url. Can be a github url or a local git repository.")
 	variables := flag.Bool("v", false, "show only the variables from a file. If encountered, this will execute and exit.")
+	interactive := flag.Bool("i", false, "ask for the value of every variable the template uses that isn't in its variables file, then render.")
+	saveAnswers := flag.String("save-answers", "", "with -i, save the answers to this yaml file, so they can be used again as templatename=answers.yaml.")
+	strict := flag.Bool("strict", false, "refuse to render unless every variable the template uses is supplied. Lists every missing variable and prints nothing.")
+	envPrefix := flag.String("env-prefix", "TEMPL_VAR_", "environment variables starting with this prefix are template variables; TEMPL_VAR_db__host sets db.host. Empty turns this off.")
+	varsFormat := flag.String("vars-format", "", "format of the variables files: "+strings.Join(templates.VariablesFormats, ", ")+". Picked from each file's extension when empty.")
+	showVars := flag.Bool("show-vars", false, "print the merged variables each template would be rendered with, and where each value came from, then exit.")
+	initVars := flag.Bool("init-vars", false, "write a starter variables file for the template to templatename=vars.yaml, or print it. -vars-format json writes json. If encountered, this will execute and exit.")
+	outputPath := flag.String("o", "", "write the rendered template to this file, or several to this directory, instead of stdout.")
+	policy := flag.String("policy", string(output.Fail), "what to do about output files that are already there: "+strings.Join(output.Policies, ", ")+". backup moves them to name"+output.BackupSuffix+" first.")
+	keepMode := flag.Bool("keep-mode", false, "give files written with -o the permission bits of their templates, so a script template renders as an executable script.")
+	lineEndings := flag.String("line-endings", "keep", "line endings of the output: "+strings.Join(templates.LineEndings, ", ")+". keep leaves them as the template renders them.")
+	bom := flag.String("bom", "keep", "byte order mark of the output: "+strings.Join(templates.ByteOrderMarks, ", ")+". keep puts one on output whose template starts with one.")
+	diff := flag.Bool("diff", false, "print a unified diff of what -o or -layout would change, and write nothing.")
+	check := flag.Bool("check", false, "write nothing, and exit 1 if what -o or -layout would write isn't what's there already.")
+	layout := flag.String("layout", "", "render every template listed in this layout file, each to its own output, then exit.")
+	noAnswers := flag.Bool("no-answers", false, "don't record what -o or -layout rendered in "+templates.ProvenanceFile+" beside the files it wrote.")
+	noHooks := flag.Bool("no-hooks", false, "don't run the commands templates declare to run after -o or -layout writes them.")
+	hookTimeout := flag.Duration("hook-timeout", templates.DefaultHookTimeout, "how long each hook may run for, unless its template says otherwise.")
+	var definitions stringList
+	flag.Var(&definitions, "set", "KEY=VALUE variable that overrides variables files and the environment. Can be repeated.")
 
 	usage := fmt.Sprintf("%s <templatename || templatename=variablesfile.yaml> <flags>\n\n"+
 		"<templatename> can come in one of two forms. First is a template filename,"+
 		"second is a template filename plus a yaml variables file of key: value pairs which"+
-		"will populate the template file's variables. If no variables file is provided, the utility"+
+		"will populate the template file's variables. Several variables files can be given as"+
+		" templatename=base.yaml,overlay.yaml and are merged in order. If no variables are provided, the utility"+
 		"operates like 'cat' on the file, printing it to stdout.\n\n"+
 		"This utility can also be called in a pipeline as %s templatename | %s FOO=BAR BAM=BAS, for folks who"+
 		"prefer not to have a variables file.\n\n"+
@@ -36,14 +83,47 @@ func main() {
 		"git repository download support is provided by the awesome https://github.com/go-git/, and all protocols are supported."+
 		"Only a `git pull` operation is supported - edit your templates with a text editor, commit to git, and"+
 		" run `templ -u` to update your templates.\n\n"+
-		"templ also supports project layouts. Supply a yaml file listing template names and template definition files.\n",
-		filepath.Base(os.Args[0]), filepath.Base(os.Args[0]), filepath.Base(os.Args[0]))
+		"templ also supports project layouts. Supply a yaml file listing template names, their variables files and"+
+		" where each one goes with `%s -layout project.yaml`.\n\n"+
+		"A template name can end with @ and a git tag, branch or commit, like `%s build.yaml@v1.4.0=vars.yaml`, to render"+
+		" the template as it is there, without changing what's checked out. `%s diff build.yaml@v1.4.0 build.yaml@main"+
+		" vars.yaml` shows how two versions render differently.\n\n"+
+		"`%s render <directory> vars.yaml -o ./newproject` renders a whole directory of templates into a new project; "+
+		"see `%s render -h`. Rendered files are recorded in "+templates.ProvenanceFile+", `%s regen` renders them again and"+
+		" `%s upgrade` merges the changes of newer templates into them.\n",
+		filepath.Base(os.Args[0]), filepath.Base(os.Args[0]), filepath.Base(os.Args[0]), filepath.Base(os.Args[0]), filepath.Base(os.Args[0]),
+		filepath.Base(os.Args[0]), filepath.Base(os.Args[0]), filepath.Base(os.Args[0]), filepath.Base(os.Args[0]), filepath.Base(os.Args[0]))
 
 	flag.Usage = func() { fmt.Println(usage); flag.PrintDefaults() }
 	flag.Parse()
 
 	fd := os.Stdin.Fd()
 
+	// One prompter reads every answer from stdin, so that none of them is lost in another reader's buffer.
+	prompter := templates.NewPrompter(os.Stdin, os.Stderr)
+
+	renderOptions := templates.RenderOptions{
+		Strict:          *strict,
+		EnvPrefix:       *envPrefix,
+		Definitions:     definitions,
+		VariablesFormat: *varsFormat,
+		LineEndings:     oneOf("line-endings", *lineEndings, templates.LineEndings),
+		ByteOrderMark:   oneOf("bom", *bom, templates.ByteOrderMarks),
+		Output:          *outputPath,
+		Writing:         writingOptions(*policy, *keepMode, prompter),
+		NoProvenance:    *noAnswers,
+		Hooks:           hookOptions(*noHooks, *hookTimeout, prompter),
+	}
+
+	if *diff || *check {
+		if *outputPath == "" && *layout == "" {
+			fmt.Fprintln(os.Stderr, "-diff and -check compare what -o or -layout would write with what's there, so they need one of them")
+			os.Exit(2)
+		}
+
+		renderOptions.Writing.DiffTo, renderOptions.Writing.Check = diffTo(*diff), *check
+	}
+
 	//Someone's piping into the binary. Read from stdin and deal with the rendering.
 	if !term.IsTerminal(int(fd)) {
 		input, err := io.ReadAll(os.Stdin)
@@ -53,27 +133,74 @@ func main() {
 			panic(fmt.Errorf("%s:%d: %v", file, line, err))
 		}
 
-		if *variables {
-			templates.RetrieveVariables(string(input))
-			os.Exit(0)
-		}
-
 		// There are 2 conditions that reach this line. The first is that we're piping input into templ.
 		// The second is that we're running in a non-interactive shell, like ci/cd does.
 		// In the first case, we want to deal with the input.
 		// In the second case, we just want to fall out of this block and back to the default logic handling.
 		if len(input) > 0 {
+			if *initVars {
+				variables, err := templates.RetrieveVariables(string(input))
+
+				if err != nil {
+					_, file, line, _ := runtime.Caller(0)
+					panic(fmt.Errorf("%s:%d: %v", file, line, err))
+				}
+
+				skeleton, err := templates.VariablesSkeleton(variables, *varsFormat)
+
+				if err != nil {
+					_, file, line, _ := runtime.Caller(0)
+					panic(fmt.Errorf("%s:%d: %v", file, line, err))
+				}
+
+				os.Stdout.Write(skeleton)
+				os.Exit(0)
+			}
+
+			if *variables {
+				variables, err := templates.RetrieveVariables(string(input))
+
+				if err != nil {
+					_, file, line, _ := runtime.Caller(0)
+					panic(fmt.Errorf("%s:%d: %v", file, line, err))
+				}
+
+				printVariables(variables)
+				os.Exit(0)
+			}
 
-			variableDefinitions := flag.Args()
-			hydratedTemplate, err := templates.RenderFromStdin(string(input), variableDefinitions)
+			variableDefinitions := positionalArgs()
+
+			if *showVars {
+				renderOptions.Definitions = append(renderOptions.Definitions, variableDefinitions...)
+				err = templates.ShowVariables("stdin", string(input), nil, renderOptions, os.Stdout)
+
+				if err != nil {
+					_, file, line, _ := runtime.Caller(0)
+					panic(fmt.Errorf("%s:%d: %v", file, line, err))
+				}
+				os.Exit(0)
+			}
+
+			hydratedTemplate, err := templates.RenderFromStdin(string(input), variableDefinitions, renderOptions)
+
+			if err == nil && *outputPath != "" {
+				// A template read from stdin has no mode to keep.
+				_, err = output.Write([]output.File{{Path: *outputPath, Content: []byte(hydratedTemplate)}}, renderOptions.Writing)
+			} else if err == nil {
+				fmt.Print(hydratedTemplate)
+			}
+
+			if isRenderProblem(err) {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
 
 			if err != nil {
 				_, file, line, _ := runtime.Caller(0)
 				panic(fmt.Errorf("%s:%d: %v", file, line, err))
 			}
 
-			fmt.Println(hydratedTemplate)
-
 			os.Exit(0)
 		}
 	}
@@ -118,9 +245,33 @@ func main() {
 		}
 	}
 
+	if *layout != "" {
+		written, err := templates.RenderLayout(*layout, renderOptions)
+
+		for _, path := range written {
+			fmt.Println(path)
+		}
+
+		if isRenderProblem(err) {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		if err != nil {
+			_, file, line, _ := runtime.Caller(0)
+			panic(fmt.Errorf("%s:%d: %v", file, line, err))
+		}
+		os.Exit(0)
+	}
+
 	if *variables {
 		templateFilePaths, _, err := templates.FindTemplateAndVariableFiles(flag.Args())
 
+		if isRenderProblem(err) {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
 		if err != nil {
 			panic(err)
 		}
@@ -132,15 +283,17 @@ func main() {
 				panic(err)
 			}
 
-			variables := templates.RetrieveVariables(string(content))
+			variables, err := templates.RetrieveTemplateVariables(file, string(content))
+
+			if err != nil {
+				panic(err)
+			}
 
 			if len(variables) == 0 {
 				fmt.Printf("No variables detected in %s\n", file)
 			} else {
 
-				for _, variable := range variables {
-					fmt.Println(variable)
-				}
+				printVariables(variables)
 			}
 
 		}
@@ -149,22 +302,452 @@ func main() {
 
 	templateFilePaths, templateVariablesFilesPaths, err := templates.FindTemplateAndVariableFiles(flag.Args())
 
+	// A template at a git ref that isn't there.
+	if isRenderProblem(err) {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
 	if err != nil {
 		_, file, line, _ := runtime.Caller(0)
 		panic(fmt.Errorf("%s:%d: %v", file, line, err))
 	}
 
-	err = templates.RenderFromFiles(templateFilePaths, templateVariablesFilesPaths)
+	if *initVars {
+		err = templates.InitVariables(templateFilePaths, templateVariablesFilesPaths, renderOptions, os.Stdout)
+
+		if isRenderProblem(err) {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		if err != nil {
+			_, file, line, _ := runtime.Caller(0)
+			panic(fmt.Errorf("%s:%d: %v", file, line, err))
+		}
+		os.Exit(0)
+	}
+
+	if *showVars {
+		for _, templatePath := range templateFilePaths {
+			content, err := os.ReadFile(templatePath)
+
+			if err != nil {
+				panic(err)
+			}
+
+			err = templates.ShowVariables(templatePath, string(content), templateVariablesFilesPaths[templatePath], renderOptions, os.Stdout)
+
+			if err != nil {
+				panic(err)
+			}
+		}
+		os.Exit(0)
+	}
+
+	if *interactive {
+		if !term.IsTerminal(int(fd)) {
+			_, file, line, _ := runtime.Caller(0)
+			panic(fmt.Errorf("%s:%d: -i needs a terminal to ask questions on", file, line))
+		}
+
+		// Questions go to stderr, so that the rendered template can be redirected on its own.
+		err = templates.RenderInteractively(templateFilePaths, templateVariablesFilesPaths, *saveAnswers, renderOptions, prompter)
+	} else {
+		err = templates.RenderFromFiles(templateFilePaths, templateVariablesFilesPaths, renderOptions)
+	}
+
+	if isRenderProblem(err) {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if err != nil {
+		_, file, line, _ := runtime.Caller(0)
+		panic(fmt.Errorf("%s:%d: %v", file, line, err))
+	}
+
+}
+
+// renderCommand is templ render <directory> [variablesfile...] -o outputdir, which renders every file of a directory
+// template, paths included, into a new directory.
+func renderCommand(args []string) {
+	command := flag.NewFlagSet("render", flag.ExitOnError)
+	outputDir := command.String("o", ".", "directory to render into.")
+	policy := command.String("policy", string(output.Fail), "what to do about files that are already there: "+strings.Join(output.Policies, ", ")+". backup moves them to name"+output.BackupSuffix+" first.")
+	strict := command.Bool("strict", false, "refuse to render unless every variable the templates use is supplied.")
+	envPrefix := command.String("env-prefix", "TEMPL_VAR_", "environment variables starting with this prefix are template variables.")
+	varsFormat := command.String("vars-format", "", "format of the variables files: "+strings.Join(templates.VariablesFormats, ", ")+". Picked from each file's extension when empty.")
+	lineEndings := command.String("line-endings", "keep", "line endings of the files: "+strings.Join(templates.LineEndings, ", ")+".")
+	bom := command.String("bom", "keep", "byte order mark of the files: "+strings.Join(templates.ByteOrderMarks, ", ")+".")
+	diff := command.Bool("diff", false, "print a unified diff of what would change in the output directory, and write nothing.")
+	check := command.Bool("check", false, "write nothing, and exit 1 if the output directory isn't what the templates render.")
+	noAnswers := command.Bool("no-answers", false, "don't record what was rendered in "+templates.ProvenanceFile+" in the output directory.")
+	noHooks := command.Bool("no-hooks", false, "don't run the commands the directory declares in its "+templates.DirectoryMetadataFile+".")
+	hookTimeout := command.Duration("hook-timeout", templates.DefaultHookTimeout, "how long each hook may run for, unless the directory says otherwise.")
+	var definitions stringList
+	command.Var(&definitions, "set", "KEY=VALUE variable that overrides variables files and the environment. Can be repeated.")
+
+	command.Usage = func() {
+		fmt.Printf("%s render <directory> [variablesfile...] -o outputdir\n\n"+
+			"Renders every file in a directory template, a directory of a template repository or any other directory, into"+
+			" outputdir. Paths are templates too: cmd/{{ .name }}/main.go becomes cmd/shop/main.go. <directory>@v1.4.0"+
+			" renders the directory as it is at that git tag, branch or commit.\n",
+			filepath.Base(os.Args[0]))
+		command.PrintDefaults()
+	}
+
+	positional := parseInterspersed(command, args)
+
+	if len(positional) == 0 {
+		command.Usage()
+		os.Exit(2)
+	}
+
+	templateDir, err := templates.FindTemplateDirectory(positional[0])
+
+	if errors.Is(err, templates.TemplateVariableErr{}) {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 
 	if err != nil {
 		_, file, line, _ := runtime.Caller(0)
 		panic(fmt.Errorf("%s:%d: %v", file, line, err))
 	}
 
+	// Overwriting files and approving hooks read their answers from the same stdin.
+	prompter := templates.NewPrompter(os.Stdin, os.Stderr)
+	renderOptions := templates.RenderOptions{
+		Strict:          *strict,
+		EnvPrefix:       *envPrefix,
+		Definitions:     definitions,
+		VariablesFormat: *varsFormat,
+		LineEndings:     oneOf("line-endings", *lineEndings, templates.LineEndings),
+		ByteOrderMark:   oneOf("bom", *bom, templates.ByteOrderMarks),
+		Writing:         writingOptions(*policy, true, prompter),
+		NoProvenance:    *noAnswers,
+		Hooks:           hookOptions(*noHooks, *hookTimeout, prompter),
+	}
+	renderOptions.Writing.DiffTo, renderOptions.Writing.Check = diffTo(*diff), *check
+	written, err := templates.RenderDirectory(templateDir, positional[1:], *outputDir, renderOptions)
+
+	for _, path := range written {
+		fmt.Println(path)
+	}
+
+	if isRenderProblem(err) {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if err != nil {
+		_, file, line, _ := runtime.Caller(0)
+		panic(fmt.Errorf("%s:%d: %v", file, line, err))
+	}
+}
+
+// regenCommand is templ regen [directory], which renders every template recorded in a directory's provenance file
+// again, with the variables it was rendered with.
+func regenCommand(args []string) {
+	command := flag.NewFlagSet("regen", flag.ExitOnError)
+	policy := command.String("policy", string(output.Overwrite), "what to do about files that are already there: "+strings.Join(output.Policies, ", ")+". backup moves them to name"+output.BackupSuffix+" first.")
+	strict := command.Bool("strict", false, "refuse to render unless every variable the templates use is supplied.")
+	envPrefix := command.String("env-prefix", "TEMPL_VAR_", "environment variables starting with this prefix override the recorded variables.")
+	diff := command.Bool("diff", false, "print a unified diff of what would change, and write nothing.")
+	check := command.Bool("check", false, "write nothing, and exit 1 if the files aren't what their templates render now.")
+	var definitions stringList
+	command.Var(&definitions, "set", "KEY=VALUE variable that overrides the recorded variables, and is recorded in their place. Can be repeated.")
+
+	command.Usage = func() {
+		fmt.Printf("%s regen [directory]\n\n"+
+			"Renders every template recorded in the directory's "+templates.ProvenanceFile+" again, from the template as it is"+
+			" in the templates directory now, with the variables it was rendered with. The directory is the current one"+
+			" if there isn't one.\n",
+			filepath.Base(os.Args[0]))
+		command.PrintDefaults()
+	}
+
+	positional := parseInterspersed(command, args)
+
+	if len(positional) > 1 {
+		command.Usage()
+		os.Exit(2)
+	}
+
+	dir := "."
+
+	if len(positional) == 1 {
+		dir = positional[0]
+	}
+
+	renderOptions := templates.RenderOptions{
+		Strict:      *strict,
+		EnvPrefix:   *envPrefix,
+		Definitions: definitions,
+		Writing:     writingOptions(*policy, true, templates.NewPrompter(os.Stdin, os.Stderr)),
+	}
+	renderOptions.Writing.DiffTo, renderOptions.Writing.Check = diffTo(*diff), *check
+	written, err := templates.Regenerate(dir, renderOptions)
+
+	for _, path := range written {
+		fmt.Println(path)
+	}
+
+	if isRenderProblem(err) {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if err != nil {
+		_, file, line, _ := runtime.Caller(0)
+		panic(fmt.Errorf("%s:%d: %v", file, line, err))
+	}
+}
+
+// upgradeCommand is templ upgrade [directory], which merges what's changed in the templates recorded in a directory's
+// provenance file, since the directory was rendered, into its files.
+func upgradeCommand(args []string) {
+	command := flag.NewFlagSet("upgrade", flag.ExitOnError)
+	strict := command.Bool("strict", false, "refuse to render unless every variable the templates use is supplied.")
+	envPrefix := command.String("env-prefix", "TEMPL_VAR_", "environment variables starting with this prefix override the recorded variables.")
+	diff := command.Bool("diff", false, "print a unified diff of what the upgrade would change, and write nothing.")
+	check := command.Bool("check", false, "write nothing, and exit 1 if the upgrade would change anything.")
+	var definitions stringList
+	command.Var(&definitions, "set", "KEY=VALUE variable that overrides the recorded variables, and is recorded in their place. Can be repeated.")
+
+	command.Usage = func() {
+		fmt.Printf("%s upgrade [directory]\n\n"+
+			"Merges the changes made to the templates recorded in the directory's "+templates.ProvenanceFile+", since the"+
+			" commits it records, into the files they rendered, keeping your changes to them. Where the two conflict, the"+
+			" files get conflict markers, and %s exits with 1. Run `%s -u` first to pull the templates' new commits.\n",
+			filepath.Base(os.Args[0]), filepath.Base(os.Args[0]), filepath.Base(os.Args[0]))
+		command.PrintDefaults()
+	}
+
+	positional := parseInterspersed(command, args)
+
+	if len(positional) > 1 {
+		command.Usage()
+		os.Exit(2)
+	}
+
+	dir := "."
+
+	if len(positional) == 1 {
+		dir = positional[0]
+	}
+
+	renderOptions := templates.RenderOptions{
+		Strict:      *strict,
+		EnvPrefix:   *envPrefix,
+		Definitions: definitions,
+	}
+	renderOptions.Writing.DiffTo, renderOptions.Writing.Check = diffTo(*diff), *check
+
+	// With -diff, the diffs are on stdout, and the summary goes out of their way.
+	var summary io.Writer = os.Stdout
+
+	if *diff {
+		summary = os.Stderr
+	}
+
+	err := templates.Upgrade(dir, renderOptions, summary)
+
+	if isRenderProblem(err) {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if err != nil {
+		_, file, line, _ := runtime.Caller(0)
+		panic(fmt.Errorf("%s:%d: %v", file, line, err))
+	}
+}
+
+// diffCommand is templ diff <old> <new> [variablesfile...], which shows how two versions of a template render
+// differently with the same variables.
+func diffCommand(args []string) {
+	command := flag.NewFlagSet("diff", flag.ExitOnError)
+	strict := command.Bool("strict", false, "refuse to render unless every variable the templates use is supplied.")
+	envPrefix := command.String("env-prefix", "TEMPL_VAR_", "environment variables starting with this prefix are template variables.")
+	varsFormat := command.String("vars-format", "", "format of the variables files: "+strings.Join(templates.VariablesFormats, ", ")+". Picked from each file's extension when empty.")
+	var definitions stringList
+	command.Var(&definitions, "set", "KEY=VALUE variable that overrides variables files and the environment. Can be repeated.")
+
+	command.Usage = func() {
+		fmt.Printf("%s diff <template>@<ref> <template>@<ref> [variablesfile...]\n\n"+
+			"Renders two versions of a template, or of a directory template, with the same variables, and prints a"+
+			" unified diff of what they render. A ref is a git tag, branch or commit of the template's repository; a"+
+			" template without one is the one that's checked out. Exits with 1 if the two are different.\n",
+			filepath.Base(os.Args[0]))
+		command.PrintDefaults()
+	}
+
+	positional := parseInterspersed(command, args)
+
+	if len(positional) < 2 {
+		command.Usage()
+		os.Exit(2)
+	}
+
+	renderOptions := templates.RenderOptions{
+		Strict:          *strict,
+		EnvPrefix:       *envPrefix,
+		Definitions:     definitions,
+		VariablesFormat: *varsFormat,
+	}
+
+	changed, err := templates.DiffVersions(positional[0], positional[1], positional[2:], renderOptions, os.Stdout)
+
+	if isRenderProblem(err) {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		_, file, line, _ := runtime.Caller(0)
+		panic(fmt.Errorf("%s:%d: %v", file, line, err))
+	}
+
+	if changed {
+		os.Exit(1)
+	}
 }
 
 //Helper functions
 
+// isRenderProblem reports whether an error is the templates', the variables' or the output's, which is told to the
+// user as it is, rather than a failure of templ's.
+func isRenderProblem(err error) bool {
+	return errors.Is(err, templates.MissingVariablesErr{}) || errors.Is(err, templates.TemplateError{}) ||
+		errors.Is(err, templates.TemplateVariableErr{}) || errors.Is(err, output.ExistsErr{}) || errors.Is(err, output.DriftErr{}) ||
+		errors.Is(err, templates.ConflictErr{}) || errors.Is(err, templates.HookErr{})
+}
+
+// diffTo is where -diff prints its diffs: stdout, or nowhere without -diff.
+func diffTo(diff bool) io.Writer {
+	if diff {
+		return os.Stdout
+	}
+	return nil
+}
+
+// oneOf checks that a flag's value is one of values, and exits if it isn't.
+func oneOf(name string, value string, values []string) string {
+	if !slices.Contains(values, value) {
+		fmt.Fprintf(os.Stderr, "-%s must be one of %s, not %s\n", name, strings.Join(values, ", "), value)
+		os.Exit(2)
+	}
+	return value
+}
+
+// writingOptions turns the -policy and -keep-mode flags into output options. The prompt policy asks its questions on
+// stderr, and needs a terminal to read the answers from.
+func writingOptions(policy string, keepMode bool, prompter *templates.Prompter) output.Options {
+	parsed, err := output.ParsePolicy(policy)
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	options := output.Options{Policy: parsed, KeepMode: keepMode}
+
+	if parsed == output.Prompt {
+		if !term.IsTerminal(int(os.Stdin.Fd())) {
+			fmt.Fprintln(os.Stderr, "-policy prompt needs a terminal to ask questions on")
+			os.Exit(2)
+		}
+
+		options.Ask = func(path string) (bool, error) {
+			return prompter.Confirm(path + " is already there. Overwrite it?")
+		}
+	}
+
+	return options
+}
+
+// hookOptions turns the -no-hooks and -hook-timeout flags into hook options. The hooks of a template repository are
+// only approved on a terminal, where the question is asked on stderr.
+func hookOptions(noHooks bool, timeout time.Duration, prompter *templates.Prompter) templates.HookOptions {
+	options := templates.HookOptions{Disabled: noHooks, Timeout: timeout}
+
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		options.Approve = func(repository string, commands []string) (bool, error) {
+			fmt.Fprintf(os.Stderr, "The templates of %s run these commands once they're rendered:\n", repository)
+
+			for _, command := range commands {
+				fmt.Fprintf(os.Stderr, "  %s\n", command)
+			}
+
+			return prompter.Confirm("Run them, now and whenever " + repository + "'s templates are rendered, until their commands change?")
+		}
+	}
+
+	return options
+}
+
+// parseInterspersed parses a command's flags wherever they are among its arguments, so that
+// templ render dir vars.yaml -o out works as well as templ render -o out dir vars.yaml. It returns the rest of the
+// arguments. Everything after a "--" is taken as it is.
+func parseInterspersed(command *flag.FlagSet, args []string) []string {
+	var positional []string
+
+	for {
+		// ExitOnError means a bad flag never gets back here.
+		command.Parse(args)
+		rest := command.Args()
+
+		if consumed := len(args) - len(rest); consumed > 0 && args[consumed-1] == "--" {
+			return append(positional, rest...)
+		}
+
+		if len(rest) == 0 {
+			return positional
+		}
+
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
+
+// positionalArgs returns the arguments left after the flags. flag.Parse swallows a "--" that ends the flags, but
+// among KEY=VALUE definitions "--" means "take everything after me literally", so it's put back.
+func positionalArgs() []string {
+	args := flag.Args()
+	consumed := len(os.Args) - 1 - len(args)
+
+	if consumed > 0 && os.Args[consumed] == "--" {
+		return append([]string{"--"}, args...)
+	}
+
+	return args
+}
+
+// printVariables prints each variable, followed by the places the template uses it.
+func printVariables(variables []templates.Variable) {
+	for _, variable := range variables {
+		fmt.Println(variable)
+
+		for _, reference := range variable.References {
+			fmt.Printf("  %s\n", reference)
+		}
+	}
+}
+
+// stringList is a flag that can be given more than once.
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ", ")
+}
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
 // createTemplDir requests information about the right path for templ's templates directory and creates that directory
 // if need be. After calling this, our initial precondition should be met.
 func createTemplDir() {
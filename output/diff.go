@@ -0,0 +1,251 @@
+package output
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// contextLines is how many unchanged lines a diff shows around each change.
+const contextLines = 3
+
+type operation int
+
+const (
+	equal operation = iota
+	deletion
+	insertion
+)
+
+type edit struct {
+	operation operation
+	line      string
+}
+
+// UnifiedDiff returns the differences between two versions of a file as a unified diff, like diff -u, or nothing if
+// they're the same. oldPath is /dev/null for a file that isn't there yet. Files with a NUL byte are binary, and only
+// said to differ.
+func UnifiedDiff(oldPath string, newPath string, old []byte, new []byte) string {
+	if bytes.Equal(old, new) {
+		return ""
+	}
+
+	var diff strings.Builder
+
+	if bytes.IndexByte(old, 0) >= 0 || bytes.IndexByte(new, 0) >= 0 {
+		fmt.Fprintf(&diff, "Binary files %s and %s differ\n", oldPath, newPath)
+		return diff.String()
+	}
+
+	fmt.Fprintf(&diff, "--- %s\n+++ %s\n", oldPath, newPath)
+	writeHunks(&diff, myers(splitLines(string(old)), splitLines(string(new))))
+
+	return diff.String()
+}
+
+// splitLines splits text into lines, each with its newline, if it has one.
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+
+	lines := strings.SplitAfter(text, "\n")
+
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	return lines
+}
+
+// myers finds the shortest edit script that turns a into b, with the linear space variant of Myers' O(ND) algorithm:
+// it finds the middle of the script, and then the script on either side of it, so that however many lines changed, it
+// only ever keeps a few rows of furthest points.
+func myers(a []string, b []string) []edit {
+	var edits []edit
+	return appendEdits(edits, a, b)
+}
+
+// appendEdits appends the shortest edit script that turns a into b to edits.
+func appendEdits(edits []edit, a []string, b []string) []edit {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	for _, line := range a[:prefix] {
+		edits = append(edits, edit{equal, line})
+	}
+
+	middleA, middleB := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+
+	switch {
+	case len(middleA) == 0:
+		for _, line := range middleB {
+			edits = append(edits, edit{insertion, line})
+		}
+	case len(middleB) == 0:
+		for _, line := range middleA {
+			edits = append(edits, edit{deletion, line})
+		}
+	default:
+		// Without a common first or last line, it takes at least two edits, so each side of the middle takes fewer.
+		x, y, u, v := middleSnake(middleA, middleB)
+
+		edits = appendEdits(edits, middleA[:x], middleB[:y])
+		for _, line := range middleA[x:u] {
+			edits = append(edits, edit{equal, line})
+		}
+		edits = appendEdits(edits, middleA[u:], middleB[v:])
+	}
+
+	for _, line := range a[len(a)-suffix:] {
+		edits = append(edits, edit{equal, line})
+	}
+
+	return edits
+}
+
+// middleSnake finds the run of equal lines, from a[x], b[y] to a[u], b[v], in the middle of the shortest edit script
+// that turns a into b. It searches from the start and from the end at once, keeping the furthest point reached on each
+// diagonal, until the two searches meet.
+func middleSnake(a []string, b []string) (x, y, u, v int) {
+	n, m := len(a), len(b)
+	delta := n - m
+	odd := delta%2 != 0
+	offset := (n+m+1)/2 + 1
+
+	// forward[offset+k] is the furthest x reached on diagonal k = x - y from the start. backward[offset+k] is the
+	// same from the end, counting lines back from the end of a and b.
+	forward := make([]int, 2*offset+1)
+	backward := make([]int, 2*offset+1)
+
+	for d := 0; d < offset; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+
+			if k == -d || (k != d && forward[offset+k-1] < forward[offset+k+1]) {
+				x = forward[offset+k+1]
+			} else {
+				x = forward[offset+k-1] + 1
+			}
+
+			y := x - k
+			startX, startY := x, y
+
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+
+			forward[offset+k] = x
+
+			if back := delta - k; odd && back >= -(d-1) && back <= d-1 && x+backward[offset+back] >= n {
+				return startX, startY, x, y
+			}
+		}
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+
+			if k == -d || (k != d && backward[offset+k-1] < backward[offset+k+1]) {
+				x = backward[offset+k+1]
+			} else {
+				x = backward[offset+k-1] + 1
+			}
+
+			y := x - k
+			startX, startY := x, y
+
+			for x < n && y < m && a[n-1-x] == b[m-1-y] {
+				x++
+				y++
+			}
+
+			backward[offset+k] = x
+
+			if ahead := delta - k; !odd && ahead >= -d && ahead <= d && x+forward[offset+ahead] >= n {
+				return n - x, m - y, n - startX, m - startY
+			}
+		}
+	}
+
+	// The searches always meet by the time they've made len(a) + len(b) edits between them.
+	panic("the middle of the edit script wasn't found")
+}
+
+// writeHunks writes the changes of an edit script as unified diff hunks, each with contextLines of context.
+func writeHunks(out io.Writer, edits []edit) {
+	for start := 0; start < len(edits); {
+		if edits[start].operation == equal {
+			start++
+			continue
+		}
+
+		// A hunk runs from the context before a change to the context after the last change that's close enough.
+		first := max(0, start-contextLines)
+		end := start
+
+		for i := start; i < len(edits); i++ {
+			if edits[i].operation != equal {
+				end = i + 1
+			} else if i-end >= 2*contextLines {
+				break
+			}
+		}
+
+		last := min(len(edits), end+contextLines)
+
+		oldStart, newStart := 0, 0
+		for _, e := range edits[:first] {
+			if e.operation != insertion {
+				oldStart++
+			}
+			if e.operation != deletion {
+				newStart++
+			}
+		}
+
+		oldCount, newCount := 0, 0
+		for _, e := range edits[first:last] {
+			if e.operation != insertion {
+				oldCount++
+			}
+			if e.operation != deletion {
+				newCount++
+			}
+		}
+
+		fmt.Fprintf(out, "@@ -%s +%s @@\n", hunkRange(oldStart, oldCount), hunkRange(newStart, newCount))
+
+		for _, e := range edits[first:last] {
+			prefix := map[operation]string{equal: " ", deletion: "-", insertion: "+"}[e.operation]
+			line, hasNewline := strings.CutSuffix(e.line, "\n")
+			fmt.Fprintf(out, "%s%s\n", prefix, line)
+
+			if !hasNewline {
+				fmt.Fprintln(out, `\ No newline at end of file`)
+			}
+		}
+
+		start = last
+	}
+}
+
+// hunkRange is where a hunk starts in one of the files and how many lines it has there. A hunk with no lines starts
+// at the line before it.
+func hunkRange(start int, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
@@ -0,0 +1,212 @@
+package output_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"templ/output"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := map[string]struct {
+		old      string
+		new      string
+		expected string
+	}{
+		"same": {"a\nb\n", "a\nb\n", ""},
+		"changed line": {"a\nb\nc\n", "a\nB\nc\n", `--- old
++++ new
+@@ -1,3 +1,3 @@
+ a
+-b
++B
+ c
+`},
+		"far apart changes get hunks of their own": {"1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n", "x\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\ny\n", `--- old
++++ new
+@@ -1,4 +1,4 @@
+-1
++x
+ 2
+ 3
+ 4
+@@ -9,4 +9,4 @@
+ 9
+ 10
+ 11
+-12
++y
+`},
+		"close changes share a hunk": {"1\n2\n3\n4\n5\n6\n7\n8\n", "x\n2\n3\n4\n5\n6\n7\ny\n", `--- old
++++ new
+@@ -1,8 +1,8 @@
+-1
++x
+ 2
+ 3
+ 4
+ 5
+ 6
+ 7
+-8
++y
+`},
+		"no newline at the end": {"a\nb\n", "a\nb", `--- old
++++ new
+@@ -1,2 +1,2 @@
+ a
+-b
++b
+\ No newline at end of file
+`},
+		"new file": {"", "a\n", `--- old
++++ new
+@@ -0,0 +1 @@
++a
+`},
+		"binary": {"a\x00", "b\x00", "Binary files old and new differ\n"},
+	}
+
+	for name, test := range tests {
+		diff := output.UnifiedDiff("old", "new", []byte(test.old), []byte(test.new))
+
+		if diff != test.expected {
+			t.Errorf("%s: expected\n%s\nreceived\n%s", name, test.expected, diff)
+		}
+	}
+}
+
+// TestUnifiedDiffIsMinimal checks random diffs against the longest common subsequence: the lines a diff removes and
+// adds have to add up to the fewest edits there can be.
+func TestUnifiedDiffIsMinimal(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+
+	randomLines := func() []string {
+		lines := make([]string, random.Intn(40))
+		for i := range lines {
+			lines[i] = string(rune('a'+random.Intn(3))) + "\n"
+		}
+		return lines
+	}
+
+	for i := 0; i < 500; i++ {
+		a, b := randomLines(), randomLines()
+		diff := output.UnifiedDiff("old", "new", []byte(strings.Join(a, "")), []byte(strings.Join(b, "")))
+
+		removed, added := 0, 0
+		for _, line := range strings.Split(diff, "\n") {
+			switch {
+			case strings.HasPrefix(line, "---"), strings.HasPrefix(line, "+++"):
+			case strings.HasPrefix(line, "-"):
+				removed++
+			case strings.HasPrefix(line, "+"):
+				added++
+			}
+		}
+
+		common := longestCommonSubsequence(a, b)
+		if removed != len(a)-common || added != len(b)-common {
+			t.Fatalf("Expected %d removed and %d added lines for %q and %q, got\n%s", len(a)-common, len(b)-common, a, b, diff)
+		}
+	}
+}
+
+func TestUnifiedDiffOfLargeChangesUsesLittleMemory(t *testing.T) {
+	var old, new strings.Builder
+	for i := 0; i < 4000; i++ {
+		fmt.Fprintf(&old, "old %d\n", i)
+		fmt.Fprintf(&new, "new %d\n", i)
+	}
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+
+	diff := output.UnifiedDiff("old", "new", []byte(old.String()), []byte(new.String()))
+
+	runtime.ReadMemStats(&after)
+
+	if lines := strings.Count(diff, "\n"); lines != 2+1+8000 {
+		t.Errorf("Expected one hunk replacing all 4000 lines, got %d lines", lines)
+	}
+
+	// Keeping every row of furthest points would take about a gigabyte.
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 64<<20 {
+		t.Errorf("Expected the diff to take less than 64MB, it took %dMB", allocated>>20)
+	}
+}
+
+func longestCommonSubsequence(a []string, b []string) int {
+	lengths := make([][]int, len(a)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(b)+1)
+	}
+
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			if a[i-1] == b[j-1] {
+				lengths[i][j] = lengths[i-1][j-1] + 1
+			} else {
+				lengths[i][j] = max(lengths[i-1][j], lengths[i][j-1])
+			}
+		}
+	}
+
+	return lengths[len(a)][len(b)]
+}
+
+func TestWriteDiffAndCheck(t *testing.T) {
+	dir := t.TempDir()
+	same := filepath.Join(dir, "same.txt")
+	changed := filepath.Join(dir, "changed.txt")
+	missing := filepath.Join(dir, "missing.txt")
+
+	for path, content := range map[string]string{same: "same\n", changed: "old\n"} {
+		err := os.WriteFile(path, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files := []output.File{
+		{Path: same, Content: []byte("same\n")},
+		{Path: changed, Content: []byte("new\n")},
+		{Path: missing, Content: []byte("new\n")},
+	}
+
+	var diff bytes.Buffer
+	written, err := output.Write(files, output.Options{DiffTo: &diff, Check: true})
+
+	var drift output.DriftErr
+	if !errors.As(err, &drift) || len(drift.Paths) != 2 || drift.Paths[0] != changed || drift.Paths[1] != missing {
+		t.Errorf("Expected changed.txt and missing.txt to have drifted, got %v", err)
+	}
+
+	if written != nil {
+		t.Errorf("Expected nothing to be written, wrote %v", written)
+	}
+
+	expected := "--- " + changed + "\n+++ " + changed + "\n@@ -1 +1 @@\n-old\n+new\n" +
+		"--- " + os.DevNull + "\n+++ " + missing + "\n@@ -0,0 +1 @@\n+new\n"
+	if diff.String() != expected {
+		t.Errorf("Expected\n%s\nreceived\n%s", expected, diff.String())
+	}
+
+	if _, err := os.Stat(missing); !os.IsNotExist(err) {
+		t.Errorf("Expected missing.txt not to be written")
+	}
+
+	if content, _ := os.ReadFile(changed); string(content) != "old\n" {
+		t.Errorf("Expected changed.txt to be left alone, it's <%s>", content)
+	}
+
+	_, err = output.Write(files[:1], output.Options{Check: true})
+	if err != nil {
+		t.Errorf("Expected a file that's the same to pass the check, got %v", err)
+	}
+}
@@ -0,0 +1,136 @@
+package output
+
+import (
+	"slices"
+	"strings"
+)
+
+// MergeLabels name the three versions of a file in the conflict markers of a Merge.
+type MergeLabels struct {
+	Yours  string
+	Base   string
+	Theirs string
+}
+
+// Merge merges the changes between base and theirs into yours, line by line, the way diff3 -m and git merge do, and
+// returns the result and how many conflicts it has. Where yours and theirs both change the same lines of base
+// differently, the result has both versions, with base between them:
+//
+//	<<<<<<< yours
+//	your lines
+//	||||||| base
+//	the lines they both changed
+//	=======
+//	their lines
+//	>>>>>>> theirs
+func Merge(base []byte, yours []byte, theirs []byte, labels MergeLabels) ([]byte, int) {
+	o, a, b := splitLines(string(base)), splitLines(string(yours)), splitLines(string(theirs))
+	toYours, toTheirs := matches(o, a), matches(o, b)
+
+	var merged strings.Builder
+	conflicts := 0
+	i, j, k := 0, 0, 0
+
+	for i < len(o) || j < len(a) || k < len(b) {
+		// Lines that are the same in all three go straight through.
+		stable := 0
+		for i+stable < len(o) && toYours[i+stable] == j+stable && toTheirs[i+stable] == k+stable {
+			stable++
+		}
+
+		if stable > 0 {
+			for _, line := range o[i : i+stable] {
+				merged.WriteString(line)
+			}
+			i, j, k = i+stable, j+stable, k+stable
+			continue
+		}
+
+		// Otherwise there's a change, which runs to the next line of base that's in both yours and theirs.
+		next, nextYours, nextTheirs := len(o), len(a), len(b)
+
+		for n := i; n < len(o); n++ {
+			if toYours[n] >= 0 && toTheirs[n] >= 0 {
+				next, nextYours, nextTheirs = n, toYours[n], toTheirs[n]
+				break
+			}
+		}
+
+		baseChunk, yoursChunk, theirsChunk := o[i:next], a[j:nextYours], b[k:nextTheirs]
+
+		switch {
+		case slices.Equal(yoursChunk, baseChunk) || slices.Equal(yoursChunk, theirsChunk):
+			writeLines(&merged, theirsChunk)
+		case slices.Equal(theirsChunk, baseChunk):
+			writeLines(&merged, yoursChunk)
+		default:
+			conflicts++
+			merged.WriteString("<<<<<<< " + labels.Yours + "\n")
+			writeChunk(&merged, yoursChunk)
+			merged.WriteString("||||||| " + labels.Base + "\n")
+			writeChunk(&merged, baseChunk)
+			merged.WriteString("=======\n")
+			writeChunk(&merged, theirsChunk)
+			merged.WriteString(">>>>>>> " + labels.Theirs + "\n")
+		}
+
+		i, j, k = next, nextYours, nextTheirs
+	}
+
+	return []byte(merged.String()), conflicts
+}
+
+// HasConflictMarkers reports whether content still has the markers of a conflict Merge wrote, which is a line starting
+// with <<<<<<< and one after it starting with >>>>>>>.
+func HasConflictMarkers(content []byte) bool {
+	opened := false
+
+	for _, line := range splitLines(string(content)) {
+		switch {
+		case strings.HasPrefix(line, "<<<<<<< "):
+			opened = true
+		case opened && strings.HasPrefix(line, ">>>>>>> "):
+			return true
+		}
+	}
+
+	return false
+}
+
+// matches maps each line of a to the line of b it's kept as, or -1 if it's deleted.
+func matches(a []string, b []string) []int {
+	matched := make([]int, len(a))
+	x, y := 0, 0
+
+	for _, e := range myers(a, b) {
+		switch e.operation {
+		case equal:
+			matched[x] = y
+			x++
+			y++
+		case deletion:
+			matched[x] = -1
+			x++
+		case insertion:
+			y++
+		}
+	}
+
+	return matched
+}
+
+func writeLines(out *strings.Builder, lines []string) {
+	for _, line := range lines {
+		out.WriteString(line)
+	}
+}
+
+// writeChunk writes one side of a conflict, ending it with a newline so that the marker after it is on a line of its
+// own.
+func writeChunk(out *strings.Builder, lines []string) {
+	writeLines(out, lines)
+
+	if len(lines) > 0 && !strings.HasSuffix(lines[len(lines)-1], "\n") {
+		out.WriteString("\n")
+	}
+}
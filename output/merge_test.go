@@ -0,0 +1,73 @@
+package output_test
+
+import (
+	"templ/output"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	labels := output.MergeLabels{Yours: "yours", Base: "old template", Theirs: "new template"}
+
+	tests := map[string]struct {
+		base      string
+		yours     string
+		theirs    string
+		expected  string
+		conflicts int
+	}{
+		"nothing changed":     {"a\nb\n", "a\nb\n", "a\nb\n", "a\nb\n", 0},
+		"only theirs changed": {"a\nb\nc\n", "a\nb\nc\n", "a\nB\nc\n", "a\nB\nc\n", 0},
+		"only yours changed":  {"a\nb\nc\n", "a\nB\nc\n", "a\nb\nc\n", "a\nB\nc\n", 0},
+		"both changed different lines": {
+			"1\n2\n3\n4\n5\n", "one\n2\n3\n4\n5\n", "1\n2\n3\n4\nfive\n",
+			"one\n2\n3\n4\nfive\n", 0,
+		},
+		"both made the same change": {"a\nb\n", "a\nB\n", "a\nB\n", "a\nB\n", 0},
+		"both added lines in different places": {
+			"a\nb\nc\n", "a\nyours\nb\nc\n", "a\nb\nc\ntheirs\n",
+			"a\nyours\nb\nc\ntheirs\n", 0,
+		},
+		"theirs deleted a line you left alone": {"a\nb\nc\n", "A\nb\nc\n", "a\nb\n", "A\nb\n", 0},
+		"both changed the same line": {
+			"a\nb\nc\n", "a\nyours\nc\n", "a\ntheirs\nc\n",
+			"a\n<<<<<<< yours\nyours\n||||||| old template\nb\n=======\ntheirs\n>>>>>>> new template\nc\n", 1,
+		},
+		"two conflicts": {
+			"1\n2\n3\n4\n5\n", "x\n2\n3\n4\nx\n", "y\n2\n3\n4\ny\n",
+			"<<<<<<< yours\nx\n||||||| old template\n1\n=======\ny\n>>>>>>> new template\n2\n3\n4\n" +
+				"<<<<<<< yours\nx\n||||||| old template\n5\n=======\ny\n>>>>>>> new template\n", 2,
+		},
+		"no base": {
+			"", "yours\n", "theirs\n",
+			"<<<<<<< yours\nyours\n||||||| old template\n=======\ntheirs\n>>>>>>> new template\n", 1,
+		},
+		"no newline at the end": {
+			"a\nb", "a\nyours", "a\ntheirs",
+			"a\n<<<<<<< yours\nyours\n||||||| old template\nb\n=======\ntheirs\n>>>>>>> new template\n", 1,
+		},
+	}
+
+	for name, test := range tests {
+		merged, conflicts := output.Merge([]byte(test.base), []byte(test.yours), []byte(test.theirs), labels)
+
+		if string(merged) != test.expected || conflicts != test.conflicts {
+			t.Errorf("%s: expected %d conflicts in\n%s\nreceived %d in\n%s", name, test.conflicts, test.expected, conflicts, merged)
+		}
+	}
+}
+
+func TestHasConflictMarkers(t *testing.T) {
+	tests := map[string]bool{
+		"a\nb\n": false,
+		"a\n<<<<<<< yours\nb\n||||||| base\n=======\nc\n>>>>>>> theirs\n": true,
+		"<<<<<<< only the start\n":                    false,
+		">>>>>>> before\n<<<<<<< after\n":             false,
+		"quoting <<<<<<< yours\nand >>>>>>> theirs\n": false,
+	}
+
+	for content, expected := range tests {
+		if output.HasConflictMarkers([]byte(content)) != expected {
+			t.Errorf("Expected %t for <%s>", expected, content)
+		}
+	}
+}
@@ -0,0 +1,325 @@
+package output
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Policy says what to do about a file that's already where rendered output is going.
+type Policy string
+
+const (
+	// Fail refuses to write anything if any of the files is already there. It's what an empty Policy means.
+	Fail Policy = "fail"
+	// Skip leaves files that are already there as they are, and writes the rest.
+	Skip Policy = "skip"
+	// Overwrite replaces files that are already there.
+	Overwrite Policy = "overwrite"
+	// Backup moves a file that's already there to the same name with .orig on the end, then writes the new one.
+	Backup Policy = "backup"
+	// Prompt asks, for each file that's already there, whether to overwrite it.
+	Prompt Policy = "prompt"
+)
+
+// Policies are the names of every Policy.
+var Policies = []string{string(Fail), string(Skip), string(Overwrite), string(Backup), string(Prompt)}
+
+// BackupSuffix is put on the end of a file's name to back it up. An older backup is replaced.
+const BackupSuffix = ".orig"
+
+// ParsePolicy checks that a policy is one of Policies.
+func ParsePolicy(policy string) (Policy, error) {
+	if !slices.Contains(Policies, policy) {
+		return "", fmt.Errorf("unknown policy %s, expected one of %s", policy, strings.Join(Policies, ", "))
+	}
+	return Policy(policy), nil
+}
+
+// Options say how files are written.
+type Options struct {
+	Policy Policy
+	// KeepMode gives each file the permission bits of the template it was rendered from, so that a script stays
+	// executable. Without it, new files are 0644 and files that are replaced keep the mode they had.
+	KeepMode bool
+	// Ask asks whether to overwrite a file, for the Prompt policy.
+	Ask func(path string) (bool, error)
+	// DiffTo, when it's set, gets a unified diff of each file against what's there now, instead of anything being
+	// written.
+	DiffTo io.Writer
+	// Check writes nothing, and fails with a DriftErr if any file isn't the same as what's there now.
+	Check bool
+}
+
+// File is rendered output, ready to write.
+type File struct {
+	Path    string
+	Content []byte
+	// Mode is the mode of the template the file was rendered from.
+	Mode fs.FileMode
+	// Link is the target of a symbolic link to make instead of a file.
+	Link string
+}
+
+// ExistsErr is files that are already there, which the Fail policy won't write over.
+type ExistsErr struct {
+	Paths []string
+}
+
+func (e ExistsErr) Error() string {
+	return fmt.Sprintf("templ won't overwrite files that are already there: %s. Pick another -policy to change that", strings.Join(e.Paths, ", "))
+}
+
+func (e ExistsErr) Is(target error) bool {
+	_, ok := target.(ExistsErr)
+	return ok
+}
+
+// DriftErr is files that aren't what their templates render, found by Options.Check.
+type DriftErr struct {
+	Paths []string
+}
+
+func (e DriftErr) Error() string {
+	return fmt.Sprintf("these files aren't what their templates render: %s", strings.Join(e.Paths, ", "))
+}
+
+func (e DriftErr) Is(target error) bool {
+	_, ok := target.(DriftErr)
+	return ok
+}
+
+// Write writes files, making the directories they're in, and returns the paths it wrote. Each file is written to a
+// temporary file next to it, which is then renamed into place, so nothing ever sees a file half written. What happens
+// to files that are already there is up to options.Policy; every question the Prompt policy asks is asked before
+// anything is written. With options.DiffTo or options.Check, nothing is written and files are compared instead; see
+// compare.
+func Write(files []File, options Options) ([]string, error) {
+	if options.DiffTo != nil || options.Check {
+		return nil, compare(files, options)
+	}
+
+	var existing []string
+	overwrite := make(map[string]bool)
+
+	for _, file := range files {
+		if _, err := os.Lstat(file.Path); err != nil {
+			continue
+		}
+
+		switch options.Policy {
+		case "", Fail:
+			existing = append(existing, file.Path)
+		case Overwrite, Backup:
+			overwrite[file.Path] = true
+		case Prompt:
+			if options.Ask == nil {
+				_, f, line, _ := runtime.Caller(0)
+				return nil, fmt.Errorf("%s:%d: nothing to ask whether to overwrite %s with", f, line, file.Path)
+			}
+
+			yes, err := options.Ask(file.Path)
+
+			if err != nil {
+				_, f, line, _ := runtime.Caller(0)
+				return nil, fmt.Errorf("%s:%d: %v", f, line, err)
+			}
+
+			overwrite[file.Path] = yes
+		}
+	}
+
+	if len(existing) > 0 {
+		return nil, ExistsErr{Paths: existing}
+	}
+
+	var written []string
+
+	for _, file := range files {
+		previous, err := os.Lstat(file.Path)
+		exists := err == nil
+
+		if exists && !overwrite[file.Path] {
+			logrus.Info("Leaving ", file.Path, " as it is")
+			continue
+		}
+
+		err = os.MkdirAll(filepath.Dir(file.Path), 0755)
+
+		if err != nil {
+			_, f, line, _ := runtime.Caller(0)
+			return written, fmt.Errorf("%s:%d: %v", f, line, err)
+		}
+
+		if exists && options.Policy == Backup {
+			err = os.Rename(file.Path, file.Path+BackupSuffix)
+
+			if err != nil {
+				_, f, line, _ := runtime.Caller(0)
+				return written, fmt.Errorf("%s:%d: %v", f, line, err)
+			}
+		}
+
+		mode := fs.FileMode(0644)
+
+		switch {
+		case options.KeepMode && file.Mode != 0:
+			mode = file.Mode.Perm()
+		case exists && previous.Mode().IsRegular():
+			mode = previous.Mode().Perm()
+		}
+
+		if file.Link != "" {
+			err = replaceWithLink(file.Path, file.Link)
+		} else {
+			err = replaceWithFile(file.Path, file.Content, mode)
+		}
+
+		if err != nil {
+			_, f, line, _ := runtime.Caller(0)
+			return written, fmt.Errorf("%s:%d: %v", f, line, err)
+		}
+
+		written = append(written, file.Path)
+	}
+
+	return written, nil
+}
+
+// replaceWithFile writes content to a temporary file beside path and renames it to path.
+func replaceWithFile(path string, content []byte, mode fs.FileMode) error {
+	temporary, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".templ-*")
+
+	if err != nil {
+		return err
+	}
+
+	// Once it's renamed, there's nothing left to remove.
+	defer os.Remove(temporary.Name())
+
+	_, err = temporary.Write(content)
+
+	if err == nil {
+		// The temporary file was made 0600, and gets its mode before anything can see it.
+		err = temporary.Chmod(mode)
+	}
+
+	if err == nil {
+		err = temporary.Sync()
+	}
+
+	if closeErr := temporary.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(temporary.Name(), path)
+}
+
+// replaceWithLink makes a symbolic link beside path and renames it to path.
+func replaceWithLink(path string, target string) error {
+	temporary, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".templ-*")
+
+	if err != nil {
+		return err
+	}
+
+	name := temporary.Name()
+	temporary.Close()
+	os.Remove(name)
+
+	err = os.Symlink(target, name)
+
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(name, path)
+
+	if err != nil {
+		os.Remove(name)
+	}
+
+	return err
+}
+
+// compare compares files with what's there now. Each one that's different has its diff written to options.DiffTo, and
+// it's a DriftErr if options.Check is set. A file's mode only counts with options.KeepMode, and if it has one.
+func compare(files []File, options Options) error {
+	var drifted []string
+
+	for _, file := range files {
+		oldPath, old, oldMode, err := current(file.Path)
+
+		if err != nil {
+			_, f, line, _ := runtime.Caller(0)
+			return fmt.Errorf("%s:%d: %v", f, line, err)
+		}
+
+		newContent, newMode := file.Content, file.Mode.Perm()
+
+		if file.Link != "" {
+			newContent, newMode = []byte(file.Link), fs.ModeSymlink
+		}
+
+		diff := UnifiedDiff(oldPath, file.Path, old, newContent)
+		modeChanged := options.KeepMode && file.Mode != 0 && oldPath != os.DevNull && oldMode != newMode
+
+		if diff == "" && !modeChanged {
+			continue
+		}
+
+		drifted = append(drifted, file.Path)
+
+		if options.DiffTo == nil {
+			continue
+		}
+
+		if modeChanged {
+			fmt.Fprintf(options.DiffTo, "%s: mode %v changes to %v\n", file.Path, oldMode, newMode)
+		}
+
+		if diff != "" {
+			fmt.Fprint(options.DiffTo, diff)
+		}
+	}
+
+	if options.Check && len(drifted) > 0 {
+		return DriftErr{Paths: drifted}
+	}
+
+	return nil
+}
+
+// current reads what's at path now: the contents of a file, or the target of a symbolic link. A path that isn't there
+// is /dev/null, and empty.
+func current(path string) (string, []byte, fs.FileMode, error) {
+	info, err := os.Lstat(path)
+
+	if os.IsNotExist(err) {
+		return os.DevNull, nil, 0, nil
+	}
+
+	if err != nil {
+		return "", nil, 0, err
+	}
+
+	if info.Mode()&fs.ModeSymlink != 0 {
+		target, err := os.Readlink(path)
+		return path, []byte(target), fs.ModeSymlink, err
+	}
+
+	content, err := os.ReadFile(path)
+
+	return path, content, info.Mode().Perm(), err
+}
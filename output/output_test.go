@@ -0,0 +1,138 @@
+package output_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"templ/output"
+	"testing"
+)
+
+func TestWritePolicies(t *testing.T) {
+	tests := map[output.Policy]struct {
+		answer   bool
+		written  bool
+		content  string
+		expected error
+	}{
+		output.Fail:      {content: "old", expected: output.ExistsErr{}},
+		output.Skip:      {content: "old"},
+		output.Overwrite: {written: true, content: "new"},
+		output.Backup:    {written: true, content: "new"},
+		output.Prompt:    {answer: true, written: true, content: "new"},
+	}
+
+	for policy, test := range tests {
+		dir := t.TempDir()
+		existing := filepath.Join(dir, "existing.txt")
+		fresh := filepath.Join(dir, "nested", "fresh.txt")
+
+		err := os.WriteFile(existing, []byte("old"), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var asked []string
+		options := output.Options{Policy: policy, Ask: func(path string) (bool, error) {
+			asked = append(asked, path)
+			return test.answer, nil
+		}}
+
+		written, err := output.Write([]output.File{{Path: existing, Content: []byte("new")}, {Path: fresh, Content: []byte("fresh")}}, options)
+
+		if test.expected != nil {
+			if !errors.Is(err, test.expected) {
+				t.Errorf("%s: expected %v, got %v", policy, test.expected, err)
+			}
+
+			if _, err := os.Stat(fresh); !os.IsNotExist(err) {
+				t.Errorf("%s: expected nothing to be written", policy)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("%s: %v", policy, err)
+		}
+
+		expected := []string{fresh}
+		if test.written {
+			expected = []string{existing, fresh}
+		}
+
+		if !reflect.DeepEqual(written, expected) {
+			t.Errorf("%s: expected to write <%v>, wrote <%v>", policy, expected, written)
+		}
+
+		content, _ := os.ReadFile(existing)
+		if string(content) != test.content {
+			t.Errorf("%s: expected <%s>, received <%s>", policy, test.content, content)
+		}
+
+		// A file that's replaced keeps its mode.
+		if info, _ := os.Stat(existing); info.Mode().Perm() != 0600 {
+			t.Errorf("%s: expected the file to stay 0600, it's %v", policy, info.Mode().Perm())
+		}
+
+		backup, err := os.ReadFile(existing + output.BackupSuffix)
+		if policy == output.Backup && string(backup) != "old" {
+			t.Errorf("%s: expected a backup of the old file, got <%s>, %v", policy, backup, err)
+		}
+
+		if policy == output.Prompt && !reflect.DeepEqual(asked, []string{existing}) {
+			t.Errorf("%s: expected to be asked about <%s>, asked about <%v>", policy, existing, asked)
+		}
+
+		entries, _ := os.ReadDir(dir)
+		for _, entry := range entries {
+			if filepath.Ext(entry.Name()) != ".txt" && entry.Name() != "nested" && entry.Name() != "existing.txt"+output.BackupSuffix {
+				t.Errorf("%s: a temporary file was left behind: %s", policy, entry.Name())
+			}
+		}
+	}
+}
+
+func TestWriteKeepsModes(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "run.sh")
+	plain := filepath.Join(dir, "plain.sh")
+	link := filepath.Join(dir, "link.sh")
+
+	files := []output.File{
+		{Path: script, Content: []byte("#!/bin/sh\n"), Mode: 0755},
+		{Path: link, Link: "run.sh"},
+	}
+
+	_, err := output.Write(files, output.Options{KeepMode: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = output.Write([]output.File{{Path: plain, Content: []byte("#!/bin/sh\n"), Mode: 0755}}, output.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info, err := os.Stat(script); err != nil || info.Mode().Perm() != 0755 {
+		t.Errorf("Expected run.sh to be 0755, got %v, %v", info.Mode(), err)
+	}
+
+	if info, err := os.Stat(plain); err != nil || info.Mode().Perm() != 0644 {
+		t.Errorf("Expected plain.sh to be 0644 without KeepMode, got %v, %v", info.Mode(), err)
+	}
+
+	if target, err := os.Readlink(link); err != nil || target != "run.sh" {
+		t.Errorf("Expected link.sh to link to run.sh, got <%s>, %v", target, err)
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	if policy, err := output.ParsePolicy("backup"); err != nil || policy != output.Backup {
+		t.Errorf("Expected backup, got %v, %v", policy, err)
+	}
+
+	if _, err := output.ParsePolicy("clobber"); err == nil {
+		t.Errorf("Expected an unknown policy to be refused")
+	}
+}
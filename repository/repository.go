@@ -4,11 +4,17 @@ import (
 	"errors"
 	"fmt"
 	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/sirupsen/logrus"
+	"io"
 	"os"
 	"path"
+	"path/filepath"
 	"regexp"
 	"runtime"
+	"strings"
 	"templ/configelements"
 )
 
@@ -189,6 +195,224 @@ func NewLocalGitRepository(upstream string) (CommonRepositoryBehaviour, error) {
 	return repo, nil
 }
 
+// OpenRepository opens a repository that's already been cloned to destination. Its Upstream is the url of its origin
+// remote, and is empty for a repository that wasn't cloned from anywhere. Its RepoType is the directory of the templ
+// directory it's in, like github or local.
+func OpenRepository(destination string) (Repository, error) {
+	gitRepo, err := git.PlainOpen(destination)
+
+	if err != nil {
+		_, file, line, _ := runtime.Caller(0)
+		return Repository{}, fmt.Errorf("%s:%d: %s: %w", file, line, destination, err)
+	}
+
+	repo := Repository{Destination: destination}
+
+	remote, err := gitRepo.Remote(git.DefaultRemoteName)
+
+	if err != nil && !errors.Is(err, git.ErrRemoteNotFound) {
+		_, file, line, _ := runtime.Caller(0)
+		return Repository{}, fmt.Errorf("%s:%d: %v", file, line, err)
+	}
+
+	if err == nil && len(remote.Config().URLs) > 0 {
+		repo.Upstream = remote.Config().URLs[0]
+	}
+
+	t := configelements.NewTemplDir()
+	relative, err := filepath.Rel(t.TemplatesDir, destination)
+
+	if err == nil && filepath.IsLocal(relative) {
+		repo.RepoType, _, _ = strings.Cut(filepath.ToSlash(relative), "/")
+	}
+
+	return repo, nil
+}
+
+// Commit returns the hash of the commit the repository has checked out.
+func (r Repository) Commit() (string, error) {
+	gitRepo, err := git.PlainOpen(r.Destination)
+
+	if err != nil {
+		_, file, line, _ := runtime.Caller(0)
+		return "", fmt.Errorf("%s:%d: %v", file, line, err)
+	}
+
+	head, err := gitRepo.Head()
+
+	if err != nil {
+		_, file, line, _ := runtime.Caller(0)
+		return "", fmt.Errorf("%s:%d: %s: %v", file, line, r.Destination, err)
+	}
+
+	return head.Hash().String(), nil
+}
+
+// ErrUnknownRevision is a commit, or anything else git can name a commit by, that a repository doesn't have.
+type ErrUnknownRevision struct {
+	ErrorMessage string
+}
+
+func (e ErrUnknownRevision) Error() string {
+	return e.ErrorMessage
+}
+
+func (e ErrUnknownRevision) Is(target error) bool {
+	_, ok := target.(ErrUnknownRevision)
+	return ok
+}
+
+// Resolve returns the hash of the commit a revision names: a tag, a branch, a commit's hash, or anything else git can
+// name a commit by. A branch is looked for among the repository's remote branches too, since a clone only has a local
+// branch for what it checked out.
+func (r Repository) Resolve(revision string) (string, error) {
+	gitRepo, err := git.PlainOpen(r.Destination)
+
+	if err != nil {
+		_, file, line, _ := runtime.Caller(0)
+		return "", fmt.Errorf("%s:%d: %v", file, line, err)
+	}
+
+	hash, err := gitRepo.ResolveRevision(plumbing.Revision(revision))
+
+	if err != nil {
+		hash, err = gitRepo.ResolveRevision(plumbing.Revision(git.DefaultRemoteName + "/" + revision))
+	}
+
+	if err != nil {
+		return "", ErrUnknownRevision{ErrorMessage: fmt.Sprintf("%s doesn't have %s", r.Destination, revision)}
+	}
+
+	return hash.String(), nil
+}
+
+// Export writes the files of a commit to dir, straight from the repository's objects, so its worktree is left as it
+// is. The revision is anything Resolve takes. Executable files stay executable, symbolic links are made as links and
+// submodules are left out.
+func (r Repository) Export(revision string, dir string) error {
+	files, err := r.files(revision)
+
+	if err != nil {
+		return err
+	}
+
+	err = files.ForEach(func(f *object.File) error {
+		path := filepath.Join(dir, filepath.FromSlash(f.Name))
+
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+
+		if err != nil {
+			return err
+		}
+
+		if f.Mode == filemode.Symlink {
+			target, err := f.Contents()
+
+			if err != nil {
+				return err
+			}
+
+			return os.Symlink(target, path)
+		}
+
+		mode := os.FileMode(0644)
+
+		if f.Mode == filemode.Executable {
+			mode = 0755
+		}
+
+		return exportFile(f, path, mode)
+	})
+
+	if err != nil {
+		_, file, line, _ := runtime.Caller(0)
+		return fmt.Errorf("%s:%d: %v", file, line, err)
+	}
+
+	return nil
+}
+
+// Paths returns the paths of the files of a commit, with slashes, read from the repository's objects like Export
+// does. The revision is anything Resolve takes.
+func (r Repository) Paths(revision string) ([]string, error) {
+	files, err := r.files(revision)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+
+	err = files.ForEach(func(f *object.File) error {
+		paths = append(paths, f.Name)
+		return nil
+	})
+
+	if err != nil {
+		_, file, line, _ := runtime.Caller(0)
+		return nil, fmt.Errorf("%s:%d: %v", file, line, err)
+	}
+
+	return paths, nil
+}
+
+// files returns the files of the commit a revision names.
+func (r Repository) files(revision string) (*object.FileIter, error) {
+	hash, err := r.Resolve(revision)
+
+	if err != nil {
+		return nil, err
+	}
+
+	gitRepo, err := git.PlainOpen(r.Destination)
+
+	if err != nil {
+		_, file, line, _ := runtime.Caller(0)
+		return nil, fmt.Errorf("%s:%d: %v", file, line, err)
+	}
+
+	commit, err := gitRepo.CommitObject(plumbing.NewHash(hash))
+
+	if err != nil {
+		_, file, line, _ := runtime.Caller(0)
+		return nil, fmt.Errorf("%s:%d: %v", file, line, err)
+	}
+
+	files, err := commit.Files()
+
+	if err != nil {
+		_, file, line, _ := runtime.Caller(0)
+		return nil, fmt.Errorf("%s:%d: %v", file, line, err)
+	}
+
+	return files, nil
+}
+
+// exportFile writes a file of a commit to path.
+func exportFile(f *object.File, path string, mode os.FileMode) error {
+	reader, err := f.Reader()
+
+	if err != nil {
+		return err
+	}
+
+	defer reader.Close()
+
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, reader)
+
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
 // Fetch implements the CommonRepositoryBehaviour interface for Repository.
 func (r Repository) Fetch() error {
 
@@ -4,9 +4,16 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"reflect"
 	"templ/repository"
 	"templ/test_helpers"
 	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing/object"
 )
 
 func TestRepositoryConstructorWithEmptyUrl(t *testing.T) {
@@ -107,6 +114,205 @@ func TestGithubFetchWithInvalidUrl(t *testing.T) {
 
 }
 
+func TestOpenRepository(t *testing.T) {
+	tempDir := setupTemplDir("templ-open-repository", t)
+	defer test_helpers.CleanUpTemplDir(tempDir, t)
+
+	destination := filepath.Join(tempDir, "github", "PlayTechnique", "templ_templates")
+	hash := commitFile(destination, "README.md", t)
+
+	gitRepo, err := git.PlainOpen(destination)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = gitRepo.CreateRemote(&config.RemoteConfig{Name: "origin", URLs: []string{"https://github.com/PlayTechnique/templ_templates.git"}})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo, err := repository.OpenRepository(destination)
+
+	if err != nil {
+		t.Fatalf("couldn't open %s: %v", destination, err)
+	}
+
+	if repo.Upstream != "https://github.com/PlayTechnique/templ_templates.git" {
+		t.Errorf("expected the origin remote as the upstream, got %q", repo.Upstream)
+	}
+
+	if repo.RepoType != "github" {
+		t.Errorf("expected a github repository, got %q", repo.RepoType)
+	}
+
+	commit, err := repo.Commit()
+
+	if err != nil || commit != hash {
+		t.Errorf("expected commit %s, got %s and %v", hash, commit, err)
+	}
+}
+
+func TestOpenRepositoryWithoutOrigin(t *testing.T) {
+	tempDir := setupTemplDir("templ-open-repository-without-origin", t)
+	defer test_helpers.CleanUpTemplDir(tempDir, t)
+
+	destination := filepath.Join(tempDir, "local", "scratch")
+	commitFile(destination, "template.txt", t)
+
+	repo, err := repository.OpenRepository(destination)
+
+	if err != nil {
+		t.Fatalf("couldn't open %s: %v", destination, err)
+	}
+
+	if repo.Upstream != "" || repo.RepoType != "local" {
+		t.Errorf("expected a local repository with no upstream, got %+v", repo)
+	}
+}
+
+func TestOpenRepositoryThatIsNotOne(t *testing.T) {
+	tempDir := setupTemplDir("templ-open-repository-not-a-repository", t)
+	defer test_helpers.CleanUpTemplDir(tempDir, t)
+
+	_, err := repository.OpenRepository(tempDir)
+
+	if !errors.Is(err, git.ErrRepositoryNotExists) {
+		t.Errorf("expected ErrRepositoryNotExists, got %v", err)
+	}
+}
+
+func TestExport(t *testing.T) {
+	tempDir := setupTemplDir("templ-export", t)
+	defer test_helpers.CleanUpTemplDir(tempDir, t)
+
+	destination := filepath.Join(tempDir, "local", "scratch")
+	first := commitFile(destination, "template.txt", t)
+
+	err := os.WriteFile(filepath.Join(destination, "template.txt"), []byte("changed\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo, err := repository.OpenRepository(destination)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exported := filepath.Join(tempDir, "exported")
+	err = repo.Export(first, exported)
+	if err != nil {
+		t.Fatalf("couldn't export %s: %v", first, err)
+	}
+
+	content, err := os.ReadFile(filepath.Join(exported, "template.txt"))
+	if err != nil || string(content) != "hello\n" {
+		t.Errorf("expected the committed file, got %q and %v", content, err)
+	}
+
+	content, err = os.ReadFile(filepath.Join(destination, "template.txt"))
+	if err != nil || string(content) != "changed\n" {
+		t.Errorf("expected the worktree to be left alone, got %q and %v", content, err)
+	}
+
+	err = repo.Export("0123456789abcdef0123456789abcdef01234567", filepath.Join(tempDir, "nothing"))
+	if !errors.Is(err, repository.ErrUnknownRevision{}) {
+		t.Errorf("expected ErrUnknownRevision, got %v", err)
+	}
+}
+
+func TestPaths(t *testing.T) {
+	tempDir := setupTemplDir("templ-paths", t)
+	defer test_helpers.CleanUpTemplDir(tempDir, t)
+
+	destination := filepath.Join(tempDir, "local", "scratch")
+	first := commitFile(destination, "template.txt", t)
+
+	err := os.WriteFile(filepath.Join(destination, "uncommitted.txt"), []byte("not yet\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo, err := repository.OpenRepository(destination)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, revision := range []string{first, "HEAD"} {
+		paths, err := repo.Paths(revision)
+		if err != nil || !reflect.DeepEqual(paths, []string{"template.txt"}) {
+			t.Errorf("expected only the committed file at %s, got %v and %v", revision, paths, err)
+		}
+	}
+
+	_, err = repo.Paths("v9")
+	if !errors.Is(err, repository.ErrUnknownRevision{}) {
+		t.Errorf("expected ErrUnknownRevision, got %v", err)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	tempDir := setupTemplDir("templ-resolve", t)
+	defer test_helpers.CleanUpTemplDir(tempDir, t)
+
+	destination := filepath.Join(tempDir, "local", "scratch")
+	hash := commitFile(destination, "template.txt", t)
+
+	repo, err := repository.OpenRepository(destination)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, revision := range []string{"HEAD", "master", hash} {
+		resolved, err := repo.Resolve(revision)
+
+		if err != nil || resolved != hash {
+			t.Errorf("expected %s to resolve to %s, got %s and %v", revision, hash, resolved, err)
+		}
+	}
+
+	_, err = repo.Resolve("v9")
+	if !errors.Is(err, repository.ErrUnknownRevision{}) {
+		t.Errorf("expected ErrUnknownRevision, got %v", err)
+	}
+}
+
+// commitFile makes a git repository at dir with one commit of one file, and returns the commit's hash.
+func commitFile(dir string, name string, t *testing.T) string {
+	gitRepo, err := git.PlainInit(dir, false)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(filepath.Join(dir, name), []byte("hello\n"), 0644)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	worktree, err := gitRepo.Worktree()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = worktree.Add(name)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash, err := worktree.Commit("first", &git.CommitOptions{Author: &object.Signature{Name: "templ", Email: "templ@example.com", When: time.Now()}})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return hash.String()
+}
+
 //func TestGithubFetchWithValidUrl(t *testing.T) {
 //
 //}
@@ -0,0 +1,68 @@
+package templatedirectories
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// HelpersDirectory is a directory whose files are all helper files.
+const HelpersDirectory = "_helpers"
+
+// IsHelperFile reports whether a template file is one of its repository's helper files: a file named like
+// _something.tmpl, or any file in a _helpers directory. Helper files hold {{ define }}s for the repository's templates
+// to share, and aren't templates to render themselves.
+func IsHelperFile(path string) bool {
+	name := filepath.Base(path)
+
+	if strings.HasPrefix(name, "_") && filepath.Ext(name) == ".tmpl" {
+		return true
+	}
+
+	for _, directory := range strings.Split(filepath.ToSlash(filepath.Dir(path)), "/") {
+		if directory == HelpersDirectory {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Helpers lists the helper files of the repository at repositoryDir, sorted. Hidden directories, like .git, are skipped.
+func Helpers(repositoryDir string) ([]string, error) {
+	helpers := []string{}
+
+	err := filepath.Walk(repositoryDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() && path != repositoryDir && strings.HasPrefix(info.Name(), ".") {
+			return filepath.SkipDir
+		}
+
+		relative, err := filepath.Rel(repositoryDir, path)
+
+		if err != nil {
+			return err
+		}
+
+		if !info.IsDir() && IsHelperFile(relative) {
+			helpers = append(helpers, path)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		_, file, line, _ := runtime.Caller(0)
+		return nil, fmt.Errorf("%s:%d: %v", file, line, err)
+	}
+
+	sort.Strings(helpers)
+
+	return helpers, nil
+}
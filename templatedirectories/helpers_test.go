@@ -0,0 +1,67 @@
+package templatedirectories_test
+
+import (
+	"path/filepath"
+	"reflect"
+	"templ/templatedirectories"
+	"templ/test_helpers"
+	"testing"
+)
+
+func TestIsHelperFile(t *testing.T) {
+	tests := map[string]bool{
+		"_labels.tmpl":               true,
+		"k8s/_names.tmpl":            true,
+		"_helpers/labels.yaml":       true,
+		"k8s/_helpers/deep/x.yaml":   true,
+		"labels.tmpl":                false,
+		"_labels.yaml":               false,
+		"k8s/helpers/labels.yaml":    false,
+		"k8s/_helpers_old/x.yaml":    false,
+		"k8s/deployment_helper.tmpl": false,
+	}
+
+	for path, expected := range tests {
+		if helper := templatedirectories.IsHelperFile(path); helper != expected {
+			t.Errorf("Expected IsHelperFile(%s) to be %v, got %v", path, expected, helper)
+		}
+	}
+}
+
+func TestHelpers(t *testing.T) {
+	repository := t.TempDir()
+
+	err := test_helpers.WriteFiles(repository, map[string]string{
+		"_labels.tmpl":            "",
+		"k8s/_names.tmpl":         "",
+		"_helpers/a.yaml":         "",
+		"k8s/deployment.yaml":     "",
+		".git/_not-a-helper.tmpl": "",
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	helpers, err := templatedirectories.Helpers(repository)
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	expected := []string{
+		filepath.Join(repository, "_helpers/a.yaml"),
+		filepath.Join(repository, "_labels.tmpl"),
+		filepath.Join(repository, "k8s/_names.tmpl"),
+	}
+
+	if !reflect.DeepEqual(helpers, expected) {
+		t.Errorf("Expected <%v>, got <%v>", expected, helpers)
+	}
+
+	_, err = templatedirectories.Helpers(filepath.Join(repository, "missing"))
+
+	if err == nil {
+		t.Errorf("Expected an error for a repository that isn't there")
+	}
+}
@@ -13,7 +13,8 @@ import (
 )
 
 // List lists the template files in the templates directory.
-// It does not descend into hidden directories; it does not return hidden files;
+// It does not descend into hidden directories; it does not return hidden files; it does not return helper files (see
+// IsHelperFile), which are never rendered on their own.
 func List() ([]string, error) {
 	allFileNames := []string{}
 	templDir := configelements.NewTemplDir().TemplatesDir
@@ -45,7 +46,7 @@ func List() ([]string, error) {
 			return err
 		}
 
-		if !info.IsDir() {
+		if !info.IsDir() && !IsHelperFile(filename) {
 			allFileNames = append(allFileNames, filename)
 		}
 
@@ -91,3 +91,25 @@ func TestListWithTemplateFiles(t *testing.T) {
 		t.Errorf("Expected <%v>, got <%v>", createFiles, files)
 	}
 }
+
+func TestListDoesNotFindHelperFiles(t *testing.T) {
+	createFiles := []string{"repo/_labels.tmpl", "repo/_helpers/names.yaml", "repo/k8s/deployment.yaml"}
+	templDir, err := test_helpers.CreateFileSystem(createFiles)
+
+	if err != nil {
+		t.Errorf("%v", err)
+	}
+
+	defer test_helpers.CleanUpTemplDir(templDir, t)
+
+	files, err := templatedirectories.List()
+
+	if err != nil {
+		t.Errorf("%v", err)
+	}
+
+	expectedFiles := []string{"repo/k8s/deployment.yaml"}
+	if !reflect.DeepEqual(files, expectedFiles) {
+		t.Errorf("Expected <%v>, got <%v>", expectedFiles, files)
+	}
+}
@@ -0,0 +1,177 @@
+package templates
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// DefinitionTypes are the types a KEY:type=VALUE definition can ask for.
+var DefinitionTypes = []string{"string", "int", "float", "bool", "list", "json"}
+
+// convertFromArrayToKeymap turns KEY=VALUE definitions from the command line into a variables tree. The grammar is:
+// - the definition is split on its first '=' only, so URL=https://x?a=b keeps the whole url;
+// - a dotted key builds nested maps: db.host=x sets .db.host;
+// - a value in square brackets is a list of strings: ports=[80,443];
+// - KEY:type=VALUE converts the value, or each item of a list, to one of DefinitionTypes: replicas:int=3,
+// ports:int=[80,443], flags:json={"a":1};
+// - every definition after a "--" argument is taken literally: the key is used as it is and the value is a string,
+// so odd=[not a list] stays a string.
+// Later definitions win over earlier ones.
+func convertFromArrayToKeymap(input []string) (map[string]interface{}, error) {
+	k := make(map[string]interface{})
+	literal := false
+
+	for _, arg := range input {
+		if arg == "--" && !literal {
+			literal = true
+			continue
+		}
+
+		key, value, found := strings.Cut(arg, "=")
+
+		if !found || key == "" {
+			_, file, line, _ := runtime.Caller(0)
+
+			message := fmt.Sprintf("%s:%d: Argument <%s> not formatted as FOO=BAR", file, line, arg)
+			err := TemplateVariableErr{ErrorMessage: message}
+			return k, err
+		}
+
+		if literal {
+			k[key] = value
+			continue
+		}
+
+		path, converted, err := parseDefinition(key, value)
+
+		if err != nil {
+			_, file, line, _ := runtime.Caller(0)
+
+			message := fmt.Sprintf("%s:%d: Argument <%s>: %v", file, line, arg, err)
+			return k, TemplateVariableErr{ErrorMessage: message}
+		}
+
+		setVariable(k, path, converted)
+	}
+
+	return k, nil
+}
+
+// parseDefinition works out the path and the value of one definition.
+func parseDefinition(key string, value string) ([]string, interface{}, error) {
+	definitionType := ""
+
+	if i := strings.LastIndex(key, ":"); i >= 0 {
+		key, definitionType = key[:i], key[i+1:]
+
+		if !slices.Contains(DefinitionTypes, definitionType) {
+			return nil, nil, fmt.Errorf("unknown type <%s>, expected one of %s", definitionType, strings.Join(DefinitionTypes, ", "))
+		}
+	}
+
+	path := strings.Split(key, ".")
+
+	for _, segment := range path {
+		if segment == "" {
+			return nil, nil, fmt.Errorf("key <%s> has an empty segment", key)
+		}
+	}
+
+	isList := strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]")
+
+	switch definitionType {
+	case "", "int", "float", "bool":
+		if !isList {
+			converted, err := convertDefinition(definitionType, value)
+			return path, converted, err
+		}
+
+		list := []interface{}{}
+
+		for _, item := range listItems(value) {
+			converted, err := convertDefinition(definitionType, item)
+
+			if err != nil {
+				return nil, nil, err
+			}
+
+			list = append(list, converted)
+		}
+
+		return path, list, nil
+	case "list":
+		if !isList {
+			value = "[" + value + "]"
+		}
+
+		list := []interface{}{}
+		for _, item := range listItems(value) {
+			list = append(list, item)
+		}
+
+		return path, list, nil
+	case "json":
+		var decoded interface{}
+
+		decoder := json.NewDecoder(bytes.NewReader([]byte(value)))
+		decoder.UseNumber()
+
+		err := decoder.Decode(&decoded)
+
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid json: %v", err)
+		}
+
+		return path, normalizeVariables(decoded), nil
+	}
+
+	// string
+	return path, value, nil
+}
+
+// convertDefinition converts a single value to a scalar definition type. No type means a string.
+func convertDefinition(definitionType string, value string) (interface{}, error) {
+	switch definitionType {
+	case "int":
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("<%s> is not an int", value)
+		}
+		return i, nil
+	case "float":
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("<%s> is not a float", value)
+		}
+		return f, nil
+	case "bool":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("<%s> is not a bool", value)
+		}
+		return b, nil
+	}
+
+	return value, nil
+}
+
+// listItems splits a [a, b, c] list into its trimmed items. [] is an empty list.
+func listItems(value string) []string {
+	inner := strings.TrimSpace(value[1 : len(value)-1])
+
+	if inner == "" {
+		return nil
+	}
+
+	items := strings.Split(inner, ",")
+	for i := range items {
+		items[i] = strings.TrimSpace(items[i])
+	}
+
+	return items
+}
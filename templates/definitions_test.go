@@ -0,0 +1,86 @@
+package templates_test
+
+import (
+	"errors"
+	"reflect"
+	"templ/templates"
+	"templ/test_helpers"
+	"testing"
+)
+
+func TestDefinitionGrammar(t *testing.T) {
+	definitions := []string{
+		"URL=https://x?a=b&c=d",
+		"db.host=db.internal",
+		"db.port:int=5432",
+		"ports=[80, 443]",
+		"weights:float=[0.5,1.5]",
+		"replicas:int=3",
+		"debug:bool=true",
+		"tags:list=a,b",
+		"extra:json={\"a\": [1, \"two\"]}",
+		"version:string=[1.0]",
+		"--",
+		"odd=[not a list]",
+		"not.nested:int=x",
+	}
+
+	variables, err := templates.LoadVariables(nil, templates.RenderOptions{Definitions: definitions})
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	expected := map[string]interface{}{
+		"URL":            "https://x?a=b&c=d",
+		"db":             map[string]interface{}{"host": "db.internal", "port": 5432},
+		"ports":          []interface{}{"80", "443"},
+		"weights":        []interface{}{0.5, 1.5},
+		"replicas":       3,
+		"debug":          true,
+		"tags":           []interface{}{"a", "b"},
+		"extra":          map[string]interface{}{"a": []interface{}{1, "two"}},
+		"version":        "[1.0]",
+		"odd":            "[not a list]",
+		"not.nested:int": "x",
+	}
+
+	if !reflect.DeepEqual(variables.Values, expected) {
+		t.Errorf("Expected <%v>, received <%v>", expected, variables.Values)
+	}
+}
+
+func TestInvalidDefinitions(t *testing.T) {
+	for _, definition := range []string{"replicas:int=three", "name:colour=red", "db..host=x", "=value", "debug:bool=maybe"} {
+		_, err := templates.RenderFromStdin(`{{ .X }}`, []string{definition}, templates.RenderOptions{})
+
+		if !errors.Is(err, templates.TemplateVariableErr{}) {
+			t.Errorf("%s should raise a TemplateVariableErr, got %v", definition, err)
+		}
+	}
+}
+
+func TestFindTemplateAndVariableFilesSplitsOnFirstEquals(t *testing.T) {
+	templDir := t.TempDir()
+	t.Setenv("TEMPL_DIR", templDir)
+
+	err := test_helpers.WriteFiles(templDir, map[string]string{"service.yaml": `{{ .NAME }}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	templateFiles, variablesFiles, err := templates.FindTemplateAndVariableFiles([]string{"service.yaml=vars=1.yaml,more.yaml"})
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if len(templateFiles) != 1 {
+		t.Fatalf("Expected one template, found %v", templateFiles)
+	}
+
+	expected := []string{"vars=1.yaml", "more.yaml"}
+	if !reflect.DeepEqual(variablesFiles[templateFiles[0]], expected) {
+		t.Errorf("Expected <%v>, received <%v>", expected, variablesFiles[templateFiles[0]])
+	}
+}
@@ -0,0 +1,283 @@
+package templates
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"templ/configelements"
+	"templ/output"
+	"templ/templatedirectories"
+	"unicode/utf8"
+)
+
+// FindTemplateDirectory finds a directory template: a path to a directory, or a directory in the templates
+// directory, like go-service or templates-repo/skeletons/go-service. go-service@v1.4.0 is the directory as it is at
+// that tag, branch or commit of its repository. A name that matches more than one directory is an error.
+func FindTemplateDirectory(name string) (string, error) {
+	if info, err := os.Stat(name); err == nil && info.IsDir() {
+		return filepath.Abs(name)
+	}
+
+	if name, ref := splitRef(name); ref != "" {
+		found, err := findDirectoriesAtRef(name, ref)
+
+		if err != nil {
+			return "", err
+		}
+
+		return oneDirectory(name+"@"+ref, found)
+	}
+
+	templDir := configelements.NewTemplDir().TemplatesDir
+
+	if info, err := os.Stat(filepath.Join(templDir, name)); err == nil && info.IsDir() {
+		return filepath.Join(templDir, name), nil
+	}
+
+	var found []string
+	suffix := "/" + strings.Trim(filepath.ToSlash(name), "/")
+
+	err := filepath.WalkDir(templDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !entry.IsDir() {
+			return nil
+		}
+
+		if path != templDir && strings.HasPrefix(entry.Name(), ".") {
+			return filepath.SkipDir
+		}
+
+		if strings.HasSuffix(filepath.ToSlash(path), suffix) {
+			found = append(found, path)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		_, file, line, _ := runtime.Caller(0)
+		return "", fmt.Errorf("%s:%d: %v", file, line, err)
+	}
+
+	return oneDirectory(name, found)
+}
+
+// oneDirectory is the directory template called name, which is an error unless exactly one directory was found.
+func oneDirectory(name string, found []string) (string, error) {
+	switch len(found) {
+	case 0:
+		return "", TemplateVariableErr{ErrorMessage: fmt.Sprintf("can't find a template directory called %s", name)}
+	case 1:
+		return found[0], nil
+	}
+
+	return "", TemplateVariableErr{ErrorMessage: fmt.Sprintf("template directory %s could be any of %s", name, strings.Join(found, ", "))}
+}
+
+// RenderDirectory renders every file of a directory template into outputDir and returns the paths it wrote. Each
+// file's contents are rendered like a single template, with the variables from variablesFiles, the environment and
+// options.Definitions, and so is its path, so that cmd/{{ .name }}/main.go becomes cmd/shop/main.go. A file whose
+// path renders with an empty directory or file name, like {{ if .docker }}Dockerfile{{ end }}, is left out, along with
+// everything in it. Binary files and symbolic links are copied as they are, and every file keeps its mode.
+//
+// Every file is rendered before anything is written, so a template with problems writes nothing; the error has the
+// problems of every file. Files already in outputDir are dealt with by options.Writing. What was rendered is recorded
+// in the ProvenanceFile of outputDir, and then the hooks of the directory's DirectoryMetadataFile are run there.
+func RenderDirectory(templateDir string, variablesFiles []string, outputDir string, options RenderOptions) ([]string, error) {
+	variables, err := LoadVariables(variablesFiles, options)
+
+	if err != nil {
+		_, file, line, _ := runtime.Caller(0)
+		return nil, fmt.Errorf("%s:%d: %w", file, line, err)
+	}
+
+	files, err := renderDirectory(templateDir, variables.Values, options)
+
+	if err != nil {
+		return nil, err
+	}
+
+	written, err := writeRenderedFiles(outputDir, files, options)
+
+	if err != nil {
+		return written, err
+	}
+
+	records := []provenanceRecord{newProvenanceRecord(templateDir, outputDir, true, variables, options)}
+	// Templates whose files were all left as they were aren't recorded, and their hooks don't run again.
+	records = writtenRecords(records, written)
+	err = recordProvenance(records, options)
+
+	if err != nil {
+		return written, err
+	}
+
+	return written, runHooks(records, options)
+}
+
+// renderDirectory renders every file of a directory template, without writing anything. See RenderDirectory.
+func renderDirectory(templateDir string, variables map[string]interface{}, options RenderOptions) ([]output.File, error) {
+	var files []output.File
+	var renderErrs []error
+
+	err := filepath.WalkDir(templateDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relative, err := filepath.Rel(templateDir, path)
+
+		if err != nil || relative == "." {
+			return err
+		}
+
+		// Helper files are loaded with the templates that use them, and aren't part of what's made. Neither is the record
+		// of a directory that was rendered before, or the directory template's own metadata.
+		if entry.Name() == ".git" || entry.Name() == ProvenanceFile || relative == DirectoryMetadataFile || templatedirectories.IsHelperFile(relative) {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if entry.IsDir() {
+			return nil
+		}
+
+		target, err := renderPath(path, relative, variables, options)
+
+		if isRenderProblem(err) {
+			renderErrs = append(renderErrs, err)
+			return nil
+		}
+
+		if err != nil || target == "" {
+			return err
+		}
+
+		info, err := entry.Info()
+
+		if err != nil {
+			return err
+		}
+
+		file, err := renderFile(path, info, variables, options)
+
+		if isRenderProblem(err) {
+			renderErrs = append(renderErrs, err)
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		file.Path = target
+		files = append(files, file)
+
+		return nil
+	})
+
+	if err != nil {
+		_, file, line, _ := runtime.Caller(0)
+		return nil, fmt.Errorf("%s:%d: %v", file, line, err)
+	}
+
+	if len(renderErrs) > 0 {
+		return nil, errors.Join(renderErrs...)
+	}
+
+	return files, nil
+}
+
+// isRenderProblem reports whether an error is a problem with the templates or their variables, which is worth
+// reporting along with the problems of the other templates being rendered, rather than a failure that stops everything.
+func isRenderProblem(err error) bool {
+	return errors.Is(err, MissingVariablesErr{}) || errors.Is(err, TemplateError{}) || errors.Is(err, TemplateVariableErr{})
+}
+
+// writeRenderedFiles writes rendered files under outputDir, by options.Writing, and returns the paths it wrote. Files
+// keep the modes of their templates, so that the scripts of a directory template stay executable.
+func writeRenderedFiles(outputDir string, files []output.File, options RenderOptions) ([]string, error) {
+	for i := range files {
+		files[i].Path = filepath.Join(outputDir, files[i].Path)
+	}
+
+	writing := options.Writing
+	writing.KeepMode = true
+
+	return output.Write(files, writing)
+}
+
+// renderPath renders the path of a file in a directory template. It's empty when any directory or file name in it
+// renders empty.
+func renderPath(templatePath string, relative string, variables map[string]interface{}, options RenderOptions) (string, error) {
+	slashed := filepath.ToSlash(relative)
+
+	if strings.Contains(slashed, "{{") {
+		rendered, err := renderFromString(templatePath, slashed, variables, options)
+
+		if err != nil {
+			return "", err
+		}
+
+		slashed = rendered
+	}
+
+	for _, segment := range strings.Split(slashed, "/") {
+		if strings.TrimSpace(segment) == "" {
+			return "", nil
+		}
+	}
+
+	if !filepath.IsLocal(filepath.FromSlash(slashed)) {
+		return "", TemplateVariableErr{ErrorMessage: fmt.Sprintf("%s: the path renders as %s, which is outside the directory being made", templatePath, slashed)}
+	}
+
+	return filepath.FromSlash(slashed), nil
+}
+
+// renderFile renders the contents of a template file that's going to be written out.
+func renderFile(templatePath string, info fs.FileInfo, variables map[string]interface{}, options RenderOptions) (output.File, error) {
+	if info.Mode()&fs.ModeSymlink != 0 {
+		link, err := os.Readlink(templatePath)
+		return output.File{Link: link, Mode: info.Mode()}, err
+	}
+
+	content, err := os.ReadFile(templatePath)
+
+	if err != nil {
+		return output.File{}, err
+	}
+
+	file := output.File{Content: content, Mode: info.Mode().Perm()}
+
+	if isBinary(content) {
+		return file, nil
+	}
+
+	rendered, err := renderFromString(templatePath, string(content), variables, options)
+
+	if err != nil {
+		return output.File{}, err
+	}
+
+	file.Content = []byte(finishOutput(string(content), rendered, options))
+
+	return file, nil
+}
+
+// isBinary guesses whether a file is binary the way git does: by looking for a NUL byte near its start. Text that
+// isn't UTF-8 is treated as binary too, since the template engine can't work with it.
+func isBinary(content []byte) bool {
+	start := content[:min(len(content), 8000)]
+	return bytes.IndexByte(start, 0) >= 0 || !utf8.Valid(content)
+}
@@ -0,0 +1,104 @@
+package templates_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"templ/output"
+	"templ/templates"
+	"templ/test_helpers"
+	"testing"
+)
+
+func TestRenderDirectory(t *testing.T) {
+	repository := includeRepository(t, map[string]string{
+		"skeletons/go-service/cmd/{{ .name }}/main.go":                "package main\n\nfunc main() { println(\"{{ .name }}\") }\n",
+		"skeletons/go-service/.github/workflows/ci.yaml":              "name: {{ .name }}\nenv: ${{ secrets.TOKEN }}\n",
+		"skeletons/go-service/{{ if .docker }}Dockerfile{{ end }}":    "FROM scratch\n",
+		"skeletons/go-service/{{ if .docker }}docker{{ end }}/x.yaml": "x\n",
+		"skeletons/go-service/logo.png":                               "\x89PNG\x00{{ .name }}",
+		"skeletons/go-service/run.sh":                                 "#!/bin/sh\necho {{ .name }}\n",
+		"skeletons/go-service/_helpers/names.tmpl":                    `{{ define "x" }}{{ end }}`,
+	})
+
+	skeleton := filepath.Join(repository, "skeletons/go-service")
+
+	err := os.Chmod(filepath.Join(skeleton, "run.sh"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := templates.FindTemplateDirectory("go-service")
+	if err != nil || found != skeleton {
+		t.Fatalf("Expected to find <%s>, received <%s>, %v", skeleton, found, err)
+	}
+
+	outputDir := t.TempDir()
+	written, err := templates.RenderDirectory(skeleton, nil, outputDir, templates.RenderOptions{Definitions: []string{"name=shop"}})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	expected := []string{".github/workflows/ci.yaml", "cmd/shop/main.go", "logo.png", "run.sh"}
+	for i, path := range expected {
+		expected[i] = filepath.Join(outputDir, path)
+	}
+
+	if !reflect.DeepEqual(written, expected) {
+		t.Errorf("Expected to write <%v>, wrote <%v>", expected, written)
+	}
+
+	contents := map[string]string{
+		"cmd/shop/main.go":          "package main\n\nfunc main() { println(\"shop\") }\n",
+		".github/workflows/ci.yaml": "name: shop\nenv: ${{ secrets.TOKEN }}\n",
+		"logo.png":                  "\x89PNG\x00{{ .name }}",
+	}
+
+	for path, want := range contents {
+		content, err := os.ReadFile(filepath.Join(outputDir, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(content) != want {
+			t.Errorf("%s: expected <%s>, received <%s>", path, want, content)
+		}
+	}
+
+	info, err := os.Stat(filepath.Join(outputDir, "run.sh"))
+	if err != nil || info.Mode().Perm() != 0755 {
+		t.Errorf("Expected run.sh to keep its mode, received %v, %v", info.Mode(), err)
+	}
+
+	_, err = templates.RenderDirectory(skeleton, nil, outputDir, templates.RenderOptions{Definitions: []string{"name=shop"}})
+	if !errors.Is(err, output.ExistsErr{}) {
+		t.Errorf("Expected a second render to refuse to overwrite, got %v", err)
+	}
+}
+
+func TestRenderDirectoryReportsEveryProblemAndWritesNothing(t *testing.T) {
+	skeleton := t.TempDir()
+
+	err := test_helpers.WriteFiles(skeleton, map[string]string{
+		"a.txt":              "{{ .a }}",
+		"{{ .b }}/b.txt":     "b",
+		"c.txt":              "{{ .c | len }}",
+		"dots/{{ .up }}.txt": "",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outputDir := filepath.Join(t.TempDir(), "out")
+	_, err = templates.RenderDirectory(skeleton, nil, outputDir, templates.RenderOptions{Strict: true, Definitions: []string{"c:int=1", "up=../../../x"}})
+
+	if !errors.Is(err, templates.MissingVariablesErr{}) || !errors.Is(err, templates.TemplateError{}) || !strings.Contains(err.Error(), "outside the directory") {
+		t.Errorf("Expected missing variables, a broken template and a path outside the directory, got %v", err)
+	}
+
+	if _, statErr := os.Stat(outputDir); !os.IsNotExist(statErr) {
+		t.Errorf("Expected nothing to be written, got %v", statErr)
+	}
+}
@@ -0,0 +1,429 @@
+package templates
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// Reference is one place a template uses a variable.
+type Reference struct {
+	// Template is the path of the included template the reference is in. It's empty for the template itself.
+	Template string
+	Line     int
+	Column   int
+	// Context is how the variable is used there: substitution for a plain {{ .X }} or an argument to a function,
+	// conditional for the condition of an if, and range or with for what a range or with block runs over.
+	Context string
+	// Guarded is true for an argument of one of the guardFunctions, like {{ .X | default "x" }}, which deals with the
+	// variable being missing itself.
+	Guarded bool
+}
+
+func (r Reference) String() string {
+	if r.Template != "" {
+		return fmt.Sprintf("%s line %d, column %d: %s", r.Template, r.Line, r.Column, r.Context)
+	}
+	return fmt.Sprintf("line %d, column %d: %s", r.Line, r.Column, r.Context)
+}
+
+// source is a template file made ready for the template engine: its front-matter is split off and its actions are
+// tokenized, with lineKeeper standing in for the front-matter so that the program has the same lines as the file.
+type source struct {
+	path string
+	// name is what the template engine calls the template: the file's name for the template being rendered, and the
+	// path for the templates it includes.
+	name     string
+	text     string
+	metadata *Metadata
+	program  string
+	// The bytes the front-matter takes up in text, and the bytes the lineKeeper takes up in program.
+	headerBytes int
+	keeperBytes int
+	// set is every template parsed along with this one.
+	set *templateSet
+	// unknowns are the actions left as they are only because they call a function templ doesn't know.
+	unknowns []unknownFunction
+}
+
+func prepareTemplate(templatePath string, templateText string) (*source, error) {
+	s, err := prepareSource(templatePath, templateText)
+
+	if err != nil {
+		return nil, err
+	}
+
+	s.name = path.Base(templatePath)
+	s.set = &templateSet{root: s, sources: map[string]*source{s.name: s}}
+
+	return s, nil
+}
+
+func prepareSource(templatePath string, templateText string) (*source, error) {
+	// A byte order mark would hide front-matter. finishOutput puts it back.
+	templateText = strings.TrimPrefix(templateText, byteOrderMark)
+
+	metadata, body, headerLines, err := parseFrontMatter(templateText)
+
+	if err != nil {
+		return nil, err
+	}
+
+	program, unknowns, err := tokenize(body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	keeper := lineKeeper(headerLines)
+
+	return &source{
+		path:        templatePath,
+		name:        templatePath,
+		text:        templateText,
+		metadata:    metadata,
+		program:     keeper + program,
+		headerBytes: len(templateText) - len(body),
+		keeperBytes: len(keeper),
+		unknowns:    unknowns,
+	}, nil
+}
+
+// unknownFunctionErrs are TemplateErrors for the actions of every template of the set that were left as they are only
+// because they call a function templ doesn't know, like {{ uper .name }}, the template itself's first.
+func (set *templateSet) unknownFunctionErrs() []error {
+	var errs []error
+	var names []string
+
+	for name, s := range set.sources {
+		if s != set.root {
+			names = append(names, name)
+		}
+	}
+
+	slices.Sort(names)
+	sources := []*source{set.root}
+
+	for _, name := range names {
+		sources = append(sources, set.sources[name])
+	}
+
+	for _, s := range sources {
+		for _, unknown := range s.unknowns {
+			message := fmt.Sprintf("%s isn't a function templ knows, so the action is left as it is", unknown.name)
+			errs = append(errs, s.errorAt(parse.Pos(s.keeperBytes+unknown.offset), message))
+		}
+	}
+
+	return errs
+}
+
+// parse hands the program to the template engine, along with its repository's helper files, the templates it extends
+// and every template it includes. A program that doesn't parse is a TemplateError.
+func (s *source) parse() (*template.Template, error) {
+	tmpl := template.New(s.name).Delims(leftDelim, rightDelim).Funcs(templateFunctions)
+	tmpl.Funcs(template.FuncMap{"include": s.set.include(tmpl)})
+
+	err := s.set.loadHelpers(tmpl)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.set.loadBases(tmpl)
+
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tmpl.Parse(s.program)
+
+	if err != nil {
+		return nil, s.templateError(tmpl, err)
+	}
+
+	err = s.set.load(tmpl)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.set.renderThroughBase(tmpl)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return tmpl, nil
+}
+
+// position turns an offset into the program into a line and column of the template file, both counted from 1.
+func (s *source) position(pos parse.Pos) (line int, column int) {
+	offset := int(pos) - s.keeperBytes + s.headerBytes
+
+	if offset < 0 || offset > len(s.text) {
+		offset = 0
+	}
+
+	before := s.text[:offset]
+	line = 1 + strings.Count(before, "\n")
+	column = 1 + offset - (strings.LastIndex(before, "\n") + 1)
+
+	return line, column
+}
+
+// variables walks a parsed template and returns every variable it references along with where. Variables declared in
+// the front-matter come first, in the order they're declared; after them come the rest, in the order they first appear.
+//
+// Each variable is named by its full path from the top of the variables tree, whatever the dot is where it's used:
+// .host inside {{ with .database }} is database.host, and $.PROTOCOL is PROTOCOL anywhere. A field of the items a range
+// runs over is named after the list with [] on the end, so .name inside {{ range .services }} is services[].name.
+// Fields of a dot templ can't follow, like the items of {{ range until 3 }}, are left out.
+func (s *source) variables(tmpl *template.Template) []Variable {
+	return s.discover(tmpl).variables
+}
+
+func (s *source) discover(tmpl *template.Template) *discovery {
+	// A template that extends another is walked from its furthest base.
+	entry := s
+	if base := s.set.sources[tmpl.Tree.ParseName]; base != nil {
+		entry = base
+	}
+
+	d := &discovery{tmpl: tmpl, source: entry, index: make(map[string]int), at: make(map[position]referenceIndex)}
+
+	if s.metadata != nil {
+		for _, variable := range s.metadata.Variables {
+			d.index[variable.Name] = len(d.variables)
+			d.variables = append(d.variables, variable)
+		}
+	}
+
+	d.walk(tmpl.Tree.Root, scope{dot: []string{}, declared: map[string][]string{"$": {}}})
+
+	return d
+}
+
+type discovery struct {
+	tmpl *template.Template
+	// source is the template being walked, which is an included one while walking an include.
+	source    *source
+	variables []Variable
+	index     map[string]int
+	// at finds a reference from where the engine placed its node, which is what the engine's errors report.
+	at map[position]referenceIndex
+	// including are the templates being walked into, so that a template that includes itself is only walked once.
+	including []string
+}
+
+// position is a place in one of the programs of a template set.
+type position struct {
+	template string
+	pos      parse.Pos
+}
+
+type referenceIndex struct {
+	variable  int
+	reference int
+}
+
+// scope is what the dot and each $variable stand for at some point of a template, as paths into the variables tree.
+// A nil path is something that isn't in the tree, or that templ can't follow.
+type scope struct {
+	dot      []string
+	declared map[string][]string
+}
+
+// enter returns a copy of the scope for a block, so that the block's declarations don't leak out of it.
+func (s scope) enter(dot []string) scope {
+	declared := make(map[string][]string, len(s.declared))
+	for name, path := range s.declared {
+		declared[name] = path
+	}
+	return scope{dot: dot, declared: declared}
+}
+
+func (d *discovery) record(node parse.Node, path []string, context string, guarded bool) {
+	if len(path) == 0 {
+		return
+	}
+
+	start := node.Position()
+
+	// The engine places $x.field at its .field, and .a.b at its .b.
+	switch n := node.(type) {
+	case *parse.VariableNode:
+		if len(n.Ident) > 1 {
+			start -= parse.Pos(len(n.Ident[0]))
+		}
+	case *parse.FieldNode:
+		if len(n.Ident) > 1 {
+			start -= parse.Pos(len(n.Ident[0]) + 1)
+		}
+	}
+
+	name := strings.Join(path, ".")
+	line, column := d.source.position(start)
+	reference := Reference{Line: line, Column: column, Context: context, Guarded: guarded}
+
+	if d.source != d.source.set.root {
+		reference.Template = d.source.path
+	}
+
+	i, found := d.index[name]
+
+	if !found {
+		i = len(d.variables)
+		d.index[name] = i
+		d.variables = append(d.variables, Variable{Name: name})
+	}
+
+	d.at[position{d.source.name, node.Position()}] = referenceIndex{variable: i, reference: len(d.variables[i].References)}
+	d.variables[i].References = append(d.variables[i].References, reference)
+}
+
+func (d *discovery) walk(node parse.Node, s scope) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			d.walk(child, s)
+		}
+	case *parse.ActionNode:
+		d.pipe(n.Pipe, s, "substitution")
+
+		// {{ $x := .database }} makes $x stand for database until the end of the block.
+		for _, variable := range n.Pipe.Decl {
+			s.declared[variable.Ident[0]] = d.pathOf(n.Pipe, s)
+		}
+	case *parse.IfNode:
+		d.pipe(n.Pipe, s, "conditional")
+		d.walk(n.List, s.enter(s.dot))
+		d.walk(n.ElseList, s.enter(s.dot))
+	case *parse.RangeNode:
+		d.pipe(n.Pipe, s, "range")
+
+		item := elementOf(d.pathOf(n.Pipe, s))
+		body := s.enter(item)
+
+		switch len(n.Pipe.Decl) {
+		case 1:
+			body.declared[n.Pipe.Decl[0].Ident[0]] = item
+		case 2:
+			body.declared[n.Pipe.Decl[0].Ident[0]] = nil
+			body.declared[n.Pipe.Decl[1].Ident[0]] = item
+		}
+
+		d.walk(n.List, body)
+		d.walk(n.ElseList, s.enter(s.dot))
+	case *parse.WithNode:
+		d.pipe(n.Pipe, s, "with")
+
+		value := d.pathOf(n.Pipe, s)
+		body := s.enter(value)
+
+		for _, variable := range n.Pipe.Decl {
+			body.declared[variable.Ident[0]] = value
+		}
+
+		d.walk(n.List, body)
+		d.walk(n.ElseList, s.enter(s.dot))
+	case *parse.TemplateNode:
+		d.pipe(n.Pipe, s, "substitution")
+		d.include(n.Name, d.pathOf(n.Pipe, s))
+	}
+}
+
+// pipe records the references in every argument of a pipeline.
+func (d *discovery) pipe(pipe *parse.PipeNode, s scope, context string) {
+	if pipe == nil {
+		return
+	}
+
+	for i, command := range pipe.Cmds {
+		for _, arg := range command.Args {
+			d.argument(arg, s, context, isGuarded(pipe, i))
+		}
+
+		// {{ include "name" .database }} uses whatever the included template uses, from database down.
+		if name, data, isInclude := includeCall(command); isInclude {
+			d.include(name, d.pathOfNode(data, s))
+		}
+	}
+}
+
+func (d *discovery) argument(node parse.Node, s scope, context string, guarded bool) {
+	switch n := node.(type) {
+	case *parse.FieldNode, *parse.VariableNode:
+		if path := d.pathOfNode(n, s); len(path) > 0 && !isBareVariable(n) {
+			d.record(n, path, context, guarded)
+		}
+	case *parse.ChainNode:
+		if path := d.pathOfNode(n, s); path != nil {
+			d.record(n, path, context, guarded)
+			return
+		}
+		d.argument(n.Node, s, context, guarded)
+	case *parse.PipeNode:
+		d.pipe(n, s, context)
+	}
+}
+
+// pathOf is the path of what a pipeline evaluates to, if it's nothing more than a reference.
+func (d *discovery) pathOf(pipe *parse.PipeNode, s scope) []string {
+	if pipe == nil || len(pipe.Cmds) != 1 || len(pipe.Cmds[0].Args) != 1 {
+		return nil
+	}
+
+	return d.pathOfNode(pipe.Cmds[0].Args[0], s)
+}
+
+func (d *discovery) pathOfNode(node parse.Node, s scope) []string {
+	switch n := node.(type) {
+	case *parse.DotNode:
+		return s.dot
+	case *parse.FieldNode:
+		return extendPath(s.dot, n.Ident)
+	case *parse.VariableNode:
+		return extendPath(s.declared[n.Ident[0]], n.Ident[1:])
+	case *parse.ChainNode:
+		return extendPath(d.pathOfNode(n.Node, s), n.Field)
+	case *parse.PipeNode:
+		return d.pathOf(n, s)
+	}
+
+	return nil
+}
+
+// isBareVariable reports whether node is a $variable on its own, whose references were recorded where it was declared.
+func isBareVariable(node parse.Node) bool {
+	variable, ok := node.(*parse.VariableNode)
+	return ok && len(variable.Ident) == 1
+}
+
+// extendPath appends fields to a path, or returns nil if the path is unknown.
+func extendPath(path []string, fields []string) []string {
+	if path == nil {
+		return nil
+	}
+
+	joined := make([]string, 0, len(path)+len(fields))
+	joined = append(joined, path...)
+	return append(joined, fields...)
+}
+
+// elementOf is the path of the items of the list at path.
+func elementOf(path []string) []string {
+	if len(path) == 0 {
+		return nil
+	}
+
+	element := extendPath(path, nil)
+	element[len(element)-1] += "[]"
+	return element
+}
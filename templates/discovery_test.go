@@ -0,0 +1,154 @@
+package templates_test
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"templ/templates"
+	"testing"
+)
+
+func TestRetrieveVariablesFindsEveryReference(t *testing.T) {
+	template := `name: {{- .NAME -}}
+{{ if and .ENABLED (eq .ENVIRONMENT "prod") }}{{ printf "%s" .REGION }}{{ end }}
+{{ range .services }}{{ .name }} {{ $.DOMAIN }}{{ end }}
+{{ with .database }}{{ .host }}:{{ .port }}{{ end }}
+{{ $owner := .team }}{{ $owner.lead }}
+${{ env.IGNORED }} {{ .NAME }}`
+
+	variables, err := templates.RetrieveVariables(template)
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	expected := map[string][]templates.Reference{
+		"NAME":            {{Line: 1, Column: 11, Context: "substitution"}, {Line: 6, Column: 23, Context: "substitution"}},
+		"ENABLED":         {{Line: 2, Column: 11, Context: "conditional"}},
+		"ENVIRONMENT":     {{Line: 2, Column: 24, Context: "conditional"}},
+		"REGION":          {{Line: 2, Column: 62, Context: "substitution"}},
+		"services":        {{Line: 3, Column: 10, Context: "range"}},
+		"services[].name": {{Line: 3, Column: 25, Context: "substitution"}},
+		"DOMAIN":          {{Line: 3, Column: 37, Context: "substitution"}},
+		"database":        {{Line: 4, Column: 9, Context: "with"}},
+		"database.host":   {{Line: 4, Column: 24, Context: "substitution"}},
+		"database.port":   {{Line: 4, Column: 36, Context: "substitution"}},
+		"team":            {{Line: 5, Column: 14, Context: "substitution"}},
+		"team.lead":       {{Line: 5, Column: 25, Context: "substitution"}},
+	}
+
+	received := make(map[string][]templates.Reference)
+	names := []string{}
+	for _, variable := range variables {
+		received[variable.Name] = variable.References
+		names = append(names, variable.Name)
+	}
+
+	if !reflect.DeepEqual(received, expected) {
+		t.Errorf("Expected <%v>, received <%v>", expected, received)
+	}
+
+	order := []string{"NAME", "ENABLED", "ENVIRONMENT", "REGION", "services", "services[].name", "DOMAIN",
+		"database", "database.host", "database.port", "team", "team.lead"}
+
+	if !reflect.DeepEqual(names, order) {
+		t.Errorf("Expected variables in order <%v>, received <%v>", order, names)
+	}
+}
+
+func TestRetrieveVariablesReportsPositionsInTheFile(t *testing.T) {
+	template := `---
+templ:
+  variables:
+    NAME:
+      required: true
+---
+  hello {{ .NAME }} from {{ .PLACE }}`
+
+	variables, err := templates.RetrieveVariables(template)
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	received := []templates.Variable{}
+	for _, variable := range variables {
+		received = append(received, templates.Variable{Name: variable.Name, References: variable.References})
+	}
+
+	expected := []templates.Variable{
+		{Name: "NAME", References: []templates.Reference{{Line: 7, Column: 12, Context: "substitution"}}},
+		{Name: "PLACE", References: []templates.Reference{{Line: 7, Column: 29, Context: "substitution"}}},
+	}
+
+	if !reflect.DeepEqual(received, expected) {
+		t.Errorf("Expected <%v>, received <%v>", expected, received)
+	}
+}
+
+func TestRetrieveVariablesSkipsUnfollowableDots(t *testing.T) {
+	names, err := variableNames(`{{ range $i, $n := .counts }}{{ $n.value }}{{ end }}{{ with index .items 0 }}{{ .name }}{{ end }}`)
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	expected := []string{"counts", "counts[].value", "items"}
+
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected <%v>, received <%v>", expected, names)
+	}
+}
+
+func TestReferenceString(t *testing.T) {
+	reference := templates.Reference{Line: 3, Column: 7, Context: "range"}
+
+	if reference.String() != "line 3, column 7: range" {
+		t.Errorf("Expected <line 3, column 7: range>, received <%s>", reference.String())
+	}
+}
+
+func TestStrictRenderChecksFieldsInsideWith(t *testing.T) {
+	template := `{{ with .database }}{{ .host }}{{ end }}
+{{ with .cache }}{{ .host }}{{ end }}`
+
+	_, err := templates.RenderFromStdin(template, []string{"database.port=5432"}, templates.RenderOptions{Strict: true})
+
+	var missingErr templates.MissingVariablesErr
+	if !errors.As(err, &missingErr) {
+		t.Fatalf("Expected a MissingVariablesErr, got %v", err)
+	}
+
+	expected := []templates.MissingVariable{
+		{Name: "database.host", Lines: []int{1}},
+		{Name: "cache", Lines: []int{2}},
+	}
+
+	if !reflect.DeepEqual(missingErr.Missing, expected) {
+		t.Errorf("Expected <%v>, received <%v>", expected, missingErr.Missing)
+	}
+}
+
+func TestPrompterSkipsListItemsAndMaps(t *testing.T) {
+	variables, err := templates.RetrieveVariables(`{{ range .services }}{{ .name }}{{ end }}{{ .database.host }}`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	answers, err := templates.NewPrompter(strings.NewReader("web\ndb.internal\n"), &out).Ask(variables, map[string]interface{}{})
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	expected := map[string]interface{}{
+		"services": "web",
+		"database": map[string]interface{}{"host": "db.internal"},
+	}
+
+	if !reflect.DeepEqual(answers, expected) {
+		t.Errorf("Expected <%v>, received <%v>", expected, answers)
+	}
+}
@@ -0,0 +1,38 @@
+package templates
+
+import "strings"
+
+// byteOrderMark is the UTF-8 byte order mark some editors put at the start of a file.
+const byteOrderMark = "\xef\xbb\xbf"
+
+// LineEndings are the ways rendered output's line endings can be written: as the template has them, as \n or as \r\n.
+var LineEndings = []string{"keep", "lf", "crlf"}
+
+// ByteOrderMarks are the ways a byte order mark can be handled: kept if the template starts with one, left off, or
+// added.
+var ByteOrderMarks = []string{"keep", "strip", "add"}
+
+// finishOutput makes the output rendered from templateText into what's printed or written. Output is exactly what the
+// template rendered, unless options.LineEndings or options.ByteOrderMark say otherwise. Templates are read without
+// their byte order mark, which is put back here.
+func finishOutput(templateText string, rendered string, options RenderOptions) string {
+	rendered = strings.TrimPrefix(rendered, byteOrderMark)
+
+	switch options.LineEndings {
+	case "lf":
+		rendered = strings.ReplaceAll(rendered, "\r\n", "\n")
+	case "crlf":
+		rendered = strings.ReplaceAll(strings.ReplaceAll(rendered, "\r\n", "\n"), "\n", "\r\n")
+	}
+
+	switch options.ByteOrderMark {
+	case "", "keep":
+		if strings.HasPrefix(templateText, byteOrderMark) {
+			rendered = byteOrderMark + rendered
+		}
+	case "add":
+		rendered = byteOrderMark + rendered
+	}
+
+	return rendered
+}
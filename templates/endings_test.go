@@ -0,0 +1,56 @@
+package templates_test
+
+import (
+	"templ/templates"
+	"testing"
+)
+
+func TestOutputIsExact(t *testing.T) {
+	template := "name: {{ .name }}\r\nkind: service"
+
+	output, err := templates.RenderFromStdin(template, []string{"name=shop"}, templates.RenderOptions{})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if output != "name: shop\r\nkind: service" {
+		t.Errorf("Expected the output to be exactly what was rendered, received <%q>", output)
+	}
+
+	// Rendering the output again, like templ x | templ A=B does, changes nothing.
+	again, err := templates.RenderFromStdin(output, []string{"name=shop"}, templates.RenderOptions{})
+	if err != nil || again != output {
+		t.Errorf("Expected <%q> again, received <%q>, %v", output, again, err)
+	}
+}
+
+func TestLineEndingsAndByteOrderMarks(t *testing.T) {
+	const bom = "\xef\xbb\xbf"
+	template := bom + "---\ntempl:\n  variables:\n    name:\n      default: shop\n---\na: {{ .name }}\r\nb\n"
+
+	tests := map[string]struct {
+		options  templates.RenderOptions
+		expected string
+	}{
+		"keep":      {templates.RenderOptions{}, bom + "a: shop\r\nb\n"},
+		"lf":        {templates.RenderOptions{LineEndings: "lf"}, bom + "a: shop\nb\n"},
+		"crlf":      {templates.RenderOptions{LineEndings: "crlf"}, bom + "a: shop\r\nb\r\n"},
+		"strip bom": {templates.RenderOptions{ByteOrderMark: "strip"}, "a: shop\r\nb\n"},
+	}
+
+	for name, test := range tests {
+		output, err := templates.RenderFromStdin(template, nil, test.options)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		if output != test.expected {
+			t.Errorf("%s: expected <%q>, received <%q>", name, test.expected, output)
+		}
+	}
+
+	output, err := templates.RenderFromStdin("plain\n", nil, templates.RenderOptions{ByteOrderMark: "add", LineEndings: "crlf"})
+	if err != nil || output != bom+"plain\r\n" {
+		t.Errorf("Expected a byte order mark and crlf on a template without variables, received <%q>, %v", output, err)
+	}
+}
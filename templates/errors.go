@@ -0,0 +1,207 @@
+package templates
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// TemplateError is a template that couldn't be parsed or rendered, and where in the template file it went wrong.
+type TemplateError struct {
+	// Template is the path of the template file, or stdin.
+	Template string
+	// Line and Column are where the problem is in the template file, counted from 1. A Column of 0 means the engine
+	// only knew the line.
+	Line   int
+	Column int
+	// Variable is the path of the variable involved, like database.host, if there is one.
+	Variable string
+	// Message is what the template engine had to say.
+	Message string
+	// Snippet is the template line the problem is on.
+	Snippet string
+}
+
+func (t TemplateError) Error() string {
+	var message strings.Builder
+
+	message.WriteString(t.Template)
+
+	if t.Line > 0 {
+		fmt.Fprintf(&message, ":%d", t.Line)
+	}
+
+	if t.Column > 0 {
+		fmt.Fprintf(&message, ":%d", t.Column)
+	}
+
+	message.WriteString(": ")
+
+	if t.Variable != "" {
+		fmt.Fprintf(&message, "variable %s: ", t.Variable)
+	}
+
+	message.WriteString(t.Message)
+
+	if t.Line > 0 {
+		gutter := strconv.Itoa(t.Line)
+		fmt.Fprintf(&message, "\n  %s | %s", gutter, t.Snippet)
+
+		if t.Column > 0 && t.Column <= len(t.Snippet)+1 {
+			// Tabs are kept, so that the caret lines up however wide the terminal draws them.
+			indent := strings.Map(func(r rune) rune {
+				if r == '\t' {
+					return r
+				}
+				return ' '
+			}, t.Snippet[:t.Column-1])
+
+			fmt.Fprintf(&message, "\n  %s | %s^", strings.Repeat(" ", len(gutter)), indent)
+		}
+	}
+
+	return message.String()
+}
+
+func (t TemplateError) Is(target error) bool {
+	_, ok := target.(TemplateError)
+	return ok
+}
+
+// templateError turns an error from the template engine into a TemplateError. The engine reports positions in the
+// program, like "template: name:12:7: executing ...", which are turned back into positions in the template file. An
+// error that's already a TemplateError, like one from an include, is returned as it is.
+func (s *source) templateError(tmpl *template.Template, err error) TemplateError {
+	var templateErr TemplateError
+
+	if errors.As(err, &templateErr) {
+		return templateErr
+	}
+
+	message := delimiterRestorer.Replace(err.Error())
+	templateErr = TemplateError{Template: s.path, Message: message}
+
+	rest, found := strings.CutPrefix(message, "template: ")
+
+	if !found {
+		return templateErr
+	}
+
+	// The engine names the template the error is in, which may be another template of the set.
+	src, matched := s, ""
+	for name, candidate := range s.set.sources {
+		if strings.HasPrefix(rest, name+":") && len(name) > len(matched) {
+			src, matched = candidate, name
+		}
+	}
+
+	location, rest, found := strings.Cut(strings.TrimPrefix(rest, src.name+":"), ": ")
+
+	if !found {
+		return templateErr
+	}
+
+	lineText, columnText, hasColumn := strings.Cut(location, ":")
+	programLine, err := strconv.Atoi(lineText)
+
+	if err != nil {
+		return templateErr
+	}
+
+	lineStart := 0
+	for i := 1; i < programLine; i++ {
+		next := strings.IndexByte(src.program[lineStart:], '\n')
+		if next < 0 {
+			return templateErr
+		}
+		lineStart += next + 1
+	}
+
+	programColumn := -1
+
+	if hasColumn {
+		programColumn, err = strconv.Atoi(columnText)
+		if err != nil {
+			return templateErr
+		}
+	} else if action := strings.Index(strings.SplitN(src.program[lineStart:], "\n", 2)[0], leftDelim); action >= 0 {
+		// Parse errors only come with a line. The first action on it is the best guess there is.
+		programColumn = action
+	}
+
+	// Execution errors read: executing "name" at <.field>: what went wrong.
+	if strings.HasPrefix(rest, "executing ") {
+		if _, after, found := strings.Cut(rest, ">: "); found {
+			rest = after
+		}
+	}
+
+	if strings.Contains(rest, "bad character") {
+		rest += " (this normally means there's a character a variable name can't have)"
+	}
+
+	if programColumn < 0 {
+		templateErr = src.errorAt(parse.Pos(lineStart), rest)
+		templateErr.Column = 0
+		return templateErr
+	}
+
+	templateErr = src.errorAt(parse.Pos(lineStart+programColumn), rest)
+
+	if reference, name, found := s.set.root.referenceAt(tmpl, position{src.name, parse.Pos(lineStart + programColumn)}); found {
+		templateErr.Variable = name
+		templateErr.Line, templateErr.Column = reference.Line, reference.Column
+	}
+
+	return templateErr
+}
+
+// errorAt is a TemplateError at a position in the program.
+func (s *source) errorAt(pos parse.Pos, message string) TemplateError {
+	line, column := s.position(pos)
+	return s.errorOn(line, column, message)
+}
+
+// errorOn is a TemplateError at a line and column of the template file, like one of its front-matter.
+func (s *source) errorOn(line int, column int, message string) TemplateError {
+	templateErr := TemplateError{Template: s.path, Line: line, Column: column, Message: message}
+
+	lines := strings.Split(s.text, "\n")
+	if line <= len(lines) {
+		templateErr.Snippet = strings.TrimRight(lines[line-1], "\r")
+	}
+
+	return templateErr
+}
+
+// actionText is the text of the action node is in, as it was written in the template.
+func (s *source) actionText(node parse.Node) string {
+	start := strings.LastIndex(s.program[:node.Position()], leftDelim)
+	end := strings.Index(s.program[node.Position():], rightDelim)
+
+	if start < 0 || end < 0 {
+		return ""
+	}
+
+	return delimiterRestorer.Replace(s.program[start : int(node.Position())+end+len(rightDelim)])
+}
+
+// referenceAt returns the variable reference the engine placed at a position, if there is one.
+func (s *source) referenceAt(tmpl *template.Template, at position) (Reference, string, bool) {
+	if tmpl.Tree == nil {
+		return Reference{}, "", false
+	}
+
+	d := s.discover(tmpl)
+	i, found := d.at[at]
+
+	if !found {
+		return Reference{}, "", false
+	}
+
+	variable := d.variables[i.variable]
+	return variable.References[i.reference], variable.Name, true
+}
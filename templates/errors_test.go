@@ -0,0 +1,129 @@
+package templates_test
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"templ/templates"
+	"templ/test_helpers"
+	"testing"
+)
+
+func TestTemplateErrorFromExecution(t *testing.T) {
+	template := `---
+templ:
+  variables:
+    services:
+      type: list
+---
+kind: Service
+{{- range .services }}
+	port: {{ .port }} name: {{ .name }}
+{{- end }}`
+
+	_, err := templates.RenderFromStdin(template, []string{`services:json=[{"port": 80}]`}, templates.RenderOptions{Strict: true})
+
+	var templateErr templates.TemplateError
+	if !errors.As(err, &templateErr) {
+		t.Fatalf("Expected a TemplateError, got %v", err)
+	}
+
+	expected := templates.TemplateError{
+		Template: "stdin",
+		Line:     9,
+		Column:   29,
+		Variable: "services[].name",
+		Message:  `map has no entry for key "name"`,
+		Snippet:  "\tport: {{ .port }} name: {{ .name }}",
+	}
+
+	if !reflect.DeepEqual(templateErr, expected) {
+		t.Errorf("Expected <%#v>, received <%#v>", expected, templateErr)
+	}
+
+	message := "stdin:9:29: variable services[].name: map has no entry for key \"name\"\n" +
+		"  9 | \tport: {{ .port }} name: {{ .name }}\n" +
+		"    | \t                           ^"
+
+	if err.Error() != message {
+		t.Errorf("Expected <%s>, received <%s>", message, err.Error())
+	}
+}
+
+func TestTemplateErrorFromParsing(t *testing.T) {
+	template := `---
+templ:
+  variables:
+    X: {}
+---
+{{ if .X }}
+  yes {{ else }}no{{ else }}
+{{ end }}`
+
+	_, err := templates.RenderFromStdin(template, []string{"X=1"}, templates.RenderOptions{})
+
+	var templateErr templates.TemplateError
+	if !errors.As(err, &templateErr) {
+		t.Fatalf("Expected a TemplateError, got %v", err)
+	}
+
+	if templateErr.Line != 7 || templateErr.Column != 7 || templateErr.Snippet != "  yes {{ else }}no{{ else }}" {
+		t.Errorf("Expected line 7, column 7 of the template, received <%#v>", templateErr)
+	}
+
+	if templateErr.Message != "expected end; found {{else}}" {
+		t.Errorf("Expected the engine's message, received <%s>", templateErr.Message)
+	}
+}
+
+func TestRenderFromFilesReportsEveryBrokenTemplate(t *testing.T) {
+	dir := t.TempDir()
+
+	err := test_helpers.WriteFiles(dir, map[string]string{
+		"first.yaml":     `{{ len .name }}`,
+		"second.yaml":    "ok\n{{ .name.first }}",
+		"variables.yaml": `name: 3`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := filepath.Join(dir, "first.yaml")
+	second := filepath.Join(dir, "second.yaml")
+	variablesFile := filepath.Join(dir, "variables.yaml")
+
+	var renderErr error
+	output, err := test_helpers.CaptureStdout(func() {
+		renderErr = templates.RenderFromFiles(
+			[]string{first, second},
+			map[string][]string{first: {variablesFile}, second: {variablesFile}},
+			templates.RenderOptions{})
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if output != "" {
+		t.Errorf("Expected nothing printed, printed <%s>", output)
+	}
+
+	joined, ok := renderErr.(interface{ Unwrap() []error })
+	if !ok {
+		t.Fatalf("Expected an error for each template, got %v", renderErr)
+	}
+
+	var positions []string
+	for _, err := range joined.Unwrap() {
+		var templateErr templates.TemplateError
+		if !errors.As(err, &templateErr) {
+			t.Fatalf("Expected a TemplateError, got %v", err)
+		}
+		positions = append(positions, filepath.Base(templateErr.Template)+":"+templateErr.Variable)
+	}
+
+	expected := []string{"first.yaml:", "second.yaml:name.first"}
+	if !reflect.DeepEqual(positions, expected) {
+		t.Errorf("Expected <%v>, received <%v>", expected, positions)
+	}
+}
@@ -0,0 +1,106 @@
+package templates
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"slices"
+	"strings"
+	"text/template"
+)
+
+// loadBases parses the chain of templates the root template extends into tmpl, from the furthest base down, so that a
+// {{ define }} of a template nearer the root replaces the {{ block }} of the same name in its base. It's called before
+// the root template itself is parsed, so that the root's defines win over all of them. The front-matter variables of
+// the bases are added to the root's, after its own.
+func (set *templateSet) loadBases(tmpl *template.Template) error {
+	stack := []string{set.root.path}
+	var bases []*source
+
+	for child := set.root; child.metadata != nil && child.metadata.Extends != ""; {
+		name, line := child.metadata.Extends, child.metadata.extendsLine
+
+		resolved, err := resolveInclude(name, child.path)
+
+		if err != nil {
+			return child.errorOn(line, 0, err.Error())
+		}
+
+		if resolved == "" {
+			return child.errorOn(line, 0, fmt.Sprintf("can't find template %q to extend", name))
+		}
+
+		if slices.Contains(stack, resolved) {
+			return child.errorOn(line, 0, "extends cycle: "+strings.Join(append(stack, resolved), " extends "))
+		}
+
+		content, err := os.ReadFile(resolved)
+
+		if err != nil {
+			_, file, line, _ := runtime.Caller(0)
+			return fmt.Errorf("%s:%d: %v", file, line, err)
+		}
+
+		base, err := prepareSource(resolved, string(content))
+
+		if err != nil {
+			return child.errorOn(line, 0, fmt.Sprintf("%s: %v", resolved, err))
+		}
+
+		base.set = set
+		set.sources[base.name] = base
+		bases = append(bases, base)
+		stack = append(stack, resolved)
+		child = base
+	}
+
+	for i := len(bases) - 1; i >= 0; i-- {
+		_, err := tmpl.New(bases[i].name).Parse(bases[i].program)
+
+		if err != nil {
+			return bases[i].templateError(tmpl, err)
+		}
+
+		set.root.metadata = inherit(set.root.metadata, bases[i].metadata)
+	}
+
+	set.bases = bases
+
+	return nil
+}
+
+// renderThroughBase makes the root template render as the furthest base it extends, now that every block the bases
+// leave open has been filled in. Anything the root template has outside its {{ define }}s is dropped.
+func (set *templateSet) renderThroughBase(tmpl *template.Template) error {
+	if len(set.bases) == 0 {
+		return nil
+	}
+
+	top := tmpl.Lookup(set.bases[len(set.bases)-1].name)
+	_, err := tmpl.AddParseTree(tmpl.Name(), top.Tree)
+
+	return err
+}
+
+// inherit adds the variables a base template declares to the ones its child declares. The child's declaration of a
+// variable wins.
+func inherit(child *Metadata, base *Metadata) *Metadata {
+	if base == nil {
+		return child
+	}
+
+	inherited := &Metadata{}
+
+	if child != nil {
+		*inherited = *child
+		inherited.Variables = slices.Clone(child.Variables)
+	}
+
+	for _, variable := range base.Variables {
+		if !containsVariable(inherited.Variables, variable.Name) {
+			inherited.Variables = append(inherited.Variables, variable)
+		}
+	}
+
+	return inherited
+}
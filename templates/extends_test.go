@@ -0,0 +1,148 @@
+package templates_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"templ/templates"
+	"templ/test_helpers"
+	"testing"
+)
+
+func TestExtendsOverridesBlocks(t *testing.T) {
+	repository := includeRepository(t, map[string]string{
+		"base/workflow.yaml": `---
+templ:
+  variables:
+    go_version:
+      default: "1.22"
+---
+name: {{ .name }}
+steps:
+  - uses: actions/setup-go@v5
+    with:
+      go-version: {{ .go_version }}
+{{- block "steps" . }}
+  - run: go test ./...
+{{- end }}
+{{- block "deploy" . }}{{ end }}
+`,
+		"base/go-service.yaml": `---
+templ:
+  extends: base/workflow.yaml
+---
+{{ define "deploy" }}
+  - run: ./deploy {{ .environment }}
+{{- end }}`,
+		"github_workflows/shop.yaml": `---
+templ:
+  extends: base/go-service.yaml
+  variables:
+    name:
+      default: shop
+---
+{{ define "steps" }}
+  - run: go vet ./...
+  - run: go test -race ./...
+{{- end }}
+`,
+	})
+
+	shop := filepath.Join(repository, "github_workflows/shop.yaml")
+
+	content, err := os.ReadFile(shop)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	variables, err := templates.RetrieveTemplateVariables(shop, string(content))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	var names []string
+	for _, variable := range variables {
+		names = append(names, variable.Name)
+	}
+
+	expected := []string{"name", "go_version", "environment"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected variables <%v>, received <%v>", expected, names)
+	}
+
+	err = test_helpers.WriteFiles(repository, map[string]string{"vars.yaml": "environment: prod\n"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var renderErr error
+	rendered, err := test_helpers.CaptureStdout(func() {
+		renderErr = templates.RenderFromFiles([]string{shop}, map[string][]string{shop: {filepath.Join(repository, "vars.yaml")}}, templates.RenderOptions{Strict: true})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if renderErr != nil {
+		t.Fatalf("%v", renderErr)
+	}
+
+	want := `name: shop
+steps:
+  - uses: actions/setup-go@v5
+    with:
+      go-version: 1.22
+  - run: go vet ./...
+  - run: go test -race ./...
+  - run: ./deploy prod
+`
+	if rendered != want {
+		t.Errorf("Expected <%s>, received <%s>", want, rendered)
+	}
+}
+
+func TestExtendsErrors(t *testing.T) {
+	repository := includeRepository(t, map[string]string{
+		"a.yaml": "---\ntempl:\n  extends: b.yaml\n---\n",
+		"b.yaml": "---\ntempl:\n  extends: a.yaml\n---\n",
+	})
+
+	tests := map[string]struct {
+		path     string
+		template string
+		message  string
+	}{
+		"missing base": {"stdin", "---\ntempl:\n  extends: nowhere.yaml\n---\n", `can't find template "nowhere.yaml" to extend`},
+		"cycle":        {filepath.Join(repository, "a.yaml"), "---\ntempl:\n  extends: b.yaml\n---\n", "extends cycle"},
+	}
+
+	for name, test := range tests {
+		_, err := templates.RetrieveTemplateVariables(test.path, test.template)
+
+		var templateErr templates.TemplateError
+		if !errors.As(err, &templateErr) {
+			t.Fatalf("%s: expected a TemplateError, got %v", name, err)
+		}
+
+		if templateErr.Line != 3 || !strings.Contains(templateErr.Message, test.message) {
+			t.Errorf("%s: expected <%s> on line 3, received <%v>", name, test.message, templateErr)
+		}
+	}
+}
+
+func TestExtendsErrorsPointAtTheBase(t *testing.T) {
+	repository := includeRepository(t, map[string]string{"base.yaml": "base\n  {{ .port | len }}\n{{ block \"body\" . }}{{ end }}"})
+
+	_, err := templates.RenderFromStdin("---\ntempl:\n  extends: base.yaml\n---\n{{ define \"body\" }}{{ .name }}{{ end }}", []string{"port:int=80", "name=shop"}, templates.RenderOptions{})
+
+	var templateErr templates.TemplateError
+	if !errors.As(err, &templateErr) {
+		t.Fatalf("Expected a TemplateError, got %v", err)
+	}
+
+	if templateErr.Template != filepath.Join(repository, "base.yaml") || templateErr.Line != 2 || templateErr.Column != 14 {
+		t.Errorf("Expected an error at base.yaml:2:14, received <%v>", templateErr)
+	}
+}
@@ -0,0 +1,262 @@
+package templates
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/sirupsen/logrus"
+	ctyjson "github.com/zclconf/go-cty/cty/json"
+	"gopkg.in/yaml.v3"
+)
+
+// VariablesFormats are the formats variables files can be written in.
+var VariablesFormats = []string{"yaml", "json", "toml", "dotenv", "hcl"}
+
+// LoadVariablesFile reads a variables file into a tree of variables. Nested maps and lists keep their structure and
+// scalars keep their types, so templates can range over lists and reach into maps like .database.host.
+// format is one of VariablesFormats; if it's empty, the format is picked from the file's name:
+// - .json, like package.json, is json;
+// - .toml, like Cargo.toml, is toml;
+// - .env, or a name starting with .env like .env.local, is dotenv;
+// - .hcl and .tfvars, like Terraform's variables files, are hcl;
+// - anything else is yaml.
+func LoadVariablesFile(templateVariablesFilePath string, format string) (map[string]interface{}, error) {
+	content, err := os.ReadFile(templateVariablesFilePath)
+	if err != nil {
+		logrus.Error("Failed to read variables file at path <", templateVariablesFilePath, "> Err is: ", err)
+		return nil, err
+	}
+
+	if format == "" {
+		format = variablesFormat(templateVariablesFilePath)
+	}
+
+	var data map[string]interface{}
+
+	switch format {
+	case "yaml":
+		data, err = parseYamlVariables(content)
+	case "json":
+		data, err = parseJsonVariables(content)
+	case "toml":
+		data, err = parseTomlVariables(content)
+	case "dotenv":
+		data, err = parseDotenvVariables(content)
+	case "hcl":
+		data, err = parseHclVariables(content, templateVariablesFilePath)
+	default:
+		return nil, TemplateVariableErr{ErrorMessage: fmt.Sprintf("unknown variables format <%s>, expected one of %s", format, strings.Join(VariablesFormats, ", "))}
+	}
+
+	if err != nil {
+		logrus.Error("Failed to parse ", format, " variables file <", templateVariablesFilePath, ">: ", err)
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// variablesFormat picks the format of a variables file from its name.
+func variablesFormat(variablesFilePath string) string {
+	name := strings.ToLower(filepath.Base(variablesFilePath))
+
+	switch {
+	case strings.HasSuffix(name, ".json"):
+		return "json"
+	case strings.HasSuffix(name, ".toml"):
+		return "toml"
+	case strings.HasSuffix(name, ".env") || strings.HasPrefix(name, ".env"):
+		return "dotenv"
+	case strings.HasSuffix(name, ".hcl") || strings.HasSuffix(name, ".tfvars"):
+		return "hcl"
+	}
+
+	return "yaml"
+}
+
+func parseYamlVariables(content []byte) (map[string]interface{}, error) {
+	// Create a map to store the parsed YAML data
+	data := make(map[string]interface{})
+
+	// Unmarshal the YAML data into the map
+	err := yaml.Unmarshal(content, &data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func parseJsonVariables(content []byte) (map[string]interface{}, error) {
+	data := make(map[string]interface{})
+
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+
+	err := decoder.Decode(&data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return normalizeVariables(data).(map[string]interface{}), nil
+}
+
+func parseTomlVariables(content []byte) (map[string]interface{}, error) {
+	data := make(map[string]interface{})
+
+	err := toml.Unmarshal(content, &data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return normalizeVariables(data).(map[string]interface{}), nil
+}
+
+// parseDotenvVariables reads KEY=VALUE lines, the way docker compose and most dotenv libraries do: blank lines and
+// # comments are skipped, a leading export is allowed, "double quoted" values understand \n style escapes, 'single
+// quoted' values are taken as they are, and unquoted values end at a " #" comment. Like environment variables, a
+// double underscore in a key nests, so database__host=x sets database.host.
+func parseDotenvVariables(content []byte) (map[string]interface{}, error) {
+	data := make(map[string]interface{})
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	lineNumber := 0
+
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		line = strings.TrimSpace(strings.TrimPrefix(line, "export "))
+		key, value, found := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+
+		if !found || key == "" {
+			return nil, fmt.Errorf("line %d: expected KEY=VALUE, got <%s>", lineNumber, line)
+		}
+
+		value = strings.TrimSpace(value)
+
+		switch {
+		case strings.HasPrefix(value, `"`):
+			end := strings.LastIndex(value, `"`)
+			if end == 0 {
+				return nil, fmt.Errorf("line %d: unterminated double quoted value", lineNumber)
+			}
+			unquoted, err := strconv.Unquote(value[:end+1])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", lineNumber, err)
+			}
+			value = unquoted
+		case strings.HasPrefix(value, "'"):
+			end := strings.LastIndex(value, "'")
+			if end == 0 {
+				return nil, fmt.Errorf("line %d: unterminated single quoted value", lineNumber)
+			}
+			value = value[1:end]
+		default:
+			if comment := strings.Index(value, " #"); comment >= 0 {
+				value = strings.TrimSpace(value[:comment])
+			}
+		}
+
+		setVariable(data, strings.Split(key, "__"), value)
+	}
+
+	return data, scanner.Err()
+}
+
+// parseHclVariables reads the attributes of an hcl file, like a Terraform .tfvars file. Expressions are evaluated
+// without any variables or functions, so they have to be literal values.
+func parseHclVariables(content []byte, filename string) (map[string]interface{}, error) {
+	file, diagnostics := hclsyntax.ParseConfig(content, filename, hcl.InitialPos)
+
+	if diagnostics.HasErrors() {
+		return nil, diagnostics
+	}
+
+	attributes, diagnostics := file.Body.JustAttributes()
+
+	if diagnostics.HasErrors() {
+		return nil, diagnostics
+	}
+
+	data := make(map[string]interface{})
+
+	for name, attribute := range attributes {
+		value, diagnostics := attribute.Expr.Value(nil)
+
+		if diagnostics.HasErrors() {
+			return nil, diagnostics
+		}
+
+		// cty's json encoding is the simplest road from an hcl value to plain go values.
+		encoded, err := ctyjson.SimpleJSONValue{Value: value}.MarshalJSON()
+
+		if err != nil {
+			return nil, err
+		}
+
+		decoder := json.NewDecoder(bytes.NewReader(encoded))
+		decoder.UseNumber()
+
+		var decoded interface{}
+		err = decoder.Decode(&decoded)
+
+		if err != nil {
+			return nil, err
+		}
+
+		data[name] = normalizeVariables(decoded)
+	}
+
+	return data, nil
+}
+
+// normalizeVariables converts what the json, toml and hcl decoders hand back into the types yaml uses, so that every
+// format produces the same variables tree: whole numbers are ints, other numbers are float64s, and lists and maps are
+// []interface{} and map[string]interface{}.
+func normalizeVariables(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = normalizeVariables(item)
+		}
+		return v
+	case []interface{}:
+		for i, item := range v {
+			v[i] = normalizeVariables(item)
+		}
+		return v
+	case []map[string]interface{}:
+		list := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			list = append(list, normalizeVariables(item))
+		}
+		return list
+	case json.Number:
+		if i, err := strconv.Atoi(v.String()); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case int64:
+		return int(v)
+	}
+
+	return value
+}
@@ -0,0 +1,130 @@
+package templates_test
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"templ/templates"
+	"templ/test_helpers"
+	"testing"
+)
+
+func TestLoadVariablesFileFormats(t *testing.T) {
+	expected := map[string]interface{}{
+		"name":     "shop",
+		"replicas": 3,
+		"debug":    true,
+		"ratio":    0.5,
+		"services": []interface{}{"api", "worker"},
+		"database": map[string]interface{}{"host": "db.internal", "port": 5432},
+	}
+
+	files := map[string]string{
+		"package.json": `{"name": "shop", "replicas": 3, "debug": true, "ratio": 0.5, "services": ["api", "worker"],
+"database": {"host": "db.internal", "port": 5432}}`,
+		"Cargo.toml": `name = "shop"
+replicas = 3
+debug = true
+ratio = 0.5
+services = ["api", "worker"]
+
+[database]
+host = "db.internal"
+port = 5432
+`,
+		"prod.tfvars": `name     = "shop"
+replicas = 3
+debug    = true
+ratio    = 0.5
+services = ["api", "worker"]
+database = {
+  host = "db.internal"
+  port = 5432
+}
+`,
+		"values.yaml": `name: shop
+replicas: 3
+debug: true
+ratio: 0.5
+services: [api, worker]
+database: {host: db.internal, port: 5432}
+`,
+	}
+
+	dir := t.TempDir()
+	err := test_helpers.WriteFiles(dir, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name := range files {
+		variables, err := templates.LoadVariablesFile(filepath.Join(dir, name), "")
+
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(variables, expected) {
+			t.Errorf("%s: expected <%v>, received <%v>", name, expected, variables)
+		}
+	}
+}
+
+func TestLoadDotenvVariablesFile(t *testing.T) {
+	dir := t.TempDir()
+	err := test_helpers.WriteFiles(dir, map[string]string{".env.local": `# settings
+export NAME=shop
+URL="https://x?a=b"
+GREETING="hello\nworld"
+LITERAL='no $expansion # here'
+PORT=8080 # the port
+database__host=db.internal
+`})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	variables, err := templates.LoadVariablesFile(filepath.Join(dir, ".env.local"), "")
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	expected := map[string]interface{}{
+		"NAME":     "shop",
+		"URL":      "https://x?a=b",
+		"GREETING": "hello\nworld",
+		"LITERAL":  "no $expansion # here",
+		"PORT":     "8080",
+		"database": map[string]interface{}{"host": "db.internal"},
+	}
+
+	if !reflect.DeepEqual(variables, expected) {
+		t.Errorf("Expected <%v>, received <%v>", expected, variables)
+	}
+}
+
+func TestLoadVariablesFileWithFormatOverride(t *testing.T) {
+	dir := t.TempDir()
+	err := test_helpers.WriteFiles(dir, map[string]string{"settings.conf": `NAME=shop`})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	variables, err := templates.LoadVariablesFile(filepath.Join(dir, "settings.conf"), "dotenv")
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if !reflect.DeepEqual(variables, map[string]interface{}{"NAME": "shop"}) {
+		t.Errorf("Expected NAME=shop, received <%v>", variables)
+	}
+
+	_, err = templates.LoadVariablesFile(filepath.Join(dir, "settings.conf"), "ini")
+
+	if !errors.Is(err, templates.TemplateVariableErr{}) {
+		t.Errorf("An unknown format should raise a TemplateVariableErr, got %v", err)
+	}
+}
@@ -0,0 +1,401 @@
+package templates
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Metadata is what a template declares about itself in its front-matter. Front-matter is an optional yaml document at
+// the very top of a template file with a single templ key, fenced by --- lines:
+//
+//	---
+//	templ:
+//	  variables:
+//	    REPLICAS:
+//	      description: how many pods to run
+//	      type: int
+//	      default: 2
+//	  hooks:
+//	    - go mod tidy
+//	---
+//
+// A yaml document without the templ key is part of the template, so plain yaml templates that start with --- are safe.
+// A directory template's metadata is in its DirectoryMetadataFile instead.
+type Metadata struct {
+	Variables []Variable
+	// Extends names the base template this one is rendered through, found the same way as an include. See loadBases.
+	Extends string
+	// Hooks are the commands to run after the template is rendered to files. See runHooks.
+	Hooks []Hook
+
+	// The line of the template file extends is on.
+	extendsLine int
+}
+
+// Variable is a template variable and whatever the template's front-matter declares about it.
+type Variable struct {
+	// Name is the variable's path in the variables tree, like NAME or database.host.
+	Name        string
+	Description string
+	Default     interface{}
+	// Type is one of string, int, bool, list or map. Values are converted to the type before rendering, so that
+	// REPLICAS=3 on the command line renders as a number.
+	Type     string
+	Required bool
+	// Choices are the only values the variable is allowed to take.
+	Choices []interface{}
+	// When is a go template condition, like .USE_DATABASE or eq .ENVIRONMENT "prod". The variable is only asked for,
+	// and only required, when the condition holds.
+	When string
+	// References are the places the template uses the variable.
+	References []Reference
+
+	// The line of the template file the variable is declared on.
+	line int
+}
+
+var variableTypes = []string{"string", "int", "bool", "list", "map"}
+
+// String describes the variable the way the -v flag prints it.
+func (v Variable) String() string {
+	var details []string
+
+	if v.Type != "" {
+		details = append(details, v.Type)
+	}
+
+	if v.Required {
+		details = append(details, "required")
+	}
+
+	if v.Default != nil {
+		details = append(details, fmt.Sprintf("default: %v", v.Default))
+	}
+
+	if v.When != "" {
+		details = append(details, "when: "+v.When)
+	}
+
+	if len(v.Choices) > 0 {
+		choices := make([]string, 0, len(v.Choices))
+		for _, choice := range v.Choices {
+			choices = append(choices, fmt.Sprint(choice))
+		}
+		details = append(details, "one of: "+strings.Join(choices, "|"))
+	}
+
+	description := v.Name
+
+	if len(details) > 0 {
+		description += " (" + strings.Join(details, ", ") + ")"
+	}
+
+	if v.Description != "" {
+		description += " - " + v.Description
+	}
+
+	return description
+}
+
+type frontMatterDocument struct {
+	Templ *struct {
+		Variables yaml.Node `yaml:"variables"`
+		Extends   yaml.Node `yaml:"extends"`
+		Hooks     yaml.Node `yaml:"hooks"`
+	} `yaml:"templ"`
+}
+
+type variableDeclaration struct {
+	Description string        `yaml:"description"`
+	Default     interface{}   `yaml:"default"`
+	Type        string        `yaml:"type"`
+	Required    bool          `yaml:"required"`
+	Choices     []interface{} `yaml:"choices"`
+	When        string        `yaml:"when"`
+}
+
+// parseFrontMatter splits a template into its metadata and its body. headerLines is the number of lines the
+// front-matter takes up, so that positions in the body can be reported as positions in the file. A template without
+// front-matter comes back whole, with nil metadata. A byte order mark in front of the front-matter is dropped.
+func parseFrontMatter(templateText string) (metadata *Metadata, body string, headerLines int, err error) {
+	templateText = strings.TrimPrefix(templateText, byteOrderMark)
+	firstLine, rest, found := strings.Cut(templateText, "\n")
+
+	if !found || strings.TrimRight(firstLine, "\r") != "---" {
+		return nil, templateText, 0, nil
+	}
+
+	var header strings.Builder
+	closed := false
+
+	for rest != "" {
+		var line string
+		line, rest, _ = strings.Cut(rest, "\n")
+
+		if strings.TrimRight(line, "\r") == "---" {
+			closed = true
+			break
+		}
+
+		header.WriteString(line)
+		header.WriteString("\n")
+	}
+
+	if !closed {
+		return nil, templateText, 0, nil
+	}
+
+	var document frontMatterDocument
+
+	// Anything that doesn't parse, or doesn't have the templ key, is yaml that belongs to the template.
+	if yaml.Unmarshal([]byte(header.String()), &document) != nil || document.Templ == nil {
+		return nil, templateText, 0, nil
+	}
+
+	body = templateText[len(templateText)-len(rest):]
+	headerLines = strings.Count(templateText[:len(templateText)-len(rest)], "\n")
+
+	// The header's own line numbers start after the opening ---.
+	metadata, err = document.metadata(1)
+
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	return metadata, body, headerLines, nil
+}
+
+// metadata is the Metadata a front-matter document declares. offset is the number of lines of the file before the
+// document, so that problems are reported by their line in the file.
+func (d frontMatterDocument) metadata(offset int) (*Metadata, error) {
+	metadata := &Metadata{}
+	declarations := d.Templ.Variables
+
+	if extends := d.Templ.Extends; extends.Kind != 0 {
+		if extends.Kind != yaml.ScalarNode || extends.Value == "" {
+			return nil, TemplateVariableErr{ErrorMessage: fmt.Sprintf("line %d: extends must be the name of a template", extends.Line+offset)}
+		}
+
+		metadata.Extends, metadata.extendsLine = extends.Value, extends.Line+offset
+	}
+
+	hooks, err := parseHooks(d.Templ.Hooks, offset)
+
+	if err != nil {
+		return nil, err
+	}
+
+	metadata.Hooks = hooks
+
+	if declarations.Kind != 0 && declarations.Kind != yaml.MappingNode {
+		return nil, TemplateVariableErr{ErrorMessage: fmt.Sprintf("line %d: front-matter variables must be a map of variable names", declarations.Line+offset)}
+	}
+
+	for i := 0; i+1 < len(declarations.Content); i += 2 {
+		nameNode, declarationNode := declarations.Content[i], declarations.Content[i+1]
+
+		var declaration variableDeclaration
+
+		// A variable declared with nothing but its name is allowed, and so is one declared with just a description.
+		if declarationNode.Kind == yaml.ScalarNode && declarationNode.Tag != "!!null" {
+			declaration.Description = declarationNode.Value
+		} else if declarationNode.Tag != "!!null" {
+			err := declarationNode.Decode(&declaration)
+
+			if err != nil {
+				return nil, TemplateVariableErr{ErrorMessage: fmt.Sprintf("line %d: variable %s: %v", nameNode.Line+offset, nameNode.Value, err)}
+			}
+		}
+
+		if declaration.Type != "" && !slices.Contains(variableTypes, declaration.Type) {
+			return nil, TemplateVariableErr{ErrorMessage: fmt.Sprintf("line %d: variable %s has unknown type %s, expected one of %s",
+				nameNode.Line+offset, nameNode.Value, declaration.Type, strings.Join(variableTypes, ", "))}
+		}
+
+		metadata.Variables = append(metadata.Variables, Variable{
+			Name:        nameNode.Value,
+			Description: declaration.Description,
+			Default:     declaration.Default,
+			Type:        declaration.Type,
+			Required:    declaration.Required,
+			Choices:     declaration.Choices,
+			When:        declaration.When,
+			line:        nameNode.Line + offset,
+		})
+	}
+
+	return metadata, nil
+}
+
+// hasFrontMatter reports whether a template starts with front-matter.
+func hasFrontMatter(templateText string) bool {
+	_, _, headerLines, err := parseFrontMatter(templateText)
+	return err != nil || headerLines > 0
+}
+
+// apply returns a copy of variables with the declared defaults filled in and every declared variable converted to its
+// type. It fails if a required variable is missing or a value isn't one of its choices.
+func (m *Metadata) apply(templatePath string, variables map[string]interface{}) (map[string]interface{}, error) {
+	if m == nil {
+		return variables, nil
+	}
+
+	applied := copyVariables(variables)
+	var missing []MissingVariable
+
+	for _, variable := range m.Variables {
+		path := strings.Split(variable.Name, ".")
+		value, found := lookupVariable(applied, path)
+
+		if !found && variable.Default != nil {
+			value, found = variable.Default, true
+		}
+
+		if !found {
+			applies, err := variable.applies(applied)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if variable.Required && applies {
+				missing = append(missing, MissingVariable{Name: variable.Name, Lines: []int{variable.line}})
+			}
+			continue
+		}
+
+		value, err := convertVariable(variable, value)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if len(variable.Choices) > 0 && !isChoice(variable.Choices, value) {
+			return nil, TemplateVariableErr{ErrorMessage: fmt.Sprintf("%s: variable %s is %v, expected one of %v", templatePath, variable.Name, value, variable.Choices)}
+		}
+
+		setVariable(applied, path, value)
+	}
+
+	if len(missing) > 0 {
+		return nil, MissingVariablesErr{Template: templatePath, Missing: missing}
+	}
+
+	return applied, nil
+}
+
+// convertVariable converts a value to the variable's declared type. Strings, which is what the command line hands
+// over, are parsed; anything else has to be of the right type already.
+func convertVariable(variable Variable, value interface{}) (interface{}, error) {
+	invalid := func() error {
+		return TemplateVariableErr{ErrorMessage: fmt.Sprintf("variable %s is %v, which is not a valid %s", variable.Name, value, variable.Type)}
+	}
+
+	text, isString := value.(string)
+
+	switch variable.Type {
+	case "string":
+		if isString {
+			return text, nil
+		}
+		switch value.(type) {
+		case map[string]interface{}, map[interface{}]interface{}, []interface{}:
+			return nil, invalid()
+		}
+		return fmt.Sprint(value), nil
+	case "int":
+		switch v := value.(type) {
+		case int:
+			return v, nil
+		case float64:
+			if v == float64(int(v)) {
+				return int(v), nil
+			}
+		case string:
+			i, err := strconv.Atoi(strings.TrimSpace(v))
+			if err == nil {
+				return i, nil
+			}
+		}
+		return nil, invalid()
+	case "bool":
+		switch v := value.(type) {
+		case bool:
+			return v, nil
+		case string:
+			b, err := strconv.ParseBool(strings.TrimSpace(v))
+			if err == nil {
+				return b, nil
+			}
+		}
+		return nil, invalid()
+	case "list":
+		switch v := value.(type) {
+		case []interface{}:
+			return v, nil
+		case string:
+			list := []interface{}{}
+			for _, item := range strings.Split(v, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					list = append(list, item)
+				}
+			}
+			return list, nil
+		}
+		return nil, invalid()
+	case "map":
+		switch value.(type) {
+		case map[string]interface{}, map[interface{}]interface{}:
+			return value, nil
+		}
+		return nil, invalid()
+	}
+
+	return value, nil
+}
+
+// applies reports whether the variable's when condition holds for variables. A variable without a condition always
+// applies.
+func (v Variable) applies(variables map[string]interface{}) (bool, error) {
+	if v.When == "" {
+		return true, nil
+	}
+
+	condition, err := template.New("when").Funcs(templateFunctions).Parse("{{ if " + v.When + " }}true{{ end }}")
+
+	if err != nil {
+		return false, TemplateVariableErr{ErrorMessage: fmt.Sprintf("variable %s has an invalid when condition <%s>: %v", v.Name, v.When, err)}
+	}
+
+	var result strings.Builder
+	err = condition.Execute(&result, variables)
+
+	if err != nil {
+		return false, TemplateVariableErr{ErrorMessage: fmt.Sprintf("variable %s: could not evaluate when condition <%s>: %v", v.Name, v.When, err)}
+	}
+
+	return result.String() == "true", nil
+}
+
+func isChoice(choices []interface{}, value interface{}) bool {
+	for _, choice := range choices {
+		if fmt.Sprint(choice) == fmt.Sprint(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// lineKeeper is a template comment spanning lines newlines, so that a template body rendered without its
+// front-matter still has its actions on the same lines as in the file.
+func lineKeeper(lines int) string {
+	if lines == 0 {
+		return ""
+	}
+	return leftDelim + "/*" + strings.Repeat("\n", lines) + "*/" + rightDelim
+}
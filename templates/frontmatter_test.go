@@ -0,0 +1,149 @@
+package templates_test
+
+import (
+	"errors"
+	"reflect"
+	"templ/templates"
+	"testing"
+)
+
+const frontMatterTemplate = `---
+templ:
+  variables:
+    NAME:
+      description: the name of the service
+      required: true
+    REPLICAS:
+      description: how many pods to run
+      type: int
+      default: 2
+    ENVIRONMENT:
+      default: dev
+      choices: [dev, prod]
+    DEBUG:
+      type: bool
+      default: false
+---
+name: {{ .NAME }}
+replicas: {{ .REPLICAS }}{{ if gt .REPLICAS 1 }} (scaled){{ end }}
+environment: {{ .ENVIRONMENT }}
+debug: {{ if .DEBUG }}on{{ else }}off{{ end }}
+owner: {{ .OWNER }}`
+
+func TestFrontMatterIsStrippedAndDefaultsApplied(t *testing.T) {
+	hydratedTemplate, err := templates.RenderFromStdin(frontMatterTemplate, []string{"NAME=shop", "OWNER=me", "DEBUG=true"}, templates.RenderOptions{})
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	expected := `name: shop
+replicas: 2 (scaled)
+environment: dev
+debug: on
+owner: me`
+	if hydratedTemplate != expected {
+		t.Errorf("Expected <%s>, received <%s>", expected, hydratedTemplate)
+	}
+}
+
+func TestFrontMatterConvertsCommandLineValuesToTheirType(t *testing.T) {
+	hydratedTemplate, err := templates.RenderFromStdin(frontMatterTemplate, []string{"NAME=shop", "OWNER=me", "REPLICAS=1"}, templates.RenderOptions{})
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	expected := `name: shop
+replicas: 1
+environment: dev
+debug: off
+owner: me`
+	if hydratedTemplate != expected {
+		t.Errorf("Expected <%s>, received <%s>", expected, hydratedTemplate)
+	}
+}
+
+func TestFrontMatterRequiredVariable(t *testing.T) {
+	_, err := templates.RenderFromStdin(frontMatterTemplate, []string{"OWNER=me"}, templates.RenderOptions{})
+
+	var missingErr templates.MissingVariablesErr
+	if !errors.As(err, &missingErr) {
+		t.Fatalf("Expected a MissingVariablesErr, got %v", err)
+	}
+
+	expected := []templates.MissingVariable{{Name: "NAME", Lines: []int{4}}}
+	if !reflect.DeepEqual(missingErr.Missing, expected) {
+		t.Errorf("Expected <%v>, received <%v>", expected, missingErr.Missing)
+	}
+}
+
+func TestFrontMatterChoicesAndTypes(t *testing.T) {
+	for _, definition := range []string{"ENVIRONMENT=staging", "REPLICAS=lots"} {
+		_, err := templates.RenderFromStdin(frontMatterTemplate, []string{"NAME=shop", definition}, templates.RenderOptions{})
+
+		if !errors.Is(err, templates.TemplateVariableErr{}) {
+			t.Errorf("%s should raise a TemplateVariableErr, got %v", definition, err)
+		}
+	}
+}
+
+func TestFrontMatterKeepsLineNumbers(t *testing.T) {
+	_, err := templates.RenderFromStdin(frontMatterTemplate, []string{"NAME=shop"}, templates.RenderOptions{Strict: true})
+
+	var missingErr templates.MissingVariablesErr
+	if !errors.As(err, &missingErr) {
+		t.Fatalf("Expected a MissingVariablesErr, got %v", err)
+	}
+
+	expected := []templates.MissingVariable{{Name: "OWNER", Lines: []int{22}}}
+	if !reflect.DeepEqual(missingErr.Missing, expected) {
+		t.Errorf("Expected <%v>, received <%v>", expected, missingErr.Missing)
+	}
+}
+
+func TestYamlDocumentWithoutTemplKeyIsNotFrontMatter(t *testing.T) {
+	template := `---
+apiVersion: v1
+---
+kind: {{ .KIND }}`
+
+	hydratedTemplate, err := templates.RenderFromStdin(template, []string{"KIND=Service"}, templates.RenderOptions{})
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	expected := `---
+apiVersion: v1
+---
+kind: Service`
+	if hydratedTemplate != expected {
+		t.Errorf("Expected <%s>, received <%s>", expected, hydratedTemplate)
+	}
+}
+
+func TestRetrieveVariablesShowsFrontMatter(t *testing.T) {
+	variables, err := templates.RetrieveVariables(frontMatterTemplate)
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	expected := []string{
+		"NAME (required) - the name of the service",
+		"REPLICAS (int, default: 2) - how many pods to run",
+		"ENVIRONMENT (default: dev, one of: dev|prod)",
+		"DEBUG (bool, default: false)",
+		"OWNER",
+	}
+
+	described := []string{}
+	for _, variable := range variables {
+		described = append(described, variable.String())
+	}
+
+	if !reflect.DeepEqual(described, expected) {
+		t.Errorf("Expected <%v>, received <%v>", expected, described)
+	}
+}
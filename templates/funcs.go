@@ -0,0 +1,271 @@
+package templates
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+// templateFunctions are the functions every template can call, on top of the ones text/template provides. They follow
+// Sprig's names and argument order, so the value being worked on comes last and can be piped in:
+// {{ .name | trimSuffix "-svc" | upper | quote }}. The README lists them all.
+var templateFunctions = template.FuncMap{
+	// Strings
+	"upper":      strings.ToUpper,
+	"lower":      strings.ToLower,
+	"title":      title,
+	"trim":       strings.TrimSpace,
+	"trimPrefix": func(prefix string, s string) string { return strings.TrimPrefix(s, prefix) },
+	"trimSuffix": func(suffix string, s string) string { return strings.TrimSuffix(s, suffix) },
+	"replace":    func(old string, new string, s string) string { return strings.ReplaceAll(s, old, new) },
+	"contains":   func(substring string, s string) bool { return strings.Contains(s, substring) },
+	"hasPrefix":  func(prefix string, s string) bool { return strings.HasPrefix(s, prefix) },
+	"hasSuffix":  func(suffix string, s string) bool { return strings.HasSuffix(s, suffix) },
+	"repeat":     func(count int, s string) string { return strings.Repeat(s, count) },
+	"trunc":      trunc,
+	"quote":      func(value interface{}) string { return strconv.Quote(toString(value)) },
+	"squote":     func(value interface{}) string { return "'" + toString(value) + "'" },
+	"toString":   toString,
+	"split":      func(separator string, s string) []string { return strings.Split(s, separator) },
+	"join":       join,
+
+	// Indentation
+	"indent":  indent,
+	"nindent": func(spaces int, s string) string { return "\n" + indent(spaces, s) },
+
+	// Defaults
+	"default": func(fallback interface{}, value interface{}) interface{} {
+		return ternary(fallback, value, empty(value))
+	},
+	"empty":    empty,
+	"coalesce": coalesce,
+	"ternary": func(whenTrue interface{}, whenFalse interface{}, condition bool) interface{} {
+		return ternary(whenTrue, whenFalse, condition)
+	},
+	"required": required,
+	"fail":     func(message string) (string, error) { return "", errors.New(message) },
+
+	// Lists and maps
+	"list":   func(items ...interface{}) []interface{} { return items },
+	"dict":   dict,
+	"keys":   keys,
+	"hasKey": func(m map[string]interface{}, key string) bool { _, found := m[key]; return found },
+
+	// Templates. Each template set replaces include with one that renders its own templates; see templateSet.include.
+	"include": func(name string, data interface{}) (string, error) {
+		return "", fmt.Errorf("can't find template %q to include", name)
+	},
+
+	// Encoding
+	"toYaml":       toYaml,
+	"toJson":       toJson,
+	"toPrettyJson": toPrettyJson,
+	"b64enc":       func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) },
+	"b64dec":       b64dec,
+	"sha256sum":    func(s string) string { sum := sha256.Sum256([]byte(s)); return hex.EncodeToString(sum[:]) },
+	"sha1sum":      func(s string) string { sum := sha1.Sum([]byte(s)); return hex.EncodeToString(sum[:]) },
+}
+
+// title upper cases the first letter of every word.
+func title(s string) string {
+	previous := ' '
+
+	return strings.Map(func(r rune) rune {
+		defer func() { previous = r }()
+
+		if unicode.IsSpace(previous) {
+			return unicode.ToTitle(r)
+		}
+		return r
+	}, s)
+}
+
+// trunc cuts s down to length characters. A negative length keeps the last characters instead.
+func trunc(length int, s string) string {
+	runes := []rune(s)
+
+	switch {
+	case length < 0 && -length < len(runes):
+		return string(runes[len(runes)+length:])
+	case length >= 0 && length < len(runes):
+		return string(runes[:length])
+	}
+
+	return s
+}
+
+// toString formats any value as a string. nil is the empty string rather than <nil>.
+func toString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case error:
+		return v.Error()
+	}
+
+	return fmt.Sprint(value)
+}
+
+// join joins the items of a list with separator.
+func join(separator string, list interface{}) string {
+	switch l := list.(type) {
+	case []string:
+		return strings.Join(l, separator)
+	case nil:
+		return ""
+	}
+
+	v := reflect.ValueOf(list)
+
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return toString(list)
+	}
+
+	items := make([]string, 0, v.Len())
+	for i := 0; i < v.Len(); i++ {
+		items = append(items, toString(v.Index(i).Interface()))
+	}
+
+	return strings.Join(items, separator)
+}
+
+// indent puts spaces in front of every line of s.
+func indent(spaces int, s string) string {
+	padding := strings.Repeat(" ", spaces)
+	return padding + strings.ReplaceAll(s, "\n", "\n"+padding)
+}
+
+// empty reports whether a value is missing or its type's zero value: nil, false, 0, "" and empty lists and maps.
+func empty(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+
+	v := reflect.ValueOf(value)
+
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Pointer, reflect.Interface:
+		return v.IsNil()
+	}
+
+	return v.IsZero()
+}
+
+// coalesce returns the first value that isn't empty.
+func coalesce(values ...interface{}) interface{} {
+	for _, value := range values {
+		if !empty(value) {
+			return value
+		}
+	}
+	return nil
+}
+
+func ternary(whenTrue interface{}, whenFalse interface{}, condition bool) interface{} {
+	if condition {
+		return whenTrue
+	}
+	return whenFalse
+}
+
+// required stops the render with message when value is missing or an empty string.
+func required(message string, value interface{}) (interface{}, error) {
+	if s, isString := value.(string); value == nil || (isString && s == "") {
+		return nil, errors.New(message)
+	}
+	return value, nil
+}
+
+// dict makes a map from key, value pairs.
+func dict(pairs ...interface{}) (map[string]interface{}, error) {
+	if len(pairs)%2 != 0 {
+		return nil, errors.New("dict needs a value for every key")
+	}
+
+	m := make(map[string]interface{}, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		m[toString(pairs[i])] = pairs[i+1]
+	}
+
+	return m, nil
+}
+
+// keys returns the keys of a map, sorted.
+func keys(m map[string]interface{}) []string {
+	sorted := make([]string, 0, len(m))
+	for key := range m {
+		sorted = append(sorted, key)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+// toYaml writes a value as yaml, without the trailing newline, so that it can be piped into nindent.
+func toYaml(value interface{}) (string, error) {
+	encoded, err := yaml.Marshal(value)
+
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSuffix(string(encoded), "\n"), nil
+}
+
+func toJson(value interface{}) (string, error) {
+	encoded, err := json.Marshal(jsonValue(value))
+	return string(encoded), err
+}
+
+func toPrettyJson(value interface{}) (string, error) {
+	encoded, err := json.MarshalIndent(jsonValue(value), "", "  ")
+	return string(encoded), err
+}
+
+// jsonValue converts the map[interface{}]interface{} maps yaml can hand back, which json can't encode.
+func jsonValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			m[fmt.Sprint(key)] = jsonValue(item)
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			m[key] = jsonValue(item)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, item := range v {
+			l[i] = jsonValue(item)
+		}
+		return l
+	}
+
+	return value
+}
+
+func b64dec(s string) (string, error) {
+	decoded, err := base64.StdEncoding.DecodeString(s)
+	return string(decoded), err
+}
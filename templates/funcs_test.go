@@ -0,0 +1,109 @@
+package templates_test
+
+import (
+	"errors"
+	"strings"
+	"templ/templates"
+	"testing"
+)
+
+func TestTemplateFunctions(t *testing.T) {
+	definitions := []string{
+		"name=shop-svc",
+		"labels.app=shop",
+		"labels.tier=web",
+		"ports:int=[80,443]",
+		"empty=",
+		"owner=me",
+	}
+
+	tests := []struct {
+		template string
+		expected string
+	}{
+		{`{{ .name | upper }} {{ upper "x" | lower }}`, `SHOP-SVC x`},
+		{`{{ title "hello big world" }}`, `Hello Big World`},
+		{`{{ .name | trimSuffix "-svc" | trimPrefix "sh" }}`, `op`},
+		{`{{ trim "  x  " }}`, `x`},
+		{`{{ .name | replace "-" "_" }}`, `shop_svc`},
+		{`{{ contains "svc" .name }} {{ hasPrefix "shop" .name }} {{ hasSuffix "x" .name }}`, `true true false`},
+		{`{{ repeat 3 "ab" }} {{ trunc 4 .name }} {{ trunc -3 .name }}`, `ababab shop svc`},
+		{`{{ .name | quote }} {{ .name | squote }} {{ quote .nothing }}`, `"shop-svc" 'shop-svc' ""`},
+		{`{{ split "-" .name | join "," }} {{ join "+" .ports }}`, `shop,svc 80+443`},
+		{`{{ .missing | default "dev" }} {{ .empty | default "dev" }} {{ .owner | default "dev" }}`, `dev dev me`},
+		{`{{ empty .empty }} {{ empty .ports }} {{ coalesce .missing .empty .owner }}`, `true false me`},
+		{`{{ ternary "on" "off" (eq .owner "me") }}`, `on`},
+		{`{{ required "owner is required" .owner }}`, `me`},
+		{"labels:{{ .labels | toYaml | nindent 2 }}", "labels:\n  app: shop\n  tier: web"},
+		{"{{ indent 2 \"a\\nb\" }}", "  a\n  b"},
+		{`{{ toJson .labels }} {{ toJson .ports }}`, `{"app":"shop","tier":"web"} [80,443]`},
+		{`{{ toPrettyJson (dict "a" 1) }}`, "{\n  \"a\": 1\n}"},
+		{`{{ keys .labels | join "," }} {{ hasKey .labels "app" }} {{ list 1 2 | len }}`, `app,tier true 2`},
+		{`{{ b64enc "hi" }} {{ b64dec "aGk=" }}`, `aGk= hi`},
+		{`{{ sha256sum "a" }}`, `ca978112ca1bbdcafac231b39a23dc4da786eff8147c4e72b9807785afee48bb`},
+		{`{{ sha1sum "a" }}`, `86f7e437faa5a7fce15d1ddcb9eaeaea377667b8`},
+	}
+
+	for _, test := range tests {
+		output, err := templates.RenderFromStdin(test.template, definitions, templates.RenderOptions{})
+
+		if err != nil {
+			t.Errorf("%s: %v", test.template, err)
+			continue
+		}
+
+		if output != test.expected {
+			t.Errorf("%s: expected <%s>, received <%s>", test.template, test.expected, output)
+		}
+	}
+}
+
+func TestRequiredStopsTheRender(t *testing.T) {
+	_, err := templates.RenderFromStdin(`owner: {{ required "owner is required" .owner }}`, []string{"x=1"}, templates.RenderOptions{})
+
+	var templateErr templates.TemplateError
+	if !errors.As(err, &templateErr) {
+		t.Fatalf("Expected a TemplateError, got %v", err)
+	}
+
+	if !strings.Contains(templateErr.Message, "owner is required") || templateErr.Column != 11 {
+		t.Errorf("Expected the required message at column 11, received <%#v>", templateErr)
+	}
+}
+
+func TestFunctionsDontClaimOtherPeoplesBraces(t *testing.T) {
+	template := `image: {{ .Values.image | quote }}
+name: {{ name | default('x') }}
+mine: {{ .name | upper }}`
+
+	output, err := templates.RenderFromStdin(template, []string{"name=shop"}, templates.RenderOptions{})
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	expected := `image: {{ .Values.image | quote }}
+name: {{ name | default('x') }}
+mine: SHOP`
+
+	if output != expected {
+		t.Errorf("Expected <%s>, received <%s>", expected, output)
+	}
+}
+
+func TestWhenConditionsCanCallFunctions(t *testing.T) {
+	template := `---
+templ:
+  variables:
+    DATABASE_HOST:
+      when: hasPrefix "prod" .ENVIRONMENT
+      required: true
+---
+{{ .ENVIRONMENT }}`
+
+	_, err := templates.RenderFromStdin(template, []string{"ENVIRONMENT=production"}, templates.RenderOptions{})
+
+	if !errors.Is(err, templates.MissingVariablesErr{}) {
+		t.Errorf("Expected DATABASE_HOST to be required in production, got %v", err)
+	}
+}
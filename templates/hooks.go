@@ -0,0 +1,494 @@
+package templates
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// DirectoryMetadataFile is where a directory template declares its metadata, like a template file does in its
+// front-matter. It's a yaml document with the same templ key, and isn't rendered along with the rest of the directory:
+//
+//	templ:
+//	  hooks:
+//	    - go mod tidy
+//	    - run: git init
+//	      timeout: 10s
+const DirectoryMetadataFile = ".templ.yaml"
+
+// DefaultHookTimeout is how long a hook may run for, unless it or HookOptions.Timeout says otherwise.
+const DefaultHookTimeout = 2 * time.Minute
+
+// Hook is a command a template declares, to be run after the template is rendered to files.
+type Hook struct {
+	// Run is the command, which is run with sh -c in the directory the template was rendered into. It's a template
+	// itself, rendered with the template's variables, so that go mod init {{ .module }} works.
+	Run string
+	// Timeout is how long the command may run for. Zero is HookOptions.Timeout.
+	Timeout time.Duration
+}
+
+// HookOptions says whether the hooks of the templates rendered are run, and how.
+type HookOptions struct {
+	// Disabled runs no hooks at all.
+	Disabled bool
+	// Timeout is how long each hook may run for, unless it says otherwise. Zero is DefaultHookTimeout.
+	Timeout time.Duration
+	// Approve asks whether the hooks of a template repository, which haven't been approved before, may run. Once they
+	// are, they may until the repository is cloned from somewhere else or its templates declare other commands; see
+	// approvalsFile. Without Approve, hooks that haven't been approved aren't run.
+	Approve func(repository string, commands []string) (bool, error)
+	// Output is where each hook's command and what it printed go, once it's finished. Nil is stderr.
+	Output io.Writer
+}
+
+// HookErr is a hook that failed or ran out of time. What it printed has already been written to HookOptions.Output.
+type HookErr struct {
+	Command string
+	Dir     string
+	Message string
+}
+
+func (h HookErr) Error() string {
+	return fmt.Sprintf("hook <%s> in %s: %s", h.Command, h.Dir, h.Message)
+}
+
+func (h HookErr) Is(target error) bool {
+	_, ok := target.(HookErr)
+	return ok
+}
+
+type hookDeclaration struct {
+	Run     string `yaml:"run"`
+	Timeout string `yaml:"timeout"`
+}
+
+// approvals are the hooks that have been approved, as kept in approvalsFile.
+type approvals struct {
+	Hooks []approval `yaml:"hooks"`
+}
+
+// approval is the hooks of a template repository that have been approved: where the repository was cloned from, and
+// the commands, as its templates declare them, before they're rendered.
+type approval struct {
+	Repository string   `yaml:"repository"`
+	Upstream   string   `yaml:"upstream,omitempty"`
+	Commands   []string `yaml:"commands"`
+}
+
+// parseHooks reads the hooks of a front-matter document, which are a list of commands, or of maps with a run command
+// and a timeout like 30s. offset is the number of lines of the file before the document.
+func parseHooks(hooks yaml.Node, offset int) ([]Hook, error) {
+	if hooks.Kind == 0 {
+		return nil, nil
+	}
+
+	if hooks.Kind != yaml.SequenceNode {
+		return nil, TemplateVariableErr{ErrorMessage: fmt.Sprintf("line %d: hooks must be a list of commands", hooks.Line+offset)}
+	}
+
+	var parsed []Hook
+
+	for _, node := range hooks.Content {
+		var declaration hookDeclaration
+
+		if node.Kind == yaml.ScalarNode {
+			declaration.Run = node.Value
+		} else if err := node.Decode(&declaration); err != nil {
+			return nil, TemplateVariableErr{ErrorMessage: fmt.Sprintf("line %d: hook: %v", node.Line+offset, err)}
+		}
+
+		if strings.TrimSpace(declaration.Run) == "" {
+			return nil, TemplateVariableErr{ErrorMessage: fmt.Sprintf("line %d: a hook needs a command to run", node.Line+offset)}
+		}
+
+		hook := Hook{Run: declaration.Run}
+
+		if declaration.Timeout != "" {
+			timeout, err := time.ParseDuration(declaration.Timeout)
+
+			if err != nil || timeout <= 0 {
+				return nil, TemplateVariableErr{ErrorMessage: fmt.Sprintf("line %d: hook timeout %s isn't a duration like 30s or 5m", node.Line+offset, declaration.Timeout)}
+			}
+
+			hook.Timeout = timeout
+		}
+
+		parsed = append(parsed, hook)
+	}
+
+	return parsed, nil
+}
+
+// readDirectoryMetadata reads the DirectoryMetadataFile of a directory template. A directory without one has nil
+// metadata. Only hooks can be declared in it.
+func readDirectoryMetadata(templateDir string) (*Metadata, error) {
+	path := filepath.Join(templateDir, DirectoryMetadataFile)
+	content, err := os.ReadFile(path)
+
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+
+	if err != nil {
+		_, file, line, _ := runtime.Caller(0)
+		return nil, fmt.Errorf("%s:%d: %v", file, line, err)
+	}
+
+	var document frontMatterDocument
+
+	err = yaml.Unmarshal(content, &document)
+
+	if err != nil {
+		return nil, TemplateVariableErr{ErrorMessage: fmt.Sprintf("%s: %v", path, err)}
+	}
+
+	if document.Templ == nil {
+		return nil, nil
+	}
+
+	if document.Templ.Variables.Kind != 0 || document.Templ.Extends.Kind != 0 {
+		return nil, TemplateVariableErr{ErrorMessage: fmt.Sprintf("%s: a directory template can only declare hooks", path)}
+	}
+
+	metadata, err := document.metadata(0)
+
+	if err != nil {
+		return nil, TemplateVariableErr{ErrorMessage: fmt.Sprintf("%s: %v", path, err)}
+	}
+
+	return metadata, nil
+}
+
+// hookRun is the hooks of one template that was rendered, with their commands rendered, and the directory they run in.
+// declared is the commands as the template declares them.
+type hookRun struct {
+	repository string
+	upstream   string
+	dir        string
+	hooks      []Hook
+	declared   []string
+}
+
+// runHooks runs the hooks of the templates that records say were rendered, in order, each in the directory its
+// template was rendered into. It stops at the first hook that fails, with a HookErr.
+//
+// Hooks only run where something was written, so callers pass only the records of templates whose files were written
+// (see writtenRecords), and none run when options.Writing only compares files, or at all with options.Hooks.Disabled.
+// The hooks of a template repository only run once they're approved: the first time, every command of the repository
+// about to run is put to options.Hooks.Approve, and the approval is kept until the repository is cloned from somewhere
+// else, or declares a command that wasn't approved.
+func runHooks(records []provenanceRecord, options RenderOptions) error {
+	if options.Hooks.Disabled || options.Writing.DiffTo != nil || options.Writing.Check {
+		return nil
+	}
+
+	var runs []hookRun
+
+	for _, record := range records {
+		run, err := newHookRun(record, options)
+
+		if err != nil {
+			return err
+		}
+
+		if len(run.hooks) > 0 {
+			runs = append(runs, run)
+		}
+	}
+
+	if len(runs) == 0 {
+		return nil
+	}
+
+	approved, err := approveHooks(runs, options.Hooks)
+
+	if err != nil {
+		return err
+	}
+
+	out := options.Hooks.Output
+
+	if out == nil {
+		out = os.Stderr
+	}
+
+	for _, run := range runs {
+		if !slices.Contains(approved, run.repository) {
+			continue
+		}
+
+		for _, hook := range run.hooks {
+			err := runHook(hook, run.dir, options.Hooks, out)
+
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// newHookRun reads the hooks of the template a record says was rendered, and renders their commands.
+func newHookRun(record provenanceRecord, options RenderOptions) (hookRun, error) {
+	var metadata *Metadata
+	var err error
+	variables := record.rendering.Variables
+
+	if record.isDirectory {
+		metadata, err = readDirectoryMetadata(record.templatePath)
+	} else {
+		var content []byte
+		content, err = os.ReadFile(record.templatePath)
+
+		if err == nil {
+			metadata, _, _, err = parseFrontMatter(string(content))
+		}
+
+		// A hook's command sees the template's defaults, like the template does.
+		if err == nil {
+			variables, err = metadata.apply(record.templatePath, variables)
+		}
+	}
+
+	if err != nil || metadata == nil {
+		return hookRun{}, err
+	}
+
+	run := hookRun{repository: approvalKey(record), upstream: record.rendering.Upstream, dir: record.dir}
+
+	for _, hook := range metadata.Hooks {
+		run.declared = append(run.declared, hook.Run)
+
+		command, err := renderFromString(record.templatePath, hook.Run, variables, options)
+
+		if err != nil {
+			return hookRun{}, err
+		}
+
+		hook.Run = command
+		run.hooks = append(run.hooks, hook)
+	}
+
+	return run, nil
+}
+
+// approvalKey is what the hooks of a template are approved by: the template's repository in the templates directory,
+// or for a template that isn't in one, the directory it's in.
+func approvalKey(record provenanceRecord) string {
+	if record.rendering.Repository != "" {
+		return record.rendering.Repository
+	}
+
+	if record.isDirectory {
+		return record.rendering.Template
+	}
+
+	return filepath.Dir(record.rendering.Template)
+}
+
+// approveHooks returns the repositories of runs whose hooks may run, asking about the ones that haven't been approved
+// yet. Hooks that aren't approved are logged as not run.
+func approveHooks(runs []hookRun, options HookOptions) ([]string, error) {
+	kept, err := readApprovals()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var repositories []string
+	wanted := make(map[string]*approval)
+	commands := make(map[string][]string)
+
+	for _, run := range runs {
+		if _, found := wanted[run.repository]; !found {
+			repositories = append(repositories, run.repository)
+			wanted[run.repository] = &approval{Repository: run.repository, Upstream: run.upstream}
+		}
+
+		for i, hook := range run.hooks {
+			commands[run.repository] = append(commands[run.repository], hook.Run)
+
+			if !slices.Contains(wanted[run.repository].Commands, run.declared[i]) {
+				wanted[run.repository].Commands = append(wanted[run.repository].Commands, run.declared[i])
+			}
+		}
+	}
+
+	var approved []string
+	newlyApproved := false
+
+	for _, repository := range repositories {
+		want := wanted[repository]
+		index := slices.IndexFunc(kept.Hooks, func(a approval) bool {
+			return a.Repository == want.Repository && a.Upstream == want.Upstream
+		})
+
+		if index >= 0 && isSubset(want.Commands, kept.Hooks[index].Commands) {
+			approved = append(approved, repository)
+			continue
+		}
+
+		if options.Approve == nil {
+			logrus.Warnf("Not running the hooks of %s, which haven't been approved: %s. Render on a terminal to approve them.",
+				repository, strings.Join(commands[repository], "; "))
+			continue
+		}
+
+		ok, err := options.Approve(repository, commands[repository])
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !ok {
+			logrus.Infof("Not running the hooks of %s", repository)
+			continue
+		}
+
+		approved = append(approved, repository)
+		newlyApproved = true
+
+		if index < 0 {
+			kept.Hooks = append(kept.Hooks, *want)
+			continue
+		}
+
+		for _, command := range want.Commands {
+			if !slices.Contains(kept.Hooks[index].Commands, command) {
+				kept.Hooks[index].Commands = append(kept.Hooks[index].Commands, command)
+			}
+		}
+	}
+
+	if newlyApproved {
+		err = writeApprovals(kept)
+	}
+
+	return approved, err
+}
+
+// isSubset reports whether every item of some is in all.
+func isSubset(some []string, all []string) bool {
+	for _, item := range some {
+		if !slices.Contains(all, item) {
+			return false
+		}
+	}
+	return true
+}
+
+// runHook runs one hook in dir and writes its command and what it printed to out.
+func runHook(hook Hook, dir string, options HookOptions, out io.Writer) error {
+	timeout := hook.Timeout
+
+	if timeout == 0 {
+		timeout = options.Timeout
+	}
+
+	if timeout == 0 {
+		timeout = DefaultHookTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var printed bytes.Buffer
+	command := exec.CommandContext(ctx, "sh", "-c", hook.Run)
+	command.Dir = dir
+	command.Stdout, command.Stderr = &printed, &printed
+	// Something the hook started in the background mustn't keep templ waiting once the hook is stopped.
+	command.WaitDelay = time.Second
+
+	err := command.Run()
+
+	fmt.Fprintf(out, "%s$ %s\n", dir, hook.Run)
+	out.Write(printed.Bytes())
+
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return HookErr{Command: hook.Run, Dir: dir, Message: fmt.Sprintf("stopped after %v", timeout)}
+	}
+
+	if err != nil {
+		return HookErr{Command: hook.Run, Dir: dir, Message: err.Error()}
+	}
+
+	return nil
+}
+
+// approvalsFile keeps the hooks that have been approved. It's in templ's state directory, $XDG_STATE_HOME/templ or
+// ~/.local/state/templ, rather than in the config directory, where the templates directory is unless TEMPL_DIR says
+// otherwise, so that it's never taken for a template.
+func approvalsFile() string {
+	state := os.Getenv("XDG_STATE_HOME")
+
+	if state == "" {
+		home, err := os.UserHomeDir()
+
+		if err != nil {
+			home = os.TempDir()
+		}
+
+		state = filepath.Join(home, ".local", "state")
+	}
+
+	return filepath.Join(state, "templ", "approved-hooks.yaml")
+}
+
+func readApprovals() (approvals, error) {
+	var kept approvals
+	content, err := os.ReadFile(approvalsFile())
+
+	if errors.Is(err, fs.ErrNotExist) {
+		return kept, nil
+	}
+
+	if err == nil {
+		err = yaml.Unmarshal(content, &kept)
+	}
+
+	if err != nil {
+		_, file, line, _ := runtime.Caller(0)
+		return kept, fmt.Errorf("%s:%d: %v", file, line, err)
+	}
+
+	return kept, nil
+}
+
+func writeApprovals(kept approvals) error {
+	var buffer bytes.Buffer
+	buffer.WriteString("# Written by templ. The hooks of template repositories that may run.\n---\n")
+	encoder := yaml.NewEncoder(&buffer)
+	encoder.SetIndent(2)
+
+	err := encoder.Encode(kept)
+
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(approvalsFile()), 0755)
+	}
+
+	if err == nil {
+		err = os.WriteFile(approvalsFile(), buffer.Bytes(), 0644)
+	}
+
+	if err != nil {
+		_, file, line, _ := runtime.Caller(0)
+		return fmt.Errorf("%s:%d: %v", file, line, err)
+	}
+
+	return nil
+}
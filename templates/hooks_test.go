@@ -0,0 +1,272 @@
+package templates_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"templ/output"
+	"templ/templates"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+)
+
+// approver approves, or refuses, the hooks of every repository it's asked about, and remembers what it was asked.
+type approver struct {
+	approve  bool
+	asked    []string
+	commands [][]string
+}
+
+func (a *approver) options(output *strings.Builder) templates.HookOptions {
+	return templates.HookOptions{
+		Approve: func(repository string, commands []string) (bool, error) {
+			a.asked = append(a.asked, repository)
+			a.commands = append(a.commands, commands)
+			return a.approve, nil
+		},
+		Output: output,
+	}
+}
+
+func TestHooksRunOnceApproved(t *testing.T) {
+	// Approvals are kept in the state directory.
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	repository := includeRepository(t, map[string]string{
+		"notes.txt": "---\ntempl:\n  variables:\n    owner:\n      default: nobody\n  hooks:\n    - echo {{ .name }} {{ .owner }} > made.txt\n    - run: cat made.txt\n      timeout: 5s\n---\n{{ .name }}'s notes\n",
+	})
+
+	refused := &approver{approve: false}
+	var printed strings.Builder
+	outputDir := t.TempDir()
+	options := templates.RenderOptions{Definitions: []string{"name=shop"}, Output: outputDir, Hooks: refused.options(&printed)}
+
+	err := renderQuietly([]string{filepath.Join(repository, "notes.txt")}, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := [][]string{{"echo shop nobody > made.txt", "cat made.txt"}}
+	if !reflect.DeepEqual(refused.asked, []string{"templates-repo"}) || !reflect.DeepEqual(refused.commands, expected) {
+		t.Errorf("Expected to be asked about templates-repo's <%v>, was asked about <%v>, <%v>", expected, refused.asked, refused.commands)
+	}
+
+	if _, err := os.Stat(filepath.Join(outputDir, "made.txt")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected refused hooks not to run, got %v", err)
+	}
+
+	approved := &approver{approve: true}
+	options.Hooks = approved.options(&printed)
+	options.Writing.Policy = output.Overwrite
+
+	err = renderQuietly([]string{filepath.Join(repository, "notes.txt")}, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	made, err := os.ReadFile(filepath.Join(outputDir, "made.txt"))
+	if err != nil || string(made) != "shop nobody\n" {
+		t.Errorf("Expected the hooks to run in the output directory, received <%s>, %v", made, err)
+	}
+
+	if want := outputDir + "$ cat made.txt\nshop nobody\n"; !strings.HasSuffix(printed.String(), want) {
+		t.Errorf("Expected the hooks' output to end with <%s>, received <%s>", want, printed.String())
+	}
+
+	// Once approved, it's not asked about again.
+	again := &approver{approve: false}
+	options.Hooks = again.options(&strings.Builder{})
+
+	err = renderQuietly([]string{filepath.Join(repository, "notes.txt")}, options)
+	if err != nil || len(again.asked) != 0 {
+		t.Errorf("Expected approved hooks to run without asking, was asked about <%v>, %v", again.asked, err)
+	}
+}
+
+func TestHooksAreAskedAboutAgainWhenTheyChange(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	repository, _ := gitRepository(t, map[string]string{
+		"notes.txt": "---\ntempl:\n  hooks:\n    - echo {{ .name }}\n---\nnotes\n",
+	})
+
+	render := func(name string) []string {
+		asker := &approver{approve: true}
+		options := templates.RenderOptions{Definitions: []string{"name=" + name}, Output: t.TempDir(), Hooks: asker.options(&strings.Builder{})}
+
+		err := renderQuietly([]string{filepath.Join(repository, "notes.txt")}, options)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return asker.asked
+	}
+
+	if asked := render("shop"); len(asked) != 1 {
+		t.Fatalf("Expected to be asked the first time, was asked about <%v>", asked)
+	}
+
+	// The same commands, whatever they're rendered with, are approved already.
+	if asked := render("bakery"); len(asked) != 0 {
+		t.Errorf("Expected not to be asked about the same hooks again, was asked about <%v>", asked)
+	}
+
+	// Commands that weren't approved are asked about...
+	err := os.WriteFile(filepath.Join(repository, "notes.txt"), []byte("---\ntempl:\n  hooks:\n    - echo {{ .name }}\n    - touch {{ .name }}.done\n---\nnotes\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if asked := render("shop"); len(asked) != 1 {
+		t.Errorf("Expected to be asked about a new command, was asked about <%v>", asked)
+	}
+
+	// ...and so is the same repository cloned from somewhere else.
+	gitRepo, err := git.PlainOpen(repository)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = gitRepo.DeleteRemote("origin")
+	if err == nil {
+		_, err = gitRepo.CreateRemote(&config.RemoteConfig{Name: "origin", URLs: []string{"https://example.com/someone-else/templates.git"}})
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if asked := render("shop"); len(asked) != 1 {
+		t.Errorf("Expected to be asked about a repository from another upstream, was asked about <%v>", asked)
+	}
+}
+
+func TestHooksDontRun(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	repository := includeRepository(t, map[string]string{
+		"notes.txt": "---\ntempl:\n  hooks:\n    - touch made.txt\n---\nnotes\n",
+	})
+
+	tests := map[string]func(*templates.RenderOptions){
+		"without anyone to approve them": func(options *templates.RenderOptions) { options.Hooks.Approve = nil },
+		"when they're disabled":          func(options *templates.RenderOptions) { options.Hooks.Disabled = true },
+		"when nothing's written":         func(options *templates.RenderOptions) { options.Writing.Check = true },
+	}
+
+	for name, change := range tests {
+		asker := &approver{approve: true}
+		outputDir := t.TempDir()
+		options := templates.RenderOptions{Output: outputDir, Hooks: asker.options(&strings.Builder{})}
+		change(&options)
+
+		err := renderQuietly([]string{filepath.Join(repository, "notes.txt")}, options)
+		if err != nil && !errors.Is(err, output.DriftErr{}) {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		if _, err := os.Stat(filepath.Join(outputDir, "made.txt")); !errors.Is(err, os.ErrNotExist) || len(asker.asked) != 0 {
+			t.Errorf("%s: expected the hooks not to be asked about or run, was asked about <%v>, %v", name, asker.asked, err)
+		}
+	}
+}
+
+func TestHooksDontRunAgainWhenNothingIsWritten(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	repository := includeRepository(t, map[string]string{
+		"notes.txt": "---\ntempl:\n  hooks:\n    - echo ran >> ran.txt\n---\nnotes\n",
+	})
+
+	outputDir := t.TempDir()
+	asker := &approver{approve: true}
+	options := templates.RenderOptions{Output: outputDir, Hooks: asker.options(&strings.Builder{})}
+
+	for _, policy := range []output.Policy{output.Fail, output.Skip} {
+		options.Writing.Policy = policy
+
+		err := renderQuietly([]string{filepath.Join(repository, "notes.txt")}, options)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ran, err := os.ReadFile(filepath.Join(outputDir, "ran.txt"))
+	if err != nil || string(ran) != "ran\n" {
+		t.Errorf("Expected the hook to run once, when notes.txt was written, received <%s>, %v", ran, err)
+	}
+}
+
+func TestDirectoryHooks(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	repository := includeRepository(t, map[string]string{
+		"skeleton/" + templates.DirectoryMetadataFile: "templ:\n  hooks:\n    - chmod +x {{ .name }}.sh\n",
+		"skeleton/{{ .name }}.sh":                     "echo {{ .name }}\n",
+	})
+
+	outputDir := t.TempDir()
+	asker := &approver{approve: true}
+	options := templates.RenderOptions{Definitions: []string{"name=shop"}, Hooks: asker.options(&strings.Builder{})}
+
+	written, err := templates.RenderDirectory(filepath.Join(repository, "skeleton"), nil, outputDir, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(written, []string{filepath.Join(outputDir, "shop.sh")}) {
+		t.Errorf("Expected only shop.sh to be written, received <%v>", written)
+	}
+
+	info, err := os.Stat(filepath.Join(outputDir, "shop.sh"))
+	if err != nil || info.Mode().Perm()&0100 == 0 {
+		t.Errorf("Expected the hook to make shop.sh executable, got <%v>, %v", info, err)
+	}
+}
+
+func TestHookProblems(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	tests := map[string]struct {
+		template string
+		expected error
+		message  string
+	}{
+		"a hook that fails": {
+			"---\ntempl:\n  hooks:\n    - echo broken; exit 3\n---\n", templates.HookErr{}, "exit status 3",
+		},
+		"a hook that takes too long": {
+			"---\ntempl:\n  hooks:\n    - run: sleep 10\n      timeout: 100ms\n---\n", templates.HookErr{}, "stopped after 100ms",
+		},
+		"hooks that aren't a list": {
+			"---\ntempl:\n  hooks: go mod tidy\n---\n", templates.TemplateVariableErr{}, "line 3: hooks must be a list",
+		},
+		"a hook without a command": {
+			"---\ntempl:\n  hooks:\n    - timeout: 5s\n---\n", templates.TemplateVariableErr{}, "line 4: a hook needs a command",
+		},
+		"a timeout that isn't one": {
+			"---\ntempl:\n  hooks:\n    - run: true\n      timeout: soon\n---\n", templates.TemplateVariableErr{}, "soon isn't a duration",
+		},
+	}
+
+	for name, test := range tests {
+		repository := includeRepository(t, map[string]string{"notes.txt": test.template})
+		asker := &approver{approve: true}
+		options := templates.RenderOptions{Output: t.TempDir(), Hooks: asker.options(&strings.Builder{})}
+
+		start := time.Now()
+		err := renderQuietly([]string{filepath.Join(repository, "notes.txt")}, options)
+
+		if !errors.Is(err, test.expected) || !strings.Contains(err.Error(), test.message) {
+			t.Errorf("%s: expected a %T with <%s>, got %v", name, test.expected, test.message, err)
+		}
+
+		if time.Since(start) > 5*time.Second {
+			t.Errorf("%s: expected the hook to be stopped, it took %v", name, time.Since(start))
+		}
+	}
+}
@@ -0,0 +1,559 @@
+package templates
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"templ/configelements"
+	"templ/templatedirectories"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/sirupsen/logrus"
+)
+
+// maxIncludeDepth stops a template that includes itself from rendering forever.
+const maxIncludeDepth = 100
+
+// templateSet is a template and everything it includes, parsed together so that they share their {{ define }}s.
+type templateSet struct {
+	root *source
+	// sources are the templates of the set, by the name the engine knows them by.
+	sources map[string]*source
+	// helpers are the helper files of the root template's repository, loaded before it.
+	helpers []*source
+	// bases are the templates the root template extends, nearest first.
+	bases []*source
+	// unresolved are includes templ couldn't find and left in the output as they are.
+	unresolved []TemplateError
+}
+
+// helperFiles are the helper files of each repository that's had them listed, by its root. Listing means walking the
+// whole repository, so it's only done once a run, however many templates of the repository are rendered.
+var helperFiles = make(map[string][]string)
+
+// loadHelpers parses the helper files of the root template's repository into tmpl, so that their {{ define }}s can be
+// used by the template and everything it includes. It's called before the template itself is parsed, so that a define
+// of the template's own wins over a helper's of the same name. Only repositories in the templates directory, and their
+// exports, have helpers: a template anywhere else may well be in somebody's own project, whose files aren't templ's.
+func (set *templateSet) loadHelpers(tmpl *template.Template) error {
+	if set.root.path == "stdin" {
+		return nil
+	}
+
+	root := helpersRoot(set.root.path)
+
+	if root == "" {
+		return nil
+	}
+
+	helpers, listed := helperFiles[root]
+
+	if !listed {
+		var err error
+		helpers, err = templatedirectories.Helpers(root)
+
+		if err != nil {
+			return err
+		}
+
+		helperFiles[root] = helpers
+	}
+
+	rootPath, _ := filepath.Abs(set.root.path)
+
+	for _, helperPath := range helpers {
+		// A helper file rendered on its own doesn't need loading twice.
+		if helperPath == rootPath {
+			continue
+		}
+
+		content, err := os.ReadFile(helperPath)
+
+		if err != nil {
+			_, file, line, _ := runtime.Caller(0)
+			return fmt.Errorf("%s:%d: %v", file, line, err)
+		}
+
+		helper, err := prepareSource(helperPath, string(content))
+
+		if err != nil {
+			return fmt.Errorf("%s: %v", helperPath, err)
+		}
+
+		helper.set = set
+		set.sources[helper.name] = helper
+		set.helpers = append(set.helpers, helper)
+
+		_, err = tmpl.New(helper.name).Parse(helper.program)
+
+		if err != nil {
+			return helper.templateError(tmpl, err)
+		}
+	}
+
+	return nil
+}
+
+// includeSite is an include call found in a template. list and index are where its action sits, when the include
+// has an action to itself.
+type includeSite struct {
+	command *parse.CommandNode
+	list    *parse.ListNode
+	index   int
+}
+
+// load parses every template that tmpl includes with a quoted name, and everything they include, into tmpl's set. Each
+// quoted name is swapped for the path of the file it found, so that the include function doesn't need to know which
+// template it's called from. A name that isn't a file may be one of the set's {{ define }}s. An include of something
+// that's neither, with an action to itself, is left in the output as it is, since it probably belongs to somebody
+// else, like Helm; anywhere else it's an error.
+func (set *templateSet) load(tmpl *template.Template) error {
+	var pending []pendingInclude
+
+	err := set.loadFrom(tmpl, set.root, []string{set.root.path}, &pending)
+
+	if err != nil {
+		return err
+	}
+
+	for _, other := range append(slices.Clone(set.bases), set.helpers...) {
+		err = set.loadFrom(tmpl, other, []string{other.path}, &pending)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, p := range pending {
+		if tmpl.Lookup(p.name.Text) != nil {
+			continue
+		}
+
+		templateErr := p.source.errorAt(p.name.Position(), fmt.Sprintf("can't find template %s to include", p.name.Quoted))
+
+		if p.site.list == nil {
+			return templateErr
+		}
+
+		action := p.site.list.Nodes[p.site.index]
+		p.site.list.Nodes[p.site.index] = &parse.TextNode{NodeType: parse.NodeText, Pos: action.Position(), Text: []byte(p.source.untrimmedActionText(action))}
+
+		logrus.Warn(templateErr.Template, ":", templateErr.Line, ": can't find template ", p.name.Quoted, " to include, leaving it as it is")
+		set.unresolved = append(set.unresolved, templateErr)
+	}
+
+	set.leaveUnknownTemplateCalls(tmpl)
+
+	return nil
+}
+
+// leaveUnknownTemplateCalls leaves {{ template }} actions of names that no template of the set defines in the output as
+// they are, like includes templ can't find, since they probably call somebody else's defines, like Helm's.
+func (set *templateSet) leaveUnknownTemplateCalls(tmpl *template.Template) {
+	for _, t := range tmpl.Templates() {
+		if t.Tree == nil || set.sources[t.Tree.ParseName] == nil {
+			continue
+		}
+
+		from := set.sources[t.Tree.ParseName]
+
+		findTemplateCalls(t.Tree.Root, func(list *parse.ListNode, index int) {
+			call := list.Nodes[index].(*parse.TemplateNode)
+
+			if tmpl.Lookup(call.Name) != nil {
+				return
+			}
+
+			templateErr := from.errorAt(call.Position(), fmt.Sprintf("can't find template %q", call.Name))
+			list.Nodes[index] = &parse.TextNode{NodeType: parse.NodeText, Pos: call.Position(), Text: []byte(from.untrimmedActionText(call))}
+
+			logrus.Warn(templateErr.Template, ":", templateErr.Line, ": can't find template ", strconv.Quote(call.Name), ", leaving it as it is")
+			set.unresolved = append(set.unresolved, templateErr)
+		})
+	}
+}
+
+// untrimmedActionText is the text of the action a node was parsed from, along with the space its trim markers took
+// away from the text around it, for an action that's left in the output as it is.
+func (s *source) untrimmedActionText(node parse.Node) string {
+	text := s.actionText(node)
+	start := strings.LastIndex(s.program[:node.Position()], leftDelim)
+	end := int(node.Position()) + strings.Index(s.program[node.Position():], rightDelim) + len(rightDelim)
+
+	if start < 0 || len(text) < 5 {
+		return text
+	}
+
+	if text[2] == '-' && isSpace(text[3]) {
+		before := s.program[:start]
+		text = before[len(strings.TrimRight(before, " \t\r\n")):] + text
+	}
+
+	if text[len(text)-3] == '-' && isSpace(text[len(text)-4]) {
+		after := s.program[end:]
+		text += after[:len(after)-len(strings.TrimLeft(after, " \t\r\n"))]
+	}
+
+	return text
+}
+
+// findTemplateCalls calls visit with the list and the index of every {{ template }} action under node.
+func findTemplateCalls(node parse.Node, visit func(list *parse.ListNode, index int)) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for i, child := range n.Nodes {
+			if _, ok := child.(*parse.TemplateNode); ok {
+				visit(n, i)
+				continue
+			}
+			findTemplateCalls(child, visit)
+		}
+	case *parse.IfNode:
+		findTemplateCalls(n.List, visit)
+		findTemplateCalls(n.ElseList, visit)
+	case *parse.RangeNode:
+		findTemplateCalls(n.List, visit)
+		findTemplateCalls(n.ElseList, visit)
+	case *parse.WithNode:
+		findTemplateCalls(n.List, visit)
+		findTemplateCalls(n.ElseList, visit)
+	}
+}
+
+type pendingInclude struct {
+	source *source
+	site   includeSite
+	name   *parse.StringNode
+}
+
+// loadFrom loads the includes of one template of the set. stack is the chain of includes that led to it.
+func (set *templateSet) loadFrom(tmpl *template.Template, from *source, stack []string, pending *[]pendingInclude) error {
+	var sites []includeSite
+
+	for _, t := range tmpl.Templates() {
+		if t.Tree != nil && t.Tree.ParseName == from.name {
+			findIncludes(t.Tree.Root, includeSite{}, func(site includeSite) { sites = append(sites, site) })
+		}
+	}
+
+	for _, site := range sites {
+		name, ok := site.command.Args[1].(*parse.StringNode)
+
+		if !ok {
+			continue
+		}
+
+		// A define of the template itself, or of one of the templates loaded so far.
+		if t := tmpl.Lookup(name.Text); t != nil && set.sources[name.Text] == nil {
+			continue
+		}
+
+		resolved, err := resolveInclude(name.Text, from.path)
+
+		if err != nil {
+			return from.errorAt(name.Position(), err.Error())
+		}
+
+		if resolved == "" {
+			*pending = append(*pending, pendingInclude{source: from, site: site, name: name})
+			continue
+		}
+
+		if slices.Contains(stack, resolved) {
+			cycle := strings.Join(append(stack, resolved), " includes ")
+			return from.errorAt(name.Position(), "include cycle: "+cycle)
+		}
+
+		name.Text, name.Quoted = resolved, strconv.Quote(resolved)
+
+		if set.sources[resolved] != nil {
+			continue
+		}
+
+		content, err := os.ReadFile(resolved)
+
+		if err != nil {
+			return from.errorAt(name.Position(), err.Error())
+		}
+
+		included, err := prepareSource(resolved, string(content))
+
+		if err != nil {
+			return from.errorAt(name.Position(), fmt.Sprintf("%s: %v", resolved, err))
+		}
+
+		included.set = set
+		set.sources[included.name] = included
+
+		_, err = tmpl.New(included.name).Parse(included.program)
+
+		if err != nil {
+			return included.templateError(tmpl, err)
+		}
+
+		err = set.loadFrom(tmpl, included, append(stack, resolved), pending)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// include returns the include function for a set: {{ include "name" data }} renders a template of the set with data
+// and returns the result, so that it can be piped on, like into nindent.
+func (set *templateSet) include(tmpl *template.Template) func(string, interface{}) (string, error) {
+	depth := 0
+
+	return func(name string, data interface{}) (string, error) {
+		if tmpl.Lookup(name) == nil {
+			return "", fmt.Errorf("can't find template %q to include", name)
+		}
+
+		if depth >= maxIncludeDepth {
+			return "", fmt.Errorf("includes of %s are nested more than %d deep", name, maxIncludeDepth)
+		}
+
+		depth++
+		defer func() { depth-- }()
+
+		var buffer bytes.Buffer
+		err := tmpl.ExecuteTemplate(&buffer, name, data)
+
+		if err != nil {
+			return "", set.root.templateError(tmpl, err)
+		}
+
+		return buffer.String(), nil
+	}
+}
+
+// findIncludes calls visit for each include call under node.
+func findIncludes(node parse.Node, site includeSite, visit func(includeSite)) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for i, child := range n.Nodes {
+			if action, ok := child.(*parse.ActionNode); ok {
+				findIncludes(action.Pipe, includeSite{list: n, index: i}, visit)
+				continue
+			}
+			findIncludes(child, includeSite{}, visit)
+		}
+	case *parse.IfNode:
+		findIncludes(n.Pipe, includeSite{}, visit)
+		findIncludes(n.List, includeSite{}, visit)
+		findIncludes(n.ElseList, includeSite{}, visit)
+	case *parse.RangeNode:
+		findIncludes(n.Pipe, includeSite{}, visit)
+		findIncludes(n.List, includeSite{}, visit)
+		findIncludes(n.ElseList, includeSite{}, visit)
+	case *parse.WithNode:
+		findIncludes(n.Pipe, includeSite{}, visit)
+		findIncludes(n.List, includeSite{}, visit)
+		findIncludes(n.ElseList, includeSite{}, visit)
+	case *parse.TemplateNode:
+		findIncludes(n.Pipe, includeSite{}, visit)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, command := range n.Cmds {
+			if _, _, isInclude := includeCall(command); isInclude {
+				visit(includeSite{command: command, list: site.list, index: site.index})
+			}
+			for _, arg := range command.Args {
+				findIncludes(arg, includeSite{}, visit)
+			}
+		}
+	case *parse.ChainNode:
+		findIncludes(n.Node, includeSite{}, visit)
+	}
+}
+
+// includeCall reports whether a command is {{ include "name" data }}, and returns the name and the data. data is nil
+// when it's piped in.
+func includeCall(command *parse.CommandNode) (string, parse.Node, bool) {
+	if len(command.Args) < 2 {
+		return "", nil, false
+	}
+
+	identifier, ok := command.Args[0].(*parse.IdentifierNode)
+
+	if !ok || identifier.Ident != "include" {
+		return "", nil, false
+	}
+
+	name, ok := command.Args[1].(*parse.StringNode)
+
+	if !ok {
+		return "", nil, false
+	}
+
+	if len(command.Args) > 2 {
+		return name.Text, command.Args[2], true
+	}
+
+	return name.Text, nil, true
+}
+
+// resolveInclude finds the file an include names. The name is tried, in order:
+// - as a path from the top of the including template's repository;
+// - as a path from the including template's directory;
+// - through the templates directory, the way template names on the command line are found. If that finds more than
+// one file, the ones whose path ends with the name are preferred, and it's an error if that still leaves more than one.
+// An empty path means there's no such file.
+func resolveInclude(name string, includingPath string) (string, error) {
+	var candidates []string
+
+	if includingPath != "stdin" {
+		if root := repositoryRoot(includingPath); root != "" {
+			candidates = append(candidates, filepath.Join(root, name))
+		}
+		candidates = append(candidates, filepath.Join(filepath.Dir(includingPath), name))
+	}
+
+	for _, candidate := range candidates {
+		if isFile(candidate) {
+			return filepath.Abs(candidate)
+		}
+	}
+
+	found, err := findFilesByName(configelements.NewTemplDir().TemplatesDir, []string{name})
+
+	if err != nil {
+		return "", err
+	}
+
+	var files, exact []string
+
+	for _, f := range found {
+		if !isFile(f) {
+			continue
+		}
+
+		files = append(files, f)
+
+		if strings.HasSuffix(filepath.ToSlash(f), "/"+strings.TrimPrefix(name, "/")) {
+			exact = append(exact, f)
+		}
+	}
+
+	if len(exact) > 0 {
+		files = exact
+	}
+
+	switch len(files) {
+	case 0:
+		return "", nil
+	case 1:
+		return filepath.Abs(files[0])
+	}
+
+	return "", errors.New("include " + strconv.Quote(name) + " could be any of " + strings.Join(files, ", "))
+}
+
+// repositoryRoot is the top of the repository a template file is in: the export it's in, for a template rendered at a
+// ref, the nearest directory above it with a .git, or else the directory in the templates directory it was cloned
+// into. It's empty for a template that's in none of them.
+func repositoryRoot(templatePath string) string {
+	absolute, err := filepath.Abs(templatePath)
+
+	if err != nil {
+		return ""
+	}
+
+	if root, _, _, _, found := exportRoot(absolute); found {
+		return root
+	}
+
+	templDir := configelements.NewTemplDir().TemplatesDir
+
+	for dir := filepath.Dir(absolute); ; dir = filepath.Dir(dir) {
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			return dir
+		}
+
+		if dir == templDir || dir == filepath.Dir(dir) {
+			break
+		}
+	}
+
+	relative, err := filepath.Rel(templDir, absolute)
+
+	if err != nil || strings.HasPrefix(relative, "..") {
+		return ""
+	}
+
+	repository, _, isNested := strings.Cut(relative, string(filepath.Separator))
+
+	if !isNested {
+		return ""
+	}
+
+	return filepath.Join(templDir, repository)
+}
+
+// helpersRoot is the repository whose helper files a template gets: repositoryRoot, if that's an export or in the
+// templates directory, and otherwise nothing.
+func helpersRoot(templatePath string) string {
+	root := repositoryRoot(templatePath)
+
+	if root == "" {
+		return ""
+	}
+
+	if _, _, _, _, found := exportRoot(root); found {
+		return root
+	}
+
+	relative, err := filepath.Rel(configelements.NewTemplDir().TemplatesDir, root)
+
+	if err != nil || !filepath.IsLocal(relative) {
+		return ""
+	}
+
+	return root
+}
+
+func isFile(filePath string) bool {
+	info, err := os.Stat(filePath)
+	return err == nil && info.Mode().IsRegular()
+}
+
+// include walks an included template with the dot at path.
+func (d *discovery) include(name string, path []string) {
+	t := d.tmpl.Lookup(name)
+
+	if t == nil || t.Tree == nil || slices.Contains(d.including, name) {
+		return
+	}
+
+	included := d.source.set.sources[t.Tree.ParseName]
+
+	if included == nil {
+		return
+	}
+
+	outer := d.source
+	d.source = included
+	d.including = append(d.including, name)
+
+	d.walk(t.Tree.Root, scope{dot: path, declared: map[string][]string{"$": path}})
+
+	d.including = d.including[:len(d.including)-1]
+	d.source = outer
+}
@@ -0,0 +1,260 @@
+package templates_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"templ/templates"
+	"templ/test_helpers"
+	"testing"
+)
+
+// includeRepository makes a templates directory with one cloned repository in it and returns the repository's path.
+func includeRepository(t *testing.T, files map[string]string) string {
+	templDir := t.TempDir()
+	t.Setenv("TEMPL_DIR", templDir)
+
+	repository := filepath.Join(templDir, "templates-repo")
+
+	err := os.MkdirAll(filepath.Join(repository, ".git"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = test_helpers.WriteFiles(repository, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return repository
+}
+
+func TestIncludeAcrossTheRepository(t *testing.T) {
+	repository := includeRepository(t, map[string]string{
+		"github_workflows/go/common-steps.yaml": "- run: go build {{ .package }}\n- run: echo ${{ env.X }}{{ define \"owner\" }}owner: {{ .name }}{{ end }}",
+		"github_workflows/go/build.yaml": `name: {{ .name }}
+steps:{{ include "github_workflows/go/common-steps.yaml" . | nindent 2 }}
+{{ include "owner" . }}
+db: {{ include "db.yaml" .database }}`,
+		"github_workflows/go/db.yaml": `{{ .host }}`,
+	})
+
+	build := filepath.Join(repository, "github_workflows/go/build.yaml")
+
+	content, err := os.ReadFile(build)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	variables, err := templates.RetrieveTemplateVariables(build, string(content))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	var names []string
+	for _, variable := range variables {
+		names = append(names, variable.Name)
+	}
+
+	expected := []string{"name", "package", "database", "database.host"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected variables <%v>, received <%v>", expected, names)
+	}
+
+	reference := variables[1].References[0]
+	if !strings.HasSuffix(reference.Template, "common-steps.yaml") || reference.Line != 1 || reference.Column != 20 {
+		t.Errorf("Expected package to be used in common-steps.yaml at 1:20, received <%s>", reference)
+	}
+
+	err = test_helpers.WriteFiles(repository, map[string]string{"vars.yaml": "name: shop\npackage: ./...\ndatabase:\n  host: db.internal\n"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var renderErr error
+	rendered, err := test_helpers.CaptureStdout(func() {
+		renderErr = templates.RenderFromFiles([]string{build}, map[string][]string{build: {filepath.Join(repository, "vars.yaml")}}, templates.RenderOptions{Strict: true})
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if renderErr != nil {
+		t.Fatalf("%v", renderErr)
+	}
+
+	want := `name: shop
+steps:
+  - run: go build ./...
+  - run: echo ${{ env.X }}
+owner: shop
+db: db.internal`
+	if rendered != want {
+		t.Errorf("Expected <%s>, received <%s>", want, rendered)
+	}
+}
+
+func TestIncludeFromStdinUsesTheTemplatesDirectory(t *testing.T) {
+	includeRepository(t, map[string]string{"k8s/labels.yaml": `app: {{ .app }}`})
+
+	output, err := templates.RenderFromStdin(`labels:{{ include "k8s/labels.yaml" . | nindent 2 }}`, []string{"app=shop"}, templates.RenderOptions{})
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if output != "labels:\n  app: shop" {
+		t.Errorf("Expected <labels:\n  app: shop>, received <%s>", output)
+	}
+}
+
+func TestIncludeCycle(t *testing.T) {
+	repository := includeRepository(t, map[string]string{
+		"a.yaml": `{{ include "b.yaml" . }}`,
+		"b.yaml": "b\n{{ include \"a.yaml\" . }}",
+	})
+
+	_, err := templates.RetrieveTemplateVariables(filepath.Join(repository, "a.yaml"), `{{ include "b.yaml" . }}`)
+
+	var templateErr templates.TemplateError
+	if !errors.As(err, &templateErr) {
+		t.Fatalf("Expected a TemplateError, got %v", err)
+	}
+
+	if !strings.HasSuffix(templateErr.Template, "b.yaml") || templateErr.Line != 2 || !strings.Contains(templateErr.Message, "include cycle") {
+		t.Errorf("Expected an include cycle at b.yaml:2, received <%v>", templateErr)
+	}
+}
+
+func TestIncludeErrorsPointAtTheIncludedTemplate(t *testing.T) {
+	repository := includeRepository(t, map[string]string{"part.yaml": "part\n  {{ .port | len }}"})
+
+	_, err := templates.RenderFromStdin(`{{ include "part.yaml" . }}`, []string{"port:int=80"}, templates.RenderOptions{})
+
+	var templateErr templates.TemplateError
+	if !errors.As(err, &templateErr) {
+		t.Fatalf("Expected a TemplateError, got %v", err)
+	}
+
+	if templateErr.Template != filepath.Join(repository, "part.yaml") || templateErr.Line != 2 || templateErr.Column != 14 {
+		t.Errorf("Expected an error at part.yaml:2:14, received <%v>", templateErr)
+	}
+}
+
+func TestUnknownIncludesAreLeftAlone(t *testing.T) {
+	includeRepository(t, map[string]string{})
+
+	template := `name: {{ .name }}
+fullname: {{ include "mychart.fullname" . }}`
+
+	output, err := templates.RenderFromStdin(template, []string{"name=shop"}, templates.RenderOptions{})
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	expected := `name: shop
+fullname: {{ include "mychart.fullname" . }}`
+
+	if output != expected {
+		t.Errorf("Expected <%s>, received <%s>", expected, output)
+	}
+
+	_, err = templates.RenderFromStdin(template, []string{"name=shop"}, templates.RenderOptions{Strict: true})
+
+	if !errors.Is(err, templates.TemplateError{}) || !strings.Contains(err.Error(), `can't find template "mychart.fullname"`) {
+		t.Errorf("Expected strict mode to refuse an unknown include, got %v", err)
+	}
+}
+
+func TestHelperFilesAreLoadedForEveryTemplateOfTheirRepository(t *testing.T) {
+	repository := includeRepository(t, map[string]string{
+		"_labels.tmpl":              `{{ define "labels" }}app: {{ .app }}{{ end }}{{ define "team" }}team: helpers{{ end }}`,
+		"_helpers/names.yaml":       `{{ define "fullname" }}{{ .app }}-{{ .environment }}{{ end }}`,
+		"k8s/deployment.yaml":       "name: {{ template \"fullname\" . }}\nlabels:{{ include \"labels\" . | nindent 2 }}\n{{ template \"team\" }}\n{{ define \"team\" }}team: platform{{ end }}",
+		"k8s/_helpers/ignored.yaml": "",
+	})
+
+	deployment := filepath.Join(repository, "k8s/deployment.yaml")
+
+	content, err := os.ReadFile(deployment)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	variables, err := templates.RetrieveTemplateVariables(deployment, string(content))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	var names []string
+	for _, variable := range variables {
+		names = append(names, variable.Name)
+	}
+
+	expected := []string{"app", "environment"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected variables <%v>, received <%v>", expected, names)
+	}
+
+	err = test_helpers.WriteFiles(repository, map[string]string{"vars.yaml": "app: shop\nenvironment: prod\n"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var renderErr error
+	rendered, err := test_helpers.CaptureStdout(func() {
+		renderErr = templates.RenderFromFiles([]string{deployment}, map[string][]string{deployment: {filepath.Join(repository, "vars.yaml")}}, templates.RenderOptions{Strict: true})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if renderErr != nil {
+		t.Fatalf("%v", renderErr)
+	}
+
+	// The template's own define of team wins over the helper's.
+	want := "name: shop-prod\nlabels:\n  app: shop\nteam: platform\n"
+	if rendered != want {
+		t.Errorf("Expected <%s>, received <%s>", want, rendered)
+	}
+}
+
+func TestHelperFilesOnlyComeFromTheTemplatesDirectory(t *testing.T) {
+	includeRepository(t, map[string]string{})
+
+	// A project of the user's own, with a file that only looks like a helper.
+	project := t.TempDir()
+	err := os.MkdirAll(filepath.Join(project, ".git"), 0755)
+	if err == nil {
+		err = test_helpers.WriteFiles(project, map[string]string{
+			"docs/_notes.tmpl": "{{ define \"broken\" }}{{ .notes ",
+			"skel/README.md":   "# {{ .name }}\n",
+		})
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	readme := filepath.Join(project, "skel/README.md")
+
+	var renderErr error
+	rendered, err := test_helpers.CaptureStdout(func() {
+		renderErr = templates.RenderFromFiles([]string{readme}, nil, templates.RenderOptions{Definitions: []string{"name=shop"}})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if renderErr != nil {
+		t.Fatalf("%v", renderErr)
+	}
+
+	if rendered != "# shop\n" {
+		t.Errorf("Expected <# shop\n>, received <%s>", rendered)
+	}
+}
@@ -0,0 +1,257 @@
+package templates
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"templ/output"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Layout is a project layout: every template a project is made of, where each one goes and what it's rendered with.
+// A layout file is yaml:
+//
+//	variables:
+//	  name: shop
+//	variables_files:
+//	  - common.yaml
+//	templates:
+//	  - template: github_workflows/go/build.yaml
+//	    output: .github/workflows/build.yaml
+//	    variables_files: [build.yaml]
+//	    variables:
+//	      go_version: "1.22"
+//	  - template: skeletons/go-service
+//	    output: .
+//
+// The variables and variables files at the top are shared by every template; each template's own win over them.
+type Layout struct {
+	Variables      map[string]interface{} `yaml:"variables"`
+	VariablesFiles []string               `yaml:"variables_files"`
+	Templates      []LayoutEntry          `yaml:"templates"`
+}
+
+// LayoutEntry is one template of a layout.
+type LayoutEntry struct {
+	// Template is found like an include is, from the layout file, or else it's a directory template.
+	Template       string                 `yaml:"template"`
+	VariablesFiles []string               `yaml:"variables_files"`
+	Variables      map[string]interface{} `yaml:"variables"`
+	// Output is where the rendered template goes: a file for a template file and a directory for a directory
+	// template. It's relative to the current directory.
+	Output string `yaml:"output"`
+}
+
+// ReadLayout reads a layout file, which is a path or is found in the templates directory like an include is. It
+// returns the layout and the path of its file. Variables files in a layout are relative to the layout file.
+func ReadLayout(name string) (Layout, string, error) {
+	layoutPath := name
+
+	if !isFile(layoutPath) {
+		resolved, err := resolveInclude(name, "stdin")
+
+		if err != nil {
+			return Layout{}, "", TemplateVariableErr{ErrorMessage: err.Error()}
+		}
+
+		if resolved == "" {
+			return Layout{}, "", TemplateVariableErr{ErrorMessage: fmt.Sprintf("can't find layout %s", name)}
+		}
+
+		layoutPath = resolved
+	}
+
+	content, err := os.ReadFile(layoutPath)
+
+	if err != nil {
+		_, file, line, _ := runtime.Caller(0)
+		return Layout{}, "", fmt.Errorf("%s:%d: %v", file, line, err)
+	}
+
+	var layout Layout
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+
+	err = decoder.Decode(&layout)
+
+	if err != nil {
+		return Layout{}, "", TemplateVariableErr{ErrorMessage: fmt.Sprintf("%s: %v", layoutPath, err)}
+	}
+
+	for i, entry := range layout.Templates {
+		if entry.Template == "" || entry.Output == "" {
+			return Layout{}, "", TemplateVariableErr{ErrorMessage: fmt.Sprintf("%s: templates[%d] needs both a template and an output", layoutPath, i)}
+		}
+	}
+
+	base := filepath.Dir(layoutPath)
+	layout.VariablesFiles = relativeTo(base, layout.VariablesFiles)
+
+	for i := range layout.Templates {
+		layout.Templates[i].VariablesFiles = relativeTo(base, layout.Templates[i].VariablesFiles)
+	}
+
+	return layout, layoutPath, nil
+}
+
+// RenderLayout renders every template of a layout file and returns the paths it wrote. Each template's variables are
+// layered, from the lowest precedence to the highest: the layout's variables files, the layout's variables, the
+// template's variables files, the template's variables, and then the environment and options.Definitions, as for
+// LoadVariables.
+//
+// Every template is rendered before anything is written, so a layout with problems writes nothing; the error has the
+// problems of every template. Nothing is written either if two templates go to the same file. Files that are already
+// there are dealt with by options.Writing. Each template is recorded in the ProvenanceFile of the directory it's
+// rendered into, and its hooks are run there once everything is written.
+func RenderLayout(name string, options RenderOptions) ([]string, error) {
+	layout, layoutPath, err := ReadLayout(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var files []output.File
+	var records []provenanceRecord
+	var renderErrs []error
+	rendering := make(map[string]string)
+
+	for i, entry := range layout.Templates {
+		label := fmt.Sprintf("%s templates[%d]", layoutPath, i)
+		entryFiles, record, err := renderLayoutEntry(layout, entry, layoutPath, label, options)
+
+		if isRenderProblem(err) {
+			renderErrs = append(renderErrs, err)
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, file := range entryFiles {
+			if other, found := rendering[file.Path]; found {
+				renderErrs = append(renderErrs, TemplateVariableErr{ErrorMessage: fmt.Sprintf("%s and %s both render %s", other, label, file.Path)})
+				continue
+			}
+
+			rendering[file.Path] = label
+			files = append(files, file)
+		}
+
+		records = append(records, record)
+	}
+
+	if len(renderErrs) > 0 {
+		return nil, errors.Join(renderErrs...)
+	}
+
+	written, err := writeRenderedFiles("", files, options)
+
+	if err != nil {
+		return written, err
+	}
+
+	// Templates whose files were all left as they were aren't recorded, and their hooks don't run again.
+	records = writtenRecords(records, written)
+	err = recordProvenance(records, options)
+
+	if err != nil {
+		return written, err
+	}
+
+	return written, runHooks(records, options)
+}
+
+// renderLayoutEntry renders one template of a layout, without writing anything, and describes it for its
+// ProvenanceFile.
+func renderLayoutEntry(layout Layout, entry LayoutEntry, layoutPath string, label string, options RenderOptions) ([]output.File, provenanceRecord, error) {
+	variables := NewVariables()
+
+	err := variables.mergeFiles(layout.VariablesFiles, options.VariablesFormat)
+
+	if err != nil {
+		return nil, provenanceRecord{}, err
+	}
+
+	variables.Merge(layout.Variables, layoutPath)
+
+	err = variables.mergeFiles(entry.VariablesFiles, options.VariablesFormat)
+
+	if err != nil {
+		return nil, provenanceRecord{}, err
+	}
+
+	variables.Merge(entry.Variables, label)
+
+	err = variables.mergeOverrides(options)
+
+	if err != nil {
+		return nil, provenanceRecord{}, err
+	}
+
+	// Layouts always write files with their templates' modes.
+	options.Writing.KeepMode = true
+
+	// A directory template is looked for first, since its name is in the path of every file in it.
+	if templateDir, found := findLayoutDirectory(entry.Template, layoutPath); found {
+		files, err := renderDirectory(templateDir, variables.Values, options)
+
+		for i := range files {
+			files[i].Path = filepath.Join(entry.Output, files[i].Path)
+		}
+
+		return files, newProvenanceRecord(templateDir, entry.Output, true, variables, options), err
+	}
+
+	templatePath, err := resolveInclude(entry.Template, layoutPath)
+
+	if err != nil {
+		return nil, provenanceRecord{}, TemplateVariableErr{ErrorMessage: fmt.Sprintf("%s: %v", label, err)}
+	}
+
+	if templatePath == "" {
+		return nil, provenanceRecord{}, TemplateVariableErr{ErrorMessage: fmt.Sprintf("%s: can't find template %s", label, entry.Template)}
+	}
+
+	info, err := os.Lstat(templatePath)
+
+	if err != nil {
+		return nil, provenanceRecord{}, err
+	}
+
+	file, err := renderFile(templatePath, info, variables.Values, options)
+	file.Path = entry.Output
+
+	return []output.File{file}, newProvenanceRecord(templatePath, entry.Output, false, variables, options), err
+}
+
+// findLayoutDirectory finds a directory template of a layout, next to the layout file or in the templates directory.
+func findLayoutDirectory(name string, layoutPath string) (string, bool) {
+	besideLayout := filepath.Join(filepath.Dir(layoutPath), name)
+
+	if info, err := os.Stat(besideLayout); err == nil && info.IsDir() {
+		return besideLayout, true
+	}
+
+	templateDir, err := FindTemplateDirectory(name)
+
+	return templateDir, err == nil
+}
+
+// relativeTo makes relative paths relative to base.
+func relativeTo(base string, paths []string) []string {
+	joined := make([]string, 0, len(paths))
+
+	for _, p := range paths {
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(base, p)
+		}
+		joined = append(joined, p)
+	}
+
+	return joined
+}
@@ -0,0 +1,108 @@
+package templates_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"templ/templates"
+	"templ/test_helpers"
+	"testing"
+)
+
+func TestRenderLayout(t *testing.T) {
+	includeRepository(t, map[string]string{
+		"github_workflows/go/build.yaml":               "name: {{ .name }}\ngo: {{ .go_version }}\nenv: {{ .environment }}",
+		"skeletons/go-service/cmd/{{ .name }}/main.go": "package main // {{ .name }} {{ .environment }}",
+	})
+
+	project := t.TempDir()
+
+	err := test_helpers.WriteFiles(project, map[string]string{
+		"layout/common.yaml": "name: shop\ngo_version: '1.21'\nenvironment: dev\n",
+		"layout/build.yaml":  "environment: staging\n",
+		"layout/project.yaml": `variables:
+  go_version: "1.22"
+variables_files:
+  - common.yaml
+templates:
+  - template: github_workflows/go/build.yaml
+    output: ` + project + `/.github/workflows/build.yaml
+    variables_files: [build.yaml]
+    variables:
+      environment: prod
+  - template: skeletons/go-service
+    output: ` + project + `/service
+`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	written, err := templates.RenderLayout(filepath.Join(project, "layout/project.yaml"), templates.RenderOptions{Definitions: []string{"name=cart"}})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	expected := []string{filepath.Join(project, ".github/workflows/build.yaml"), filepath.Join(project, "service/cmd/cart/main.go")}
+	if !reflect.DeepEqual(written, expected) {
+		t.Errorf("Expected to write <%v>, wrote <%v>", expected, written)
+	}
+
+	contents := map[string]string{
+		".github/workflows/build.yaml": "name: cart\ngo: 1.22\nenv: prod",
+		"service/cmd/cart/main.go":     "package main // cart dev",
+	}
+
+	for path, want := range contents {
+		content, err := os.ReadFile(filepath.Join(project, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(content) != want {
+			t.Errorf("%s: expected <%s>, received <%s>", path, want, content)
+		}
+	}
+}
+
+func TestRenderLayoutProblems(t *testing.T) {
+	includeRepository(t, map[string]string{"a.txt": "{{ .a }}", "b.txt": "{{ .b | len }}"})
+
+	project := t.TempDir()
+
+	tests := map[string]struct {
+		layout  string
+		message string
+	}{
+		"typo":             {"templates:\n  - template: a.txt\n    ouput: a\n", "field ouput not found"},
+		"no output":        {"templates:\n  - template: a.txt\n", "needs both a template and an output"},
+		"missing template": {"templates:\n  - template: nowhere.txt\n    output: x\n", "nowhere.txt"},
+		"same output":      {"templates:\n  - template: a.txt\n    output: " + project + "/x\n  - template: a.txt\n    output: " + project + "/x\n", "both render"},
+		"broken template":  {"variables: {b: 1}\ntemplates:\n  - template: b.txt\n    output: " + project + "/b\n", "len"},
+	}
+
+	for name, test := range tests {
+		err := test_helpers.WriteFiles(project, map[string]string{"project.yaml": test.layout})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = templates.RenderLayout(filepath.Join(project, "project.yaml"), templates.RenderOptions{})
+
+		if !errors.Is(err, templates.TemplateVariableErr{}) && !errors.Is(err, templates.TemplateError{}) {
+			t.Errorf("%s: expected a layout or template error, got %v", name, err)
+			continue
+		}
+
+		if !strings.Contains(err.Error(), test.message) {
+			t.Errorf("%s: expected an error about <%s>, got %v", name, test.message, err)
+		}
+	}
+
+	entries, err := os.ReadDir(project)
+	if err != nil || len(entries) != 1 {
+		t.Errorf("Expected nothing but the layout to be written, got %v, %v", entries, err)
+	}
+}
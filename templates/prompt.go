@@ -0,0 +1,251 @@
+package templates
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// Prompter asks for the values of template variables.
+type Prompter struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+// NewPrompter returns a Prompter that reads answers from in and writes its questions to out.
+func NewPrompter(in io.Reader, out io.Writer) *Prompter {
+	return &Prompter{in: bufio.NewReader(in), out: out}
+}
+
+// Ask asks for every variable that isn't in answers yet, in order, and returns answers with the new values added. The
+// question shows the variable's description, choices and default; an empty answer takes the default. Answers are
+// checked against the variable's type and choices, and asked again until they pass. A variable whose front-matter has
+// a when condition is only asked about if the condition holds for the answers given before it. Fields of list items,
+// like services[].name, aren't asked about, and neither is a variable the template only uses as a map, like database
+// when it uses database.host, unless the front-matter declares it.
+func (p *Prompter) Ask(variables []Variable, answers map[string]interface{}) (map[string]interface{}, error) {
+	answers = copyVariables(answers)
+
+	for _, variable := range variables {
+		if strings.Contains(variable.Name, "[]") || (variable.line == 0 && isParent(variables, variable.Name)) {
+			continue
+		}
+
+		path := strings.Split(variable.Name, ".")
+
+		if _, found := lookupVariable(answers, path); found {
+			continue
+		}
+
+		applies, err := variable.applies(answers)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !applies {
+			continue
+		}
+
+		value, err := p.askFor(variable)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if value != nil {
+			setVariable(answers, path, value)
+		}
+	}
+
+	return answers, nil
+}
+
+// isParent reports whether name is a map holding another of the variables.
+func isParent(variables []Variable, name string) bool {
+	for _, variable := range variables {
+		if strings.HasPrefix(variable.Name, name+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// askFor asks the question for one variable until it gets an acceptable answer. A nil value means the variable was
+// left unset.
+func (p *Prompter) askFor(variable Variable) (interface{}, error) {
+	for {
+		fmt.Fprint(p.out, question(variable))
+
+		answer, err := p.in.ReadString('\n')
+
+		if err != nil && !(errors.Is(err, io.EOF) && answer != "") {
+			_, file, line, _ := runtime.Caller(0)
+			return nil, fmt.Errorf("%s:%d: no answer for %s: %v", file, line, variable.Name, err)
+		}
+
+		answer = strings.TrimRight(answer, "\r\n")
+		var value interface{} = answer
+
+		if answer == "" {
+			switch {
+			case variable.Default != nil:
+				value = variable.Default
+			case variable.Required:
+				fmt.Fprintf(p.out, "%s is required.\n", variable.Name)
+				continue
+			case variable.Type != "" && variable.Type != "string":
+				return nil, nil
+			}
+		}
+
+		value, err = convertVariable(variable, value)
+
+		if err == nil && len(variable.Choices) > 0 && !isChoice(variable.Choices, value) {
+			err = fmt.Errorf("%v is not one of %v", value, variable.Choices)
+		}
+
+		if err != nil {
+			fmt.Fprintf(p.out, "%v\n", err)
+			continue
+		}
+
+		return value, nil
+	}
+}
+
+// Confirm asks a yes or no question, which is no unless the answer starts with y.
+func (p *Prompter) Confirm(question string) (bool, error) {
+	fmt.Fprintf(p.out, "%s [y/N]: ", question)
+
+	answer, err := p.in.ReadString('\n')
+
+	if err != nil && !(errors.Is(err, io.EOF) && answer != "") {
+		_, file, line, _ := runtime.Caller(0)
+		return false, fmt.Errorf("%s:%d: no answer to %s: %v", file, line, question, err)
+	}
+
+	return strings.HasPrefix(strings.ToLower(strings.TrimSpace(answer)), "y"), nil
+}
+
+// question is the prompt for a variable, like:
+// ENVIRONMENT - where to deploy (dev|prod) [dev]:
+func question(variable Variable) string {
+	var q strings.Builder
+
+	q.WriteString(variable.Name)
+
+	if variable.Description != "" {
+		q.WriteString(" - " + variable.Description)
+	}
+
+	if len(variable.Choices) > 0 {
+		choices := make([]string, 0, len(variable.Choices))
+		for _, choice := range variable.Choices {
+			choices = append(choices, fmt.Sprint(choice))
+		}
+		q.WriteString(" (" + strings.Join(choices, "|") + ")")
+	} else if variable.Type != "" && variable.Type != "string" {
+		q.WriteString(" (" + variable.Type + ")")
+	}
+
+	if variable.Default != nil {
+		fmt.Fprintf(&q, " [%v]", variable.Default)
+	}
+
+	q.WriteString(": ")
+
+	return q.String()
+}
+
+// RenderInteractively asks for every variable of each template that its variables don't supply, renders the
+// templates with the answers and prints them. A variable shared by several templates is only asked about once. If
+// answersFile isn't empty, every answer is also saved there as yaml, ready to be handed back as templatename=answersFile.
+func RenderInteractively(templateFiles []string, templateVariables map[string][]string, answersFile string, options RenderOptions, prompter *Prompter) error {
+	err := validateTemplatesExist(templateFiles)
+
+	if err != nil {
+		logrus.Error(err)
+		return err
+	}
+
+	answers := make(map[string]interface{})
+	outputs := make([]string, 0, len(templateFiles))
+	values := make([]Variables, 0, len(templateFiles))
+
+	for _, templatePath := range templateFiles {
+		templateContents, err := os.ReadFile(templatePath)
+
+		if err != nil {
+			_, file, line, _ := runtime.Caller(0)
+			return fmt.Errorf("%s:%d: %v", file, line, err)
+		}
+
+		layers, err := LoadVariables(templateVariables[templatePath], options)
+
+		if err != nil {
+			_, file, line, _ := runtime.Caller(0)
+			return fmt.Errorf("%s:%d: %w", file, line, err)
+		}
+
+		variables := layers.Values
+
+		// Earlier answers fill in for this template too, unless its own variables say otherwise.
+		for key, value := range answers {
+			if _, found := variables[key]; !found {
+				variables[key] = value
+			}
+		}
+
+		questions, err := RetrieveTemplateVariables(templatePath, string(templateContents))
+
+		if err != nil {
+			_, file, line, _ := runtime.Caller(0)
+			return fmt.Errorf("%s:%d: %w", file, line, err)
+		}
+
+		variables, err = prompter.Ask(questions, variables)
+
+		if err != nil {
+			return err
+		}
+
+		for key, value := range variables {
+			answers[key] = value
+		}
+
+		output, err := renderFromString(templatePath, string(templateContents), variables, options)
+
+		if err != nil {
+			return err
+		}
+
+		outputs = append(outputs, finishOutput(string(templateContents), output, options))
+		values = append(values, Variables{Values: variables, Sources: layers.Sources})
+	}
+
+	if answersFile != "" {
+		content, err := yaml.Marshal(answers)
+
+		if err != nil {
+			_, file, line, _ := runtime.Caller(0)
+			return fmt.Errorf("%s:%d: %v", file, line, err)
+		}
+
+		err = os.WriteFile(answersFile, append([]byte("---\n"), content...), 0644)
+
+		if err != nil {
+			_, file, line, _ := runtime.Caller(0)
+			return fmt.Errorf("%s:%d: %v", file, line, err)
+		}
+	}
+
+	return emit(templateFiles, outputs, values, options)
+}
@@ -0,0 +1,137 @@
+package templates_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"templ/templates"
+	"templ/test_helpers"
+	"testing"
+)
+
+const promptTemplate = `---
+templ:
+  variables:
+    NAME:
+      description: the name of the service
+      required: true
+    ENVIRONMENT:
+      default: dev
+      choices: [dev, prod]
+    USE_DATABASE:
+      type: bool
+      default: false
+    DATABASE_HOST:
+      description: where the database lives
+      when: .USE_DATABASE
+      required: true
+    REPLICAS:
+      type: int
+---
+{{ .NAME }} {{ .ENVIRONMENT }} {{ .USE_DATABASE }} {{ .DATABASE_HOST }} {{ .REPLICAS }} {{ .OWNER }}`
+
+func TestPrompterAsksEveryMissingVariable(t *testing.T) {
+	variables, err := templates.RetrieveVariables(promptTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An empty required answer and an invalid choice are both asked again.
+	in := strings.NewReader("\nshop\nstaging\nprod\ntrue\ndb.internal\nthree\n3\n")
+	var out strings.Builder
+
+	answers, err := templates.NewPrompter(in, &out).Ask(variables, map[string]interface{}{"OWNER": "me"})
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	expected := map[string]interface{}{
+		"NAME":          "shop",
+		"ENVIRONMENT":   "prod",
+		"USE_DATABASE":  true,
+		"DATABASE_HOST": "db.internal",
+		"REPLICAS":      3,
+		"OWNER":         "me",
+	}
+
+	if !reflect.DeepEqual(answers, expected) {
+		t.Errorf("Expected <%v>, received <%v>", expected, answers)
+	}
+
+	if !strings.Contains(out.String(), "ENVIRONMENT (dev|prod) [dev]: ") {
+		t.Errorf("Question for ENVIRONMENT should show its choices and default, asked <%s>", out.String())
+	}
+}
+
+func TestPrompterSkipsConditionalQuestions(t *testing.T) {
+	variables, err := templates.RetrieveVariables(promptTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in := strings.NewReader("shop\n\n\n\n\n")
+	var out strings.Builder
+
+	answers, err := templates.NewPrompter(in, &out).Ask(variables, map[string]interface{}{})
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	expected := map[string]interface{}{
+		"NAME":         "shop",
+		"ENVIRONMENT":  "dev",
+		"USE_DATABASE": false,
+		"OWNER":        "",
+	}
+
+	if !reflect.DeepEqual(answers, expected) {
+		t.Errorf("Expected <%v>, received <%v>", expected, answers)
+	}
+
+	if strings.Contains(out.String(), "DATABASE_HOST") {
+		t.Errorf("DATABASE_HOST should not be asked for without a database, asked <%s>", out.String())
+	}
+}
+
+func TestRenderInteractivelySavesAnswers(t *testing.T) {
+	dir := t.TempDir()
+	templateFile := filepath.Join(dir, "service.yaml")
+	answersFile := filepath.Join(dir, "answers.yaml")
+
+	err := test_helpers.WriteFiles(dir, map[string]string{"service.yaml": `{{ .NAME }} in {{ .ENVIRONMENT }}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prompter := templates.NewPrompter(strings.NewReader("shop\nprod\n"), &strings.Builder{})
+
+	var renderErr error
+	output, err := test_helpers.CaptureStdout(func() {
+		renderErr = templates.RenderInteractively([]string{templateFile}, map[string][]string{}, answersFile, templates.RenderOptions{}, prompter)
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if renderErr != nil {
+		t.Fatalf("%v", renderErr)
+	}
+
+	if output != "shop in prod" {
+		t.Errorf("Expected <shop in prod>, received <%s>", output)
+	}
+
+	answers, err := os.ReadFile(answersFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "---\nENVIRONMENT: prod\nNAME: shop\n"
+	if string(answers) != expected {
+		t.Errorf("Expected answers <%s>, received <%s>", expected, answers)
+	}
+}
@@ -0,0 +1,422 @@
+package templates
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"templ/configelements"
+	"templ/output"
+	"templ/repository"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// ProvenanceFile is written to every directory templ renders into. It says which template made each file or
+// directory there, from which repository and commit, and what it was rendered with, so that templ regen can render
+// them all again.
+const ProvenanceFile = ".templ-answers.yaml"
+
+// Provenance is what's in a ProvenanceFile.
+type Provenance struct {
+	Renders []Rendering `yaml:"renders"`
+}
+
+// Rendering is a template that was rendered into the directory of a ProvenanceFile.
+type Rendering struct {
+	// Template is the template's path in its repository, or an absolute path for a template that isn't in the
+	// templates directory, which isn't rendered again.
+	Template string `yaml:"template"`
+	// Repository is the template's repository, relative to the templates directory.
+	Repository string `yaml:"repository,omitempty"`
+	// Upstream is where the repository was cloned from. It's empty for a repository that wasn't cloned.
+	Upstream string `yaml:"upstream,omitempty"`
+	// Commit is the commit the repository had checked out, or the one Ref named.
+	Commit string `yaml:"commit,omitempty"`
+	// Ref is the tag, branch or commit the template was rendered at, for a template that wasn't rendered as it's
+	// checked out.
+	Ref string `yaml:"ref,omitempty"`
+	// Output is the file or directory the template was rendered to, relative to the ProvenanceFile.
+	Output        string `yaml:"output"`
+	LineEndings   string `yaml:"line_endings,omitempty"`
+	ByteOrderMark string `yaml:"bom,omitempty"`
+	KeepMode      bool   `yaml:"keep_mode,omitempty"`
+	// Variables are every variable the template was given, after variables files, the environment and the command
+	// line were merged. The defaults in its front-matter aren't, so that a new default is picked up.
+	Variables map[string]interface{} `yaml:"variables"`
+}
+
+// provenanceRecord is a Rendering, and the directory of the ProvenanceFile it goes in, along with the template it was
+// rendered from.
+type provenanceRecord struct {
+	dir          string
+	rendering    Rendering
+	templatePath string
+	isDirectory  bool
+}
+
+// newProvenanceRecord describes a template that's rendered to target, a file, or a directory for a directory template.
+func newProvenanceRecord(templatePath string, target string, isDirectory bool, variables Variables, options RenderOptions) provenanceRecord {
+	rendering := describeTemplate(templatePath)
+	rendering.Variables = variables.recorded()
+	rendering.KeepMode = options.Writing.KeepMode && !isDirectory
+
+	if options.LineEndings != "keep" {
+		rendering.LineEndings = options.LineEndings
+	}
+
+	if options.ByteOrderMark != "keep" {
+		rendering.ByteOrderMark = options.ByteOrderMark
+	}
+
+	if isDirectory {
+		rendering.Output = "."
+		return provenanceRecord{dir: target, rendering: rendering, templatePath: templatePath, isDirectory: true}
+	}
+
+	rendering.Output = filepath.Base(target)
+
+	return provenanceRecord{dir: filepath.Dir(target), rendering: rendering, templatePath: templatePath}
+}
+
+// describeTemplate says where a template is: its repository in the templates directory, that repository's upstream and
+// the commit it has checked out. A template that isn't in a git repository has no upstream or commit, and one that
+// isn't in the templates directory at all is just its absolute path.
+func describeTemplate(templatePath string) Rendering {
+	absolute, err := filepath.Abs(templatePath)
+
+	if err != nil {
+		return Rendering{Template: templatePath}
+	}
+
+	// A template rendered at a ref is in an export of its repository, which says which one and at which commit.
+	if repositoryDir, commit, ref, found := exportOf(absolute); found {
+		rendering := describeTemplate(filepath.Join(repositoryDir, exportedPath(absolute)))
+		rendering.Commit, rendering.Ref = commit, ref
+		return rendering
+	}
+
+	root := repositoryRoot(absolute)
+	templDir, _ := filepath.Abs(configelements.NewTemplDir().TemplatesDir)
+	repositoryDir, err := filepath.Rel(templDir, root)
+
+	if root == "" || err != nil || !filepath.IsLocal(repositoryDir) {
+		return Rendering{Template: absolute}
+	}
+
+	inRepository, _ := filepath.Rel(root, absolute)
+	rendering := Rendering{Template: filepath.ToSlash(inRepository), Repository: filepath.ToSlash(repositoryDir)}
+
+	if _, err := os.Stat(filepath.Join(root, ".git")); err != nil {
+		return rendering
+	}
+
+	repo, err := repository.OpenRepository(root)
+
+	if err == nil {
+		rendering.Upstream = repo.Upstream
+		rendering.Commit, err = repo.Commit()
+	}
+
+	// A directory that only looks like a repository has no commits to tell of.
+	if err != nil && !errors.Is(err, git.ErrRepositoryNotExists) {
+		logrus.Warn("Can't tell which commit ", templatePath, " is from: ", err)
+	}
+
+	return rendering
+}
+
+// checkPaths makes sure a Rendering read from the ProvenanceFile in dir, which came with the project and could say
+// anything, only renders a template of the templates directory, into dir. A template that isn't in the templates
+// directory is recorded by its absolute path, which isn't rendered again, since it could be any file.
+func (r Rendering) checkPaths(dir string) error {
+	record := filepath.Join(dir, ProvenanceFile)
+
+	if !filepath.IsLocal(filepath.FromSlash(r.Output)) {
+		return TemplateVariableErr{ErrorMessage: fmt.Sprintf("%s: output %s isn't inside %s, so templ won't write to it", record, r.Output, dir)}
+	}
+
+	if r.Repository == "" {
+		return TemplateVariableErr{ErrorMessage: fmt.Sprintf("%s: %s isn't in the templates directory, so templ won't render it again; render it yourself with templ", record, r.Template)}
+	}
+
+	if !filepath.IsLocal(filepath.FromSlash(r.Repository)) {
+		return TemplateVariableErr{ErrorMessage: fmt.Sprintf("%s: repository %s isn't inside the templates directory", record, r.Repository)}
+	}
+
+	if !filepath.IsLocal(filepath.FromSlash(r.Template)) {
+		return TemplateVariableErr{ErrorMessage: fmt.Sprintf("%s: template %s isn't inside repository %s", record, r.Template, r.Repository)}
+	}
+
+	return nil
+}
+
+// templatePath is where the template of a Rendering is now. A template that was rendered at a ref is exported as it
+// is at that ref now, which for a branch may be a newer commit.
+func (r Rendering) templatePath() (string, error) {
+	repositoryDir := filepath.Join(configelements.NewTemplDir().TemplatesDir, filepath.FromSlash(r.Repository))
+
+	if r.Ref == "" {
+		return filepath.Join(repositoryDir, filepath.FromSlash(r.Template)), nil
+	}
+
+	dir, err := exportRef(repositoryDir, r.Ref)
+
+	if errors.Is(err, repository.ErrUnknownRevision{}) || errors.Is(err, git.ErrRepositoryNotExists) {
+		return "", TemplateVariableErr{ErrorMessage: fmt.Sprintf("%s was rendered at %s of %s, which isn't there any more: %v", r.Template, r.Ref, repositoryDir, err)}
+	}
+
+	return filepath.Join(dir, filepath.FromSlash(r.Template)), err
+}
+
+// ReadProvenance reads the ProvenanceFile in dir.
+func ReadProvenance(dir string) (Provenance, error) {
+	path := filepath.Join(dir, ProvenanceFile)
+	content, err := os.ReadFile(path)
+
+	if errors.Is(err, fs.ErrNotExist) {
+		return Provenance{}, TemplateVariableErr{ErrorMessage: fmt.Sprintf("there's no %s in %s; templ writes one where it writes rendered files", ProvenanceFile, dir)}
+	}
+
+	if err != nil {
+		_, file, line, _ := runtime.Caller(0)
+		return Provenance{}, fmt.Errorf("%s:%d: %v", file, line, err)
+	}
+
+	var provenance Provenance
+
+	err = yaml.Unmarshal(content, &provenance)
+
+	if err != nil {
+		return Provenance{}, TemplateVariableErr{ErrorMessage: fmt.Sprintf("%s: %v", path, err)}
+	}
+
+	return provenance, nil
+}
+
+// recordProvenance adds renderings to the ProvenanceFile of each of their directories. A rendering replaces the one
+// that's there for the same output. Callers only pass the renderings that were written; see writtenRecords. Nothing
+// is recorded when nothing was written, because options.Writing only compares files, or when options.NoProvenance is
+// set.
+func recordProvenance(records []provenanceRecord, options RenderOptions) error {
+	if options.NoProvenance || options.Writing.DiffTo != nil || options.Writing.Check {
+		return nil
+	}
+
+	var dirs []string
+	renderings := make(map[string][]Rendering)
+
+	for _, record := range records {
+		if _, found := renderings[record.dir]; !found {
+			dirs = append(dirs, record.dir)
+		}
+		renderings[record.dir] = append(renderings[record.dir], record.rendering)
+	}
+
+	for _, dir := range dirs {
+		var provenance Provenance
+
+		if _, err := os.Stat(filepath.Join(dir, ProvenanceFile)); err == nil {
+			provenance, err = ReadProvenance(dir)
+
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, rendering := range renderings[dir] {
+			provenance.add(rendering)
+		}
+
+		var buffer bytes.Buffer
+		buffer.WriteString("# Written by templ. templ regen renders this directory again from it.\n---\n")
+		encoder := yaml.NewEncoder(&buffer)
+		encoder.SetIndent(2)
+
+		err := encoder.Encode(provenance)
+
+		if err != nil {
+			_, file, line, _ := runtime.Caller(0)
+			return fmt.Errorf("%s:%d: %v", file, line, err)
+		}
+
+		_, err = output.Write([]output.File{{Path: filepath.Join(dir, ProvenanceFile), Content: buffer.Bytes()}}, output.Options{Policy: output.Overwrite})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writtenRecords returns the records of the renderings something was written for: the file a template was rendered
+// to, or a file in the directory a directory template was. A rendering whose files were all left as they were isn't
+// what's there, so it isn't recorded.
+func writtenRecords(records []provenanceRecord, written []string) []provenanceRecord {
+	var kept []provenanceRecord
+
+	for _, record := range records {
+		target := filepath.Join(record.dir, filepath.FromSlash(record.rendering.Output))
+
+		for _, path := range written {
+			relative, err := filepath.Rel(target, path)
+
+			if err == nil && (relative == "." || (record.isDirectory && filepath.IsLocal(relative))) {
+				kept = append(kept, record)
+				break
+			}
+		}
+	}
+
+	return kept
+}
+
+// add records a rendering, in place of any that's there for the same output.
+func (p *Provenance) add(rendering Rendering) {
+	for i, existing := range p.Renders {
+		if filepath.Clean(existing.Output) == filepath.Clean(rendering.Output) {
+			p.Renders[i] = rendering
+			return
+		}
+	}
+
+	p.Renders = append(p.Renders, rendering)
+}
+
+// Regenerate renders every template in the ProvenanceFile of dir again, from the template as it is in the templates
+// directory now, with the variables it was rendered with, and returns the paths it wrote. options.Definitions and the
+// environment override the recorded variables, and the ProvenanceFile is brought up to date: the new variables, and the
+// commit each template is at now. Files are written by options.Writing, as they were by the render that made them.
+//
+// Every template is rendered before anything is written, so nothing is written if any of them has problems.
+func Regenerate(dir string, options RenderOptions) ([]string, error) {
+	provenance, err := ReadProvenance(dir)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var files []output.File
+	var records []provenanceRecord
+	var renderErrs []error
+
+	for _, rendering := range provenance.Renders {
+		entryFiles, record, err := regenerate(dir, rendering, options)
+
+		if isRenderProblem(err) {
+			renderErrs = append(renderErrs, err)
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if rendering.Commit != "" && record.rendering.Commit != rendering.Commit {
+			logrus.Info(rendering.Output, " was rendered from commit ", rendering.Commit, " of ", rendering.Template, ", which is at ", record.rendering.Commit, " now")
+		}
+
+		files = append(files, entryFiles...)
+		records = append(records, record)
+	}
+
+	if len(renderErrs) > 0 {
+		return nil, errors.Join(renderErrs...)
+	}
+
+	writing := options.Writing
+	writing.KeepMode = true
+
+	written, err := output.Write(files, writing)
+
+	if err != nil {
+		return written, err
+	}
+
+	return written, recordProvenance(writtenRecords(records, written), options)
+}
+
+// regenerate renders one Rendering of the ProvenanceFile in dir again, without writing anything.
+func regenerate(dir string, rendering Rendering, options RenderOptions) ([]output.File, provenanceRecord, error) {
+	err := rendering.checkPaths(dir)
+
+	if err != nil {
+		return nil, provenanceRecord{}, err
+	}
+
+	target := filepath.Join(dir, filepath.FromSlash(rendering.Output))
+	templatePath, err := rendering.templatePath()
+
+	if err != nil {
+		return nil, provenanceRecord{}, err
+	}
+
+	info, err := os.Lstat(templatePath)
+
+	if err != nil {
+		return nil, provenanceRecord{}, TemplateVariableErr{ErrorMessage: fmt.Sprintf("can't find %s, which %s was rendered from", templatePath, target)}
+	}
+
+	variables := NewVariables()
+	variables.Merge(rendering.Variables, filepath.Join(dir, ProvenanceFile))
+
+	err = variables.mergeOverrides(options)
+
+	if err != nil {
+		return nil, provenanceRecord{}, err
+	}
+
+	record := newProvenanceRecord(templatePath, target, info.IsDir(), variables, rendering.options(options))
+
+	files, err := renderRecorded(templatePath, info, target, rendering, variables.Values, options)
+
+	return files, record, err
+}
+
+// renderRecorded renders the template at templatePath to target, without writing anything, the way a Rendering says
+// it was rendered.
+func renderRecorded(templatePath string, info fs.FileInfo, target string, rendering Rendering, variables map[string]interface{}, options RenderOptions) ([]output.File, error) {
+	options = rendering.options(options)
+
+	if info.IsDir() {
+		files, err := renderDirectory(templatePath, variables, options)
+
+		for i := range files {
+			files[i].Path = filepath.Join(target, files[i].Path)
+		}
+
+		return files, err
+	}
+
+	file, err := renderFile(templatePath, info, variables, options)
+	file.Path = target
+
+	// Without keep mode, the file keeps whatever mode it has.
+	if !rendering.KeepMode {
+		file.Mode = 0
+	}
+
+	return []output.File{file}, err
+}
+
+// options are the options a Rendering was rendered with.
+func (r Rendering) options(options RenderOptions) RenderOptions {
+	options.LineEndings, options.ByteOrderMark = orKeep(r.LineEndings), orKeep(r.ByteOrderMark)
+	options.Writing.KeepMode = r.KeepMode
+
+	return options
+}
+
+// orKeep is a recorded line endings or byte order mark option, which is keep when it wasn't recorded.
+func orKeep(option string) string {
+	if option == "" {
+		return "keep"
+	}
+	return option
+}
@@ -0,0 +1,388 @@
+package templates_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"templ/output"
+	"templ/templates"
+	"templ/test_helpers"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// gitRepository is includeRepository as a real git repository, with every file committed and an origin remote. It
+// returns the repository and the commit's hash.
+func gitRepository(t *testing.T, files map[string]string) (string, string) {
+	repository := includeRepository(t, files)
+
+	err := os.RemoveAll(filepath.Join(repository, ".git"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gitRepo, err := git.PlainInit(repository, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = gitRepo.CreateRemote(&config.RemoteConfig{Name: "origin", URLs: []string{"https://github.com/PlayTechnique/templ_templates.git"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return repository, commitAll(t, repository)
+}
+
+// commitAll commits every file in a repository and returns the commit's hash.
+func commitAll(t *testing.T, repository string) string {
+	gitRepo, err := git.PlainOpen(repository)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	worktree, err := gitRepo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = worktree.AddGlob(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash, err := worktree.Commit("templates", &git.CommitOptions{Author: &object.Signature{Name: "templ", Email: "templ@example.com", When: time.Now()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return hash.String()
+}
+
+// renderQuietly is templates.RenderFromFiles, without the paths it writes being printed.
+func renderQuietly(templateFiles []string, options templates.RenderOptions) error {
+	var renderErr error
+
+	_, err := test_helpers.CaptureStdout(func() {
+		renderErr = templates.RenderFromFiles(templateFiles, nil, options)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return renderErr
+}
+
+func TestRenderDirectoryRecordsProvenance(t *testing.T) {
+	repository, commit := gitRepository(t, map[string]string{
+		"skeletons/go-service/cmd/{{ .name }}/main.go": "package main // {{ .name }}\n",
+	})
+
+	outputDir := t.TempDir()
+	_, err := templates.RenderDirectory(filepath.Join(repository, "skeletons/go-service"), nil, outputDir, templates.RenderOptions{Definitions: []string{"name=shop"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	provenance, err := templates.ReadProvenance(outputDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []templates.Rendering{{
+		Template:   "skeletons/go-service",
+		Repository: "templates-repo",
+		Upstream:   "https://github.com/PlayTechnique/templ_templates.git",
+		Commit:     commit,
+		Output:     ".",
+		Variables:  map[string]interface{}{"name": "shop"},
+	}}
+
+	if !reflect.DeepEqual(provenance.Renders, expected) {
+		t.Errorf("Expected <%+v>, received <%+v>", expected, provenance.Renders)
+	}
+}
+
+func TestRenderFromFilesRecordsProvenance(t *testing.T) {
+	repository, _ := gitRepository(t, map[string]string{
+		"config.yaml": "name: {{ .name }}\n",
+		"notes.txt":   "{{ .name }}'s notes\n",
+	})
+
+	outputDir := t.TempDir()
+	options := templates.RenderOptions{Definitions: []string{"name=shop"}, Output: outputDir, LineEndings: "crlf"}
+	templateFiles := []string{filepath.Join(repository, "config.yaml"), filepath.Join(repository, "notes.txt")}
+
+	err := renderQuietly(templateFiles, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A second render of one of them replaces its record.
+	options.Definitions = []string{"name=store"}
+	options.Writing.Policy = output.Overwrite
+
+	err = renderQuietly(templateFiles[:1], options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	provenance, err := templates.ReadProvenance(outputDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var outputs []string
+	for _, rendering := range provenance.Renders {
+		outputs = append(outputs, rendering.Output+"="+rendering.Variables["name"].(string)+","+rendering.LineEndings)
+	}
+
+	expected := []string{"config.yaml=store,crlf", "notes.txt=shop,crlf"}
+	if !reflect.DeepEqual(outputs, expected) {
+		t.Errorf("Expected <%v>, received <%v>", expected, outputs)
+	}
+}
+
+func TestOnlyWrittenRenderingsAreRecorded(t *testing.T) {
+	repository, _ := gitRepository(t, map[string]string{
+		"notes.txt":          "{{ .name }}'s notes\n",
+		"skeleton/README.md": "# {{ .name }}\n",
+	})
+
+	outputDir := t.TempDir()
+	options := templates.RenderOptions{Definitions: []string{"name=shop"}, Output: filepath.Join(outputDir, "notes.txt")}
+
+	err := renderQuietly([]string{filepath.Join(repository, "notes.txt")}, options)
+	if err == nil {
+		_, err = templates.RenderDirectory(filepath.Join(repository, "skeleton"), nil, outputDir, options)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rendering again with other variables, but leaving the files as they are, leaves the record as it is too.
+	options.Definitions = []string{"name=store"}
+	options.Writing.Policy = output.Skip
+
+	err = renderQuietly([]string{filepath.Join(repository, "notes.txt")}, options)
+	if err == nil {
+		_, err = templates.RenderDirectory(filepath.Join(repository, "skeleton"), nil, outputDir, options)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	provenance, err := templates.ReadProvenance(outputDir)
+	if err != nil || len(provenance.Renders) != 2 {
+		t.Fatalf("Expected two renderings, got <%+v>, %v", provenance, err)
+	}
+
+	for _, rendering := range provenance.Renders {
+		if rendering.Variables["name"] != "shop" {
+			t.Errorf("Expected %s to be recorded with the variables it was written with, got <%v>", rendering.Output, rendering.Variables)
+		}
+	}
+}
+
+func TestVariablesFromTheEnvironmentAreNotRecorded(t *testing.T) {
+	repository, _ := gitRepository(t, map[string]string{
+		"config.yaml": "name: {{ .name }}\ntoken: {{ .registry.token }}\nurl: {{ .registry.url }}\n",
+	})
+
+	t.Setenv("TESTING_TEMPL_registry__token", "s3cret")
+	t.Setenv("TESTING_TEMPL_password", "hunter2")
+
+	outputDir := t.TempDir()
+	options := templates.RenderOptions{
+		Definitions: []string{"name=shop", "registry.url=https://registry.example.com"},
+		EnvPrefix:   "TESTING_TEMPL_",
+		Output:      filepath.Join(outputDir, "config.yaml"),
+	}
+
+	err := renderQuietly([]string{filepath.Join(repository, "config.yaml")}, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	provenance, err := templates.ReadProvenance(outputDir)
+	if err != nil || len(provenance.Renders) != 1 {
+		t.Fatalf("Expected one rendering, got <%+v>, %v", provenance, err)
+	}
+
+	expected := map[string]interface{}{"name": "shop", "registry": map[string]interface{}{"url": "https://registry.example.com"}}
+	if !reflect.DeepEqual(provenance.Renders[0].Variables, expected) {
+		t.Errorf("Expected <%v>, received <%v>", expected, provenance.Renders[0].Variables)
+	}
+
+	content, err := os.ReadFile(filepath.Join(outputDir, templates.ProvenanceFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(string(content), "s3cret") || strings.Contains(string(content), "hunter2") {
+		t.Errorf("Expected no values from the environment in %s, received <%s>", templates.ProvenanceFile, content)
+	}
+}
+
+func TestNoProvenance(t *testing.T) {
+	repository, _ := gitRepository(t, map[string]string{
+		"skeleton/README.md": "# {{ .name }}\n",
+	})
+
+	outputDir := t.TempDir()
+	options := templates.RenderOptions{Definitions: []string{"name=shop"}, NoProvenance: true}
+
+	_, err := templates.RenderDirectory(filepath.Join(repository, "skeleton"), nil, outputDir, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(outputDir, templates.ProvenanceFile)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected no %s, got %v", templates.ProvenanceFile, err)
+	}
+
+	_, err = templates.Regenerate(outputDir, templates.RenderOptions{})
+	if !errors.Is(err, templates.TemplateVariableErr{}) {
+		t.Errorf("Expected regenerating without a record to be a TemplateVariableErr, got %v", err)
+	}
+}
+
+func TestRegenerate(t *testing.T) {
+	repository, first := gitRepository(t, map[string]string{
+		"skeleton/README.md":           "# {{ .name }}\n",
+		"skeleton/{{ .name }}.go":      "package {{ .name }}\n",
+		"skeleton/.templ-answers.yaml": "not part of the template\n",
+	})
+
+	outputDir := t.TempDir()
+	_, err := templates.RenderDirectory(filepath.Join(repository, "skeleton"), nil, outputDir, templates.RenderOptions{Definitions: []string{"name=shop"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The template moves on.
+	err = os.WriteFile(filepath.Join(repository, "skeleton/README.md"), []byte("# {{ .name }}\n\nMade by templ.\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second := commitAll(t, repository)
+
+	var diff strings.Builder
+	_, err = templates.Regenerate(outputDir, templates.RenderOptions{Writing: output.Options{DiffTo: &diff, Check: true}})
+	if !errors.Is(err, output.DriftErr{}) || !strings.Contains(diff.String(), "+Made by templ.") {
+		t.Errorf("Expected the new README to be drift, got %v and <%s>", err, diff.String())
+	}
+
+	written, err := templates.Regenerate(outputDir, templates.RenderOptions{Writing: output.Options{Policy: output.Overwrite}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{filepath.Join(outputDir, "README.md"), filepath.Join(outputDir, "shop.go")}
+	if !reflect.DeepEqual(written, expected) {
+		t.Errorf("Expected to write <%v>, wrote <%v>", expected, written)
+	}
+
+	readme, err := os.ReadFile(filepath.Join(outputDir, "README.md"))
+	if err != nil || string(readme) != "# shop\n\nMade by templ.\n" {
+		t.Errorf("Expected the README of the new template, received <%s>, %v", readme, err)
+	}
+
+	provenance, err := templates.ReadProvenance(outputDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if commit := provenance.Renders[0].Commit; commit != second || commit == first {
+		t.Errorf("Expected the record to move from %s to %s, it's at %s", first, second, commit)
+	}
+
+	// Variables on the command line replace the recorded ones.
+	_, err = templates.Regenerate(outputDir, templates.RenderOptions{Definitions: []string{"name=store"}, Writing: output.Options{Policy: output.Overwrite}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(outputDir, "store.go")); err != nil {
+		t.Errorf("Expected store.go, got %v", err)
+	}
+
+	provenance, err = templates.ReadProvenance(outputDir)
+	if err != nil || provenance.Renders[0].Variables["name"] != "store" {
+		t.Errorf("Expected the new name to be recorded, got <%+v>, %v", provenance, err)
+	}
+}
+
+func TestRegenerateMissingTemplate(t *testing.T) {
+	repository, _ := gitRepository(t, map[string]string{
+		"notes.txt": "{{ .name }}'s notes\n",
+	})
+
+	outputDir := t.TempDir()
+	options := templates.RenderOptions{Definitions: []string{"name=shop"}, Output: filepath.Join(outputDir, "notes.txt")}
+
+	err := renderQuietly([]string{filepath.Join(repository, "notes.txt")}, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.Remove(filepath.Join(repository, "notes.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = templates.Regenerate(outputDir, templates.RenderOptions{Writing: output.Options{Policy: output.Overwrite}})
+	if !errors.Is(err, templates.TemplateVariableErr{}) || !strings.Contains(err.Error(), "notes.txt") {
+		t.Errorf("Expected a TemplateVariableErr about notes.txt, got %v", err)
+	}
+}
+
+func TestRegenerateOnlyRendersTemplatesIntoItsDirectory(t *testing.T) {
+	gitRepository(t, map[string]string{
+		"notes.txt": "{{ .name }}'s notes\n",
+	})
+
+	payload := filepath.Join(t.TempDir(), "payload.txt")
+	err := os.WriteFile(payload, []byte("payload\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"an output outside the directory":        "template: notes.txt\n    repository: templates-repo\n    output: ../victim/rc",
+		"an absolute output":                     "template: notes.txt\n    repository: templates-repo\n    output: VICTIM",
+		"a template that isn't in a repository":  "template: " + payload + "\n    output: notes.txt",
+		"a repository outside the templates dir": "template: notes.txt\n    repository: ../" + filepath.Base(filepath.Dir(payload)) + "\n    output: notes.txt",
+		"a template outside its repository":      "template: ../../" + filepath.Base(payload) + "\n    repository: templates-repo\n    output: notes.txt",
+	}
+
+	for name, entry := range tests {
+		base := t.TempDir()
+		projectDir, victim := filepath.Join(base, "project"), filepath.Join(base, "victim", "rc")
+
+		err := test_helpers.WriteFiles(base, map[string]string{
+			"victim/rc":                           "mine\n",
+			"project/" + templates.ProvenanceFile: "renders:\n  - " + strings.ReplaceAll(entry, "VICTIM", victim) + "\n    variables:\n      name: shop\n",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = templates.Regenerate(projectDir, templates.RenderOptions{Writing: output.Options{Policy: output.Overwrite}})
+		if !errors.Is(err, templates.TemplateVariableErr{}) {
+			t.Errorf("%s: expected a TemplateVariableErr, got %v", name, err)
+		}
+
+		content, err := os.ReadFile(victim)
+		if err != nil || string(content) != "mine\n" {
+			t.Errorf("%s: expected %s to be left alone, received <%s>, %v", name, victim, content, err)
+		}
+	}
+}
@@ -0,0 +1,320 @@
+package templates
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"templ/configelements"
+	"templ/repository"
+	"templ/templatedirectories"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/sirupsen/logrus"
+)
+
+// splitRef splits a template name like go-service@v1.4.0 into the name and the git ref, which is a tag, a branch or a
+// commit. A name without an @ has no ref.
+func splitRef(name string) (string, string) {
+	at := strings.LastIndex(name, "@")
+
+	if at <= 0 || at == len(name)-1 {
+		return name, ""
+	}
+
+	return name[:at], name[at+1:]
+}
+
+// exportsDir is where the commits templates are rendered at are exported to. Each export is a directory of its own,
+// named by exportName.
+func exportsDir() string {
+	cache, err := os.UserCacheDir()
+
+	if err != nil {
+		cache = os.TempDir()
+	}
+
+	return filepath.Join(cache, "templ", "refs")
+}
+
+// exportName names the export of a commit a ref names in a repository, whose path in the templates directory is
+// relative: the repository, the ref and the commit, separated by @s, which are escaped in the first two. Templates
+// exported there are known to be from it, and recorded with its ref, however many other refs name the same commit.
+func exportName(relative string, ref string, commit string) string {
+	return url.QueryEscape(filepath.ToSlash(relative)) + "@" + url.QueryEscape(ref) + "@" + commit
+}
+
+// exportRoot returns the export a path is in, and the repository, the ref and the commit it's named after. It's false
+// for a path that isn't in an export.
+func exportRoot(path string) (root string, relative string, ref string, commit string, found bool) {
+	inExports, err := filepath.Rel(exportsDir(), path)
+
+	if err != nil || !filepath.IsLocal(inExports) {
+		return "", "", "", "", false
+	}
+
+	name, _, _ := strings.Cut(filepath.ToSlash(inExports), "/")
+	parts := strings.Split(name, "@")
+
+	if len(parts) != 3 || strings.HasPrefix(name, ".") {
+		return "", "", "", "", false
+	}
+
+	relative, err = url.QueryUnescape(parts[0])
+
+	if err == nil {
+		ref, err = url.QueryUnescape(parts[1])
+	}
+
+	if err != nil {
+		return "", "", "", "", false
+	}
+
+	return filepath.Join(exportsDir(), name), filepath.FromSlash(relative), ref, parts[2], true
+}
+
+// exportRef exports the commit a ref names in a repository of the templates directory, and returns the directory it's
+// in, which is a repository of its own as far as finding includes goes. Each commit is read from the repository's
+// objects and exported once for each ref, to a directory named by exportName, so its worktree is never touched.
+func exportRef(repositoryDir string, ref string) (string, error) {
+	repo, err := repository.OpenRepository(repositoryDir)
+
+	if err != nil {
+		return "", err
+	}
+
+	commit, err := repo.Resolve(ref)
+
+	if err != nil {
+		return "", err
+	}
+
+	templDir := configelements.NewTemplDir().TemplatesDir
+	relative, err := filepath.Rel(templDir, repositoryDir)
+
+	if err != nil || !filepath.IsLocal(relative) {
+		relative = filepath.Base(repositoryDir)
+	}
+
+	dir := filepath.Join(exportsDir(), exportName(relative, ref, commit))
+
+	// An export that's used is kept; see pruneExports.
+	if _, err := os.Stat(dir); err == nil {
+		now := time.Now()
+		os.Chtimes(dir, now, now)
+		return dir, nil
+	}
+
+	err = os.MkdirAll(filepath.Dir(dir), 0755)
+
+	if err != nil {
+		_, file, line, _ := runtime.Caller(0)
+		return "", fmt.Errorf("%s:%d: %v", file, line, err)
+	}
+
+	// The commit is exported beside where it goes and renamed into place, so a half-made export is never used.
+	exporting, err := os.MkdirTemp(filepath.Dir(dir), "."+filepath.Base(dir)+"-*")
+
+	if err != nil {
+		_, file, line, _ := runtime.Caller(0)
+		return "", fmt.Errorf("%s:%d: %v", file, line, err)
+	}
+
+	defer os.RemoveAll(exporting)
+
+	err = repo.Export(commit, exporting)
+
+	if err == nil {
+		err = os.Rename(exporting, dir)
+	}
+
+	// Someone else exported it first.
+	if _, statErr := os.Stat(dir); err != nil && statErr == nil {
+		err = nil
+	}
+
+	if err != nil {
+		_, file, line, _ := runtime.Caller(0)
+		return "", fmt.Errorf("%s:%d: %v", file, line, err)
+	}
+
+	pruneExports(relative)
+
+	return dir, nil
+}
+
+// exportRetention is how long an export is kept after it was last used.
+const exportRetention = 30 * 24 * time.Hour
+
+// pruneExports removes the exports of a repository, whose path in the templates directory is relative, that haven't
+// been used for exportRetention. They're exported again if they're needed after all.
+func pruneExports(relative string) {
+	entries, err := os.ReadDir(exportsDir())
+
+	if err != nil {
+		return
+	}
+
+	// Exports still being made start with a dot, so they aren't among them.
+	prefix := url.QueryEscape(filepath.ToSlash(relative)) + "@"
+
+	for _, entry := range entries {
+		info, err := entry.Info()
+
+		if err != nil || !entry.IsDir() || !strings.HasPrefix(entry.Name(), prefix) || time.Since(info.ModTime()) < exportRetention {
+			continue
+		}
+
+		err = os.RemoveAll(filepath.Join(exportsDir(), entry.Name()))
+
+		if err != nil {
+			logrus.Warn("Can't remove the old export ", filepath.Join(exportsDir(), entry.Name()), ": ", err)
+		}
+	}
+}
+
+// exportOf says which repository of the templates directory, which commit and which ref, an exported template is
+// from. It's false for a template that isn't in an export.
+func exportOf(path string) (repositoryDir string, commit string, ref string, found bool) {
+	_, relative, ref, commit, found := exportRoot(path)
+
+	if !found {
+		return "", "", "", false
+	}
+
+	return filepath.Join(configelements.NewTemplDir().TemplatesDir, relative), commit, ref, true
+}
+
+// exportedPath is the path of an exported template in its repository.
+func exportedPath(path string) string {
+	relative, err := filepath.Rel(repositoryRoot(path), path)
+
+	if err != nil {
+		return path
+	}
+
+	return relative
+}
+
+// walkRef finds the files and directories of the git repositories in the templates directory, as they are at ref, that
+// match. match is given the path each has in the templates directory. The names are read from the repositories'
+// objects, and only the repositories with something that matches are exported; walkRef returns the paths the matches
+// are exported to. Repositories that don't have ref are left out, and it's a TemplateVariableErr if none of them has it.
+func walkRef(ref string, match func(inTemplDir string, isDir bool) bool) ([]string, error) {
+	repositories, err := templatedirectories.FindRepositories()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var exported []string
+	found := false
+
+	for _, repositoryDir := range repositories {
+		repo, err := repository.OpenRepository(repositoryDir)
+
+		if err == nil {
+			var paths []string
+			paths, err = repo.Paths(ref)
+
+			if err == nil {
+				found = true
+				paths = matchingPaths(repositoryDir, paths, match)
+			}
+
+			if err == nil && len(paths) > 0 {
+				var dir string
+				dir, err = exportRef(repositoryDir, ref)
+
+				for _, path := range paths {
+					exported = append(exported, filepath.Join(dir, filepath.FromSlash(path)))
+				}
+			}
+		}
+
+		// Directories that only look like repositories don't have any refs.
+		if errors.Is(err, repository.ErrUnknownRevision{}) || errors.Is(err, git.ErrRepositoryNotExists) {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !found {
+		return nil, TemplateVariableErr{ErrorMessage: fmt.Sprintf("none of the repositories in the templates directory has %s", ref)}
+	}
+
+	return exported, nil
+}
+
+// matchingPaths returns the paths of the files of a repository, and of the directories they're in, that match.
+func matchingPaths(repositoryDir string, files []string, match func(inTemplDir string, isDir bool) bool) []string {
+	var matched []string
+	seen := make(map[string]bool)
+
+	// The repository itself is a directory template too.
+	if match(repositoryDir, true) {
+		matched = append(matched, ".")
+	}
+
+	for _, file := range files {
+		var dirs []string
+
+		for dir := path.Dir(file); dir != "." && !seen[dir]; dir = path.Dir(dir) {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+
+		// Outermost first, the way they're walked.
+		slices.Reverse(dirs)
+
+		for _, dir := range dirs {
+			if match(filepath.Join(repositoryDir, filepath.FromSlash(dir)), true) {
+				matched = append(matched, dir)
+			}
+		}
+
+		if match(filepath.Join(repositoryDir, filepath.FromSlash(file)), false) {
+			matched = append(matched, file)
+		}
+	}
+
+	return matched
+}
+
+// findFilesAtRef is findFilesByName for the templates as they are at a git ref. It returns the paths they're exported
+// to.
+func findFilesAtRef(name string, ref string) ([]string, error) {
+	found, err := walkRef(ref, func(inTemplDir string, isDir bool) bool {
+		return !isDir && !templatedirectories.IsHelperFile(inTemplDir) && strings.Contains(inTemplDir, name)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(found) == 0 {
+		return nil, TemplateVariableErr{ErrorMessage: fmt.Sprintf("can't find a template called %s at %s", name, ref)}
+	}
+
+	return found, nil
+}
+
+// findDirectoriesAtRef finds the directory templates called name as they are at a git ref, like
+// FindTemplateDirectory does. It returns the paths they're exported to.
+func findDirectoriesAtRef(name string, ref string) ([]string, error) {
+	whole := filepath.Join(configelements.NewTemplDir().TemplatesDir, name)
+	suffix := "/" + strings.Trim(filepath.ToSlash(name), "/")
+
+	return walkRef(ref, func(inTemplDir string, isDir bool) bool {
+		return isDir && (inTemplDir == whole || strings.HasSuffix(filepath.ToSlash(inTemplDir), suffix))
+	})
+}
@@ -0,0 +1,213 @@
+package templates_test
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"templ/output"
+	"templ/templates"
+	"templ/test_helpers"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// refRepository is a git repository with a tag, v1, on its first commit, and a second commit that changes every
+// template. It returns the repository and the hashes of the two commits.
+func refRepository(t *testing.T) (string, string, string) {
+	// Refs are exported to the cache directory.
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+
+	repository, first := gitRepository(t, map[string]string{
+		"build.yaml":          "version: 1\n{{ include \"steps.yaml\" . }}",
+		"steps.yaml":          "name: {{ .name }}\n",
+		"skeleton/README.md":  "# {{ .name }} 1\n",
+		"skeleton/bin/run.sh": "echo 1\n",
+	})
+
+	gitRepo, err := git.PlainOpen(repository)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = gitRepo.CreateTag("v1", plumbing.NewHash(first), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes := map[string]string{
+		"build.yaml":         "version: 2\n{{ include \"steps.yaml\" . }}",
+		"steps.yaml":         "name: {{ .name }}!\n",
+		"skeleton/README.md": "# {{ .name }} 2\n",
+	}
+
+	for path, content := range changes {
+		err = os.WriteFile(filepath.Join(repository, path), []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return repository, first, commitAll(t, repository)
+}
+
+func TestRenderAtRef(t *testing.T) {
+	repository, first, _ := refRepository(t)
+
+	// Another repository with the ref, but not the template, isn't exported.
+	other := filepath.Join(filepath.Dir(repository), "other-repo")
+	otherRepo, err := git.PlainInit(other, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(filepath.Join(other, "unrelated.yaml"), []byte("other\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = otherRepo.CreateTag("v1", plumbing.NewHash(commitAll(t, other)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ref := range []string{"v1", first} {
+		templateFiles, variablesFiles, err := templates.FindTemplateAndVariableFiles([]string{"build.yaml@" + ref + "=vars.yaml"})
+		if err != nil {
+			t.Fatalf("%s: %v", ref, err)
+		}
+
+		if len(templateFiles) != 1 || !reflect.DeepEqual(variablesFiles[templateFiles[0]], []string{"vars.yaml"}) {
+			t.Fatalf("%s: expected one template with vars.yaml, received <%v>, <%v>", ref, templateFiles, variablesFiles)
+		}
+
+		rendered, err := renderToString(templateFiles, templates.RenderOptions{Definitions: []string{"name=shop"}})
+		if err != nil || rendered != "version: 1\nname: shop\n" {
+			t.Errorf("%s: expected the template and its include as they were, received <%s>, %v", ref, rendered, err)
+		}
+	}
+
+	var exported []string
+	err = filepath.WalkDir(os.Getenv("XDG_CACHE_HOME"), func(path string, entry fs.DirEntry, err error) error {
+		exported = append(exported, path)
+		return err
+	})
+
+	if err != nil || strings.Contains(strings.Join(exported, "\n"), "other-repo") {
+		t.Errorf("Expected only templates-repo to be exported, received <%v>, %v", exported, err)
+	}
+
+	// What's checked out is left alone.
+	content, err := os.ReadFile(filepath.Join(repository, "build.yaml"))
+	if err != nil || !strings.HasPrefix(string(content), "version: 2") {
+		t.Errorf("Expected the worktree to be left alone, received <%s>, %v", content, err)
+	}
+
+	_, _, err = templates.FindTemplateAndVariableFiles([]string{"build.yaml@v9"})
+	if !errors.Is(err, templates.TemplateVariableErr{}) {
+		t.Errorf("Expected a ref that isn't there to be a TemplateVariableErr, got %v", err)
+	}
+
+	_, _, err = templates.FindTemplateAndVariableFiles([]string{"nothing.yaml@v1"})
+	if !errors.Is(err, templates.TemplateVariableErr{}) {
+		t.Errorf("Expected a template that isn't at the ref to be a TemplateVariableErr, got %v", err)
+	}
+}
+
+func TestRenderDirectoryAtRef(t *testing.T) {
+	repository, first, _ := refRepository(t)
+
+	skeleton, err := templates.FindTemplateDirectory("skeleton@v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Another ref on the same commit, found before either is rendered, is recorded as itself.
+	gitRepo, err := git.PlainOpen(repository)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = gitRepo.Storer.SetReference(plumbing.NewHashReference(plumbing.NewBranchReferenceName("stable"), plumbing.NewHash(first)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stable, err := templates.FindTemplateDirectory("skeleton@stable")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outputDir := t.TempDir()
+	_, err = templates.RenderDirectory(skeleton, nil, outputDir, templates.RenderOptions{Definitions: []string{"name=shop"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	readme, err := os.ReadFile(filepath.Join(outputDir, "README.md"))
+	if err != nil || string(readme) != "# shop 1\n" {
+		t.Errorf("Expected the README as it was at v1, received <%s>, %v", readme, err)
+	}
+
+	provenance, err := templates.ReadProvenance(outputDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rendering := provenance.Renders[0]
+	if rendering.Template != "skeleton" || rendering.Repository != "templates-repo" || rendering.Ref != "v1" || rendering.Commit != first {
+		t.Errorf("Expected skeleton of templates-repo at v1, %s, received <%+v>", first, rendering)
+	}
+
+	if _, err := os.Stat(filepath.Join(filepath.Dir(skeleton), ".git")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected the export not to pretend to be a git repository, got %v", err)
+	}
+
+	stableDir := t.TempDir()
+	_, err = templates.RenderDirectory(stable, nil, stableDir, templates.RenderOptions{Definitions: []string{"name=shop"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for dir, ref := range map[string]string{outputDir: "v1", stableDir: "stable"} {
+		provenance, err := templates.ReadProvenance(dir)
+		if err != nil || provenance.Renders[0].Ref != ref || provenance.Renders[0].Commit != first {
+			t.Errorf("Expected the rendering to be recorded at %s, %s, received <%+v>, %v", ref, first, provenance, err)
+		}
+	}
+
+	// Regenerating renders it at the same ref.
+	err = os.WriteFile(filepath.Join(outputDir, "README.md"), []byte("changed\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = templates.Regenerate(outputDir, templates.RenderOptions{Writing: output.Options{Policy: output.Overwrite}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	readme, err = os.ReadFile(filepath.Join(outputDir, "README.md"))
+	if err != nil || string(readme) != "# shop 1\n" {
+		t.Errorf("Expected regenerating to render v1 again, received <%s>, %v", readme, err)
+	}
+}
+
+// renderToString renders templates with templates.RenderFromFiles, and returns what's printed.
+func renderToString(templateFiles []string, options templates.RenderOptions) (string, error) {
+	var renderErr error
+
+	rendered, err := test_helpers.CaptureStdout(func() {
+		renderErr = templates.RenderFromFiles(templateFiles, nil, options)
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	return rendered, renderErr
+}
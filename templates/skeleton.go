@@ -0,0 +1,340 @@
+package templates
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SkeletonFormats are the formats a starter variables file can be written in.
+var SkeletonFormats = []string{"yaml", "json"}
+
+// VariablesSkeleton returns a starter variables file, in one of SkeletonFormats, holding every one of variables. Each
+// variable is filled with its declared default, or its first choice, or a placeholder for its type: "" for strings, 0,
+// false, [] and {}. An undeclared variable's type is guessed from how it's used: false for one only used in
+// conditions, [] for one that's ranged over and {} for one used in a with. Dotted paths become nested maps and a list
+// whose items have fields, like services[].name, becomes a list with one example item. In yaml, each variable's
+// description is a comment above it.
+func VariablesSkeleton(variables []Variable, format string) ([]byte, error) {
+	root := &yaml.Node{Kind: yaml.MappingNode}
+
+	for _, variable := range variables {
+		err := addToSkeleton(root, strings.Split(variable.Name, "."), variable)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var buffer bytes.Buffer
+
+	switch format {
+	case "", "yaml":
+		buffer.WriteString("---\n")
+		encoder := yaml.NewEncoder(&buffer)
+		encoder.SetIndent(2)
+
+		err := encoder.Encode(root)
+
+		if err != nil {
+			return nil, err
+		}
+	case "json":
+		err := writeJsonNode(&buffer, root, "")
+
+		if err != nil {
+			return nil, err
+		}
+		buffer.WriteString("\n")
+	default:
+		return nil, TemplateVariableErr{ErrorMessage: fmt.Sprintf("can't write a variables file as <%s>, expected one of %s", format, strings.Join(SkeletonFormats, ", "))}
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// addToSkeleton puts a variable at the end of path, making maps and lists along the way.
+func addToSkeleton(mapping *yaml.Node, path []string, variable Variable) error {
+	segment, isItem := strings.CutSuffix(path[0], "[]")
+	key, value := skeletonEntry(mapping, segment)
+
+	if len(path) == 1 && !isItem {
+		if variable.Description != "" {
+			key.HeadComment = variable.Description
+		}
+
+		// A map or list made for an earlier variable's fields stays what it is.
+		if value.Kind == 0 {
+			return placeholder(value, variable)
+		}
+		return nil
+	}
+
+	if !isItem {
+		asContainer(value, yaml.MappingNode)
+		return addToSkeleton(value, path[1:], variable)
+	}
+
+	asContainer(value, yaml.SequenceNode)
+
+	if len(value.Content) == 0 {
+		value.Content = append(value.Content, &yaml.Node{Kind: yaml.MappingNode})
+	}
+
+	return addToSkeleton(value.Content[0], path[1:], variable)
+}
+
+// skeletonEntry finds the key and value nodes for key in a mapping, adding an empty pair if there aren't any.
+func skeletonEntry(mapping *yaml.Node, key string) (*yaml.Node, *yaml.Node) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i], mapping.Content[i+1]
+		}
+	}
+
+	keyNode := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}
+	valueNode := &yaml.Node{}
+	mapping.Content = append(mapping.Content, keyNode, valueNode)
+
+	return keyNode, valueNode
+}
+
+// asContainer turns a node into a block map or list, unless it already is one.
+func asContainer(node *yaml.Node, kind yaml.Kind) {
+	if node.Kind == kind {
+		node.Style = 0
+		return
+	}
+
+	*node = yaml.Node{Kind: kind}
+}
+
+// placeholder fills a node with the starting value for a variable.
+func placeholder(node *yaml.Node, variable Variable) error {
+	var value interface{} = ""
+
+	switch {
+	case variable.Default != nil:
+		value = variable.Default
+	case len(variable.Choices) > 0:
+		value = variable.Choices[0]
+	case variable.Type == "int":
+		value = 0
+	case variable.Type == "bool" || (variable.Type == "" && onlyUsedAs(variable, "conditional")):
+		value = false
+	case variable.Type == "list" || (variable.Type == "" && usedAs(variable, "range")):
+		*node = yaml.Node{Kind: yaml.SequenceNode, Style: yaml.FlowStyle}
+		return nil
+	case variable.Type == "map" || (variable.Type == "" && usedAs(variable, "with")):
+		*node = yaml.Node{Kind: yaml.MappingNode, Style: yaml.FlowStyle}
+		return nil
+	}
+
+	return node.Encode(value)
+}
+
+func onlyUsedAs(variable Variable, context string) bool {
+	for _, reference := range variable.References {
+		if reference.Context != context {
+			return false
+		}
+	}
+	return len(variable.References) > 0
+}
+
+func usedAs(variable Variable, context string) bool {
+	for _, reference := range variable.References {
+		if reference.Context == context {
+			return true
+		}
+	}
+	return false
+}
+
+// writeJsonNode writes a yaml node as indented json, keeping the order of its keys.
+func writeJsonNode(out *bytes.Buffer, node *yaml.Node, indent string) error {
+	switch node.Kind {
+	case yaml.MappingNode:
+		if len(node.Content) == 0 {
+			out.WriteString("{}")
+			return nil
+		}
+
+		out.WriteString("{\n")
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, _ := json.Marshal(node.Content[i].Value)
+			fmt.Fprintf(out, "%s  %s: ", indent, key)
+
+			err := writeJsonNode(out, node.Content[i+1], indent+"  ")
+			if err != nil {
+				return err
+			}
+
+			if i+2 < len(node.Content) {
+				out.WriteString(",")
+			}
+			out.WriteString("\n")
+		}
+		out.WriteString(indent + "}")
+	case yaml.SequenceNode:
+		if len(node.Content) == 0 {
+			out.WriteString("[]")
+			return nil
+		}
+
+		out.WriteString("[\n")
+		for i, item := range node.Content {
+			out.WriteString(indent + "  ")
+
+			err := writeJsonNode(out, item, indent+"  ")
+			if err != nil {
+				return err
+			}
+
+			if i+1 < len(node.Content) {
+				out.WriteString(",")
+			}
+			out.WriteString("\n")
+		}
+		out.WriteString(indent + "]")
+	default:
+		var value interface{}
+
+		err := node.Decode(&value)
+		if err != nil {
+			return err
+		}
+
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		out.Write(encoded)
+	}
+
+	return nil
+}
+
+// InitVariables writes a starter variables file for templates. A template given a variables file, like
+// templatename=vars.yaml, has its skeleton written to that file, which must not exist yet; the skeletons of the rest
+// are printed to out. Templates that share a file share one skeleton. The format is options.VariablesFormat, or else
+// picked from the file's name, and is yaml for out.
+func InitVariables(templateFiles []string, templateVariables map[string][]string, options RenderOptions, out io.Writer) error {
+	err := validateTemplatesExist(templateFiles)
+
+	if err != nil {
+		return err
+	}
+
+	// The variables for each target, in the order targets first appear. "" is out.
+	var targets []string
+	variables := make(map[string][]Variable)
+
+	for _, templatePath := range templateFiles {
+		target := ""
+
+		switch files := templateVariables[templatePath]; len(files) {
+		case 0:
+		case 1:
+			target = files[0]
+		default:
+			return TemplateVariableErr{ErrorMessage: fmt.Sprintf("%s: a starter variables file can only be written to one file, got %s", templatePath, strings.Join(files, ","))}
+		}
+
+		content, err := os.ReadFile(templatePath)
+
+		if err != nil {
+			_, file, line, _ := runtime.Caller(0)
+			return fmt.Errorf("%s:%d: %v", file, line, err)
+		}
+
+		found, err := RetrieveTemplateVariables(templatePath, string(content))
+
+		if err != nil {
+			_, file, line, _ := runtime.Caller(0)
+			return fmt.Errorf("%s:%d: %s: %w", file, line, templatePath, err)
+		}
+
+		if _, seen := variables[target]; !seen {
+			targets = append(targets, target)
+		}
+
+		for _, variable := range found {
+			if !containsVariable(variables[target], variable.Name) {
+				variables[target] = append(variables[target], variable)
+			}
+		}
+	}
+
+	for _, target := range targets {
+		format := options.VariablesFormat
+
+		if format == "" && target != "" {
+			format = variablesFormat(target)
+		}
+
+		skeleton, err := VariablesSkeleton(variables[target], format)
+
+		if err != nil {
+			return err
+		}
+
+		if target != "" {
+			err = writeNewFile(target, skeleton)
+
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		_, err = out.Write(skeleton)
+
+		if err != nil {
+			_, file, line, _ := runtime.Caller(0)
+			return fmt.Errorf("%s:%d: %w", file, line, err)
+		}
+	}
+
+	return nil
+}
+
+func containsVariable(variables []Variable, name string) bool {
+	for _, variable := range variables {
+		if variable.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// writeNewFile writes content to a file that mustn't exist yet. A file that's there already is a TemplateVariableErr.
+func writeNewFile(filePath string, content []byte) error {
+	f, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+
+	if errors.Is(err, os.ErrExist) {
+		return TemplateVariableErr{ErrorMessage: fmt.Sprintf("%s already exists, templ won't overwrite it", filePath)}
+	}
+
+	if err == nil {
+		_, err = f.Write(content)
+
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}
+
+	if err != nil {
+		_, file, line, _ := runtime.Caller(0)
+		return fmt.Errorf("%s:%d: %v", file, line, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,173 @@
+package templates_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"templ/templates"
+	"templ/test_helpers"
+	"testing"
+)
+
+const skeletonTemplate = `---
+templ:
+  variables:
+    NAME:
+      description: the name of the service
+      required: true
+    REPLICAS:
+      type: int
+      default: 2
+    ENVIRONMENT:
+      choices: [dev, prod]
+---
+{{ .NAME }} {{ .REPLICAS }} {{ .ENVIRONMENT }}
+{{ if .debug }}debugging{{ end }}
+{{ with .database }}{{ .host }}:{{ .port }}{{ end }}
+{{ range .services }}{{ .name }}{{ end }}
+{{ range .ports }}{{ . }}{{ end }}`
+
+func TestVariablesSkeletonAsYaml(t *testing.T) {
+	variables, err := templates.RetrieveVariables(skeletonTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	skeleton, err := templates.VariablesSkeleton(variables, "yaml")
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	expected := `---
+# the name of the service
+NAME: ""
+REPLICAS: 2
+ENVIRONMENT: dev
+debug: false
+database:
+  host: ""
+  port: ""
+services:
+  - name: ""
+ports: []
+`
+	if string(skeleton) != expected {
+		t.Errorf("Expected <%s>, received <%s>", expected, skeleton)
+	}
+}
+
+func TestVariablesSkeletonAsJson(t *testing.T) {
+	variables, err := templates.RetrieveVariables(`{{ .NAME }}{{ range .services }}{{ .port }}{{ end }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	skeleton, err := templates.VariablesSkeleton(variables, "json")
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	expected := `{
+  "NAME": "",
+  "services": [
+    {
+      "port": ""
+    }
+  ]
+}
+`
+	if string(skeleton) != expected {
+		t.Errorf("Expected <%s>, received <%s>", expected, skeleton)
+	}
+}
+
+func TestVariablesSkeletonRejectsOtherFormats(t *testing.T) {
+	_, err := templates.VariablesSkeleton(nil, "toml")
+
+	if !errors.Is(err, templates.TemplateVariableErr{}) {
+		t.Errorf("Expected a TemplateVariableErr, got %v", err)
+	}
+}
+
+func TestInitVariablesWritesSharedFile(t *testing.T) {
+	dir := t.TempDir()
+
+	err := test_helpers.WriteFiles(dir, map[string]string{
+		"service.yaml":    `{{ .NAME }} {{ .database.host }}`,
+		"deployment.yaml": `{{ .NAME }} {{ .REPLICAS }}`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	service := filepath.Join(dir, "service.yaml")
+	deployment := filepath.Join(dir, "deployment.yaml")
+	varsFile := filepath.Join(dir, "vars.json")
+
+	var out strings.Builder
+	err = templates.InitVariables([]string{service, deployment}, map[string][]string{service: {varsFile}, deployment: {varsFile}}, templates.RenderOptions{}, &out)
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if out.Len() != 0 {
+		t.Errorf("Expected nothing printed, received <%s>", out.String())
+	}
+
+	content, err := os.ReadFile(varsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{
+  "NAME": "",
+  "database": {
+    "host": ""
+  },
+  "REPLICAS": ""
+}
+`
+	if string(content) != expected {
+		t.Errorf("Expected <%s>, received <%s>", expected, content)
+	}
+
+	// The skeleton can be handed straight back to render the templates.
+	variables, err := templates.LoadVariables([]string{varsFile}, templates.RenderOptions{})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if _, found := variables.Values["database"].(map[string]interface{}); !found {
+		t.Errorf("Expected database to load as a map, received <%v>", variables.Values)
+	}
+
+	err = templates.InitVariables([]string{service}, map[string][]string{service: {varsFile}}, templates.RenderOptions{}, &out)
+
+	if !errors.Is(err, templates.TemplateVariableErr{}) || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("Expected an existing file to be left alone, got %v", err)
+	}
+}
+
+func TestInitVariablesPrintsWithoutVariablesFile(t *testing.T) {
+	dir := t.TempDir()
+
+	err := test_helpers.WriteFiles(dir, map[string]string{"service.yaml": `{{ .NAME }}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	err = templates.InitVariables([]string{filepath.Join(dir, "service.yaml")}, map[string][]string{}, templates.RenderOptions{}, &out)
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if out.String() != "---\nNAME: \"\"\n" {
+		t.Errorf("Expected <---\nNAME: \"\"\n>, received <%s>", out.String())
+	}
+}
@@ -0,0 +1,205 @@
+package templates
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// guardFunctions deal with a variable that's missing themselves, so strict mode leaves the variables passed to them,
+// like {{ .name | default "x" }}, to them.
+var guardFunctions = []string{"default", "coalesce", "empty", "required"}
+
+// guardedLookup is the function strict mode looks guarded variables up with, so that missingkey=error doesn't apply to
+// them. It's only added to the templates of a strict render, after they're parsed.
+const guardedLookup = "_templGuardedLookup"
+
+// MissingVariablesErr lists every variable a template references that was not supplied.
+type MissingVariablesErr struct {
+	Template string
+	Missing  []MissingVariable
+}
+
+// MissingVariable is a variable path, like database.host, and the template lines it is referenced on.
+type MissingVariable struct {
+	Name  string
+	Lines []int
+}
+
+func (m MissingVariablesErr) Error() string {
+	var message strings.Builder
+
+	fmt.Fprintf(&message, "%s: missing variables:", m.Template)
+
+	for _, missing := range m.Missing {
+		lines := make([]string, 0, len(missing.Lines))
+		for _, line := range missing.Lines {
+			lines = append(lines, fmt.Sprint(line))
+		}
+
+		fmt.Fprintf(&message, "\n  %s (line %s)", missing.Name, strings.Join(lines, ", "))
+	}
+
+	return message.String()
+}
+
+func (m MissingVariablesErr) Is(target error) bool {
+	_, ok := target.(MissingVariablesErr)
+	return ok
+}
+
+// findMissingVariables returns each of a template's variables that is not in values, with the lines it's referenced on.
+// Fields of list items, like services[].name, can't be checked before rendering and are left to the engine. Neither
+// are the fields of a missing variable, since database.host is no news once database is missing, nor the variables
+// that are only passed to guardFunctions.
+func findMissingVariables(variables []Variable, values map[string]interface{}) []MissingVariable {
+	var missing []MissingVariable
+
+	for _, variable := range variables {
+		if len(variable.References) == 0 || strings.Contains(variable.Name, "[]") {
+			continue
+		}
+
+		if _, found := lookupVariable(values, strings.Split(variable.Name, ".")); found || hasMissingParent(missing, variable.Name) {
+			continue
+		}
+
+		var lines []int
+		for _, reference := range variable.References {
+			if reference.Guarded {
+				continue
+			}
+			if len(lines) == 0 || lines[len(lines)-1] != reference.Line {
+				lines = append(lines, reference.Line)
+			}
+		}
+
+		// Only passed to guardFunctions, which take care of it being missing.
+		if len(lines) == 0 {
+			continue
+		}
+
+		missing = append(missing, MissingVariable{Name: variable.Name, Lines: lines})
+	}
+
+	return missing
+}
+
+func hasMissingParent(missing []MissingVariable, name string) bool {
+	for _, m := range missing {
+		if strings.HasPrefix(name, m.Name+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// isGuarded reports whether the arguments of the command at index in a pipe are passed to one of the guardFunctions:
+// either the command calls one, like {{ default "x" .name }}, or it's a lone variable piped into one, like
+// {{ .name | default "x" }}.
+func isGuarded(pipe *parse.PipeNode, index int) bool {
+	if callsGuard(pipe.Cmds[index]) {
+		return true
+	}
+
+	return index+1 < len(pipe.Cmds) && len(pipe.Cmds[index].Args) == 1 && callsGuard(pipe.Cmds[index+1])
+}
+
+func callsGuard(command *parse.CommandNode) bool {
+	if len(command.Args) == 0 {
+		return false
+	}
+
+	identifier, ok := command.Args[0].(*parse.IdentifierNode)
+	return ok && slices.Contains(guardFunctions, identifier.Ident)
+}
+
+// guardVariables rewrites every variable passed to guardFunctions in tmpl's templates, like .database.host, into
+// a call to guardedLookup, which gives nil for a variable that's missing rather than failing with missingkey=error.
+func guardVariables(tmpl *template.Template) {
+	tmpl.Funcs(template.FuncMap{guardedLookup: lookupGuarded})
+
+	for _, t := range tmpl.Templates() {
+		if t.Tree != nil {
+			guardNode(t.Tree.Root)
+		}
+	}
+}
+
+func guardNode(node parse.Node) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			guardNode(child)
+		}
+	case *parse.ActionNode:
+		guardNode(n.Pipe)
+	case *parse.IfNode:
+		guardNode(n.Pipe)
+		guardNode(n.List)
+		guardNode(n.ElseList)
+	case *parse.RangeNode:
+		guardNode(n.Pipe)
+		guardNode(n.List)
+		guardNode(n.ElseList)
+	case *parse.WithNode:
+		guardNode(n.Pipe)
+		guardNode(n.List)
+		guardNode(n.ElseList)
+	case *parse.TemplateNode:
+		guardNode(n.Pipe)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for i, command := range n.Cmds {
+			guarded := isGuarded(n, i)
+			for j, arg := range command.Args {
+				if guarded {
+					command.Args[j] = guardedArgument(arg)
+				}
+				guardNode(command.Args[j])
+			}
+		}
+	case *parse.ChainNode:
+		guardNode(n.Node)
+	}
+}
+
+// guardedArgument returns the call to guardedLookup that replaces a variable, or the node as it is if it isn't one.
+func guardedArgument(node parse.Node) parse.Node {
+	var data parse.Node
+	var fields []string
+
+	switch n := node.(type) {
+	case *parse.FieldNode:
+		data, fields = &parse.DotNode{NodeType: parse.NodeDot, Pos: n.Pos}, n.Ident
+	case *parse.VariableNode:
+		if len(n.Ident) == 1 {
+			return node
+		}
+		data, fields = &parse.VariableNode{NodeType: parse.NodeVariable, Pos: n.Pos, Ident: n.Ident[:1]}, n.Ident[1:]
+	default:
+		return node
+	}
+
+	args := []parse.Node{parse.NewIdentifier(guardedLookup).SetPos(node.Position()), data}
+	for _, field := range fields {
+		args = append(args, &parse.StringNode{NodeType: parse.NodeString, Pos: node.Position(), Quoted: strconv.Quote(field), Text: field})
+	}
+
+	command := &parse.CommandNode{NodeType: parse.NodeCommand, Pos: node.Position(), Args: args}
+	return &parse.PipeNode{NodeType: parse.NodePipe, Pos: node.Position(), Cmds: []*parse.CommandNode{command}}
+}
+
+// lookupGuarded looks fields up in data, giving nil if any of them is missing.
+func lookupGuarded(data interface{}, fields ...string) interface{} {
+	value, _ := lookupVariable(map[string]interface{}{"": data}, append([]string{""}, fields...))
+	return value
+}
@@ -0,0 +1,167 @@
+package templates_test
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"templ/templates"
+	"templ/test_helpers"
+	"testing"
+)
+
+func TestStrictRenderFromStdinListsEveryMissingVariable(t *testing.T) {
+	template := `name: {{ .NAME }}
+host: {{ .database.host }}
+{{- range .ports }}
+port: {{ .number }} {{ $.PROTOCOL }}
+{{- end }}
+again: {{ .NAME }}`
+
+	_, err := templates.RenderFromStdin(template, []string{"ports=80"}, templates.RenderOptions{Strict: true})
+
+	var missingErr templates.MissingVariablesErr
+	if !errors.As(err, &missingErr) {
+		t.Fatalf("Expected a MissingVariablesErr, got %v", err)
+	}
+
+	expected := []templates.MissingVariable{
+		{Name: "NAME", Lines: []int{1, 6}},
+		{Name: "database.host", Lines: []int{2}},
+		{Name: "PROTOCOL", Lines: []int{4}},
+	}
+
+	if !reflect.DeepEqual(missingErr.Missing, expected) {
+		t.Errorf("Expected <%v>, received <%v>", expected, missingErr.Missing)
+	}
+}
+
+func TestStrictRenderFromStdinWithoutDefinitionsStillChecks(t *testing.T) {
+	_, err := templates.RenderFromStdin(`I love {{ .SPECIES }}`, []string{}, templates.RenderOptions{Strict: true})
+
+	if !errors.Is(err, templates.MissingVariablesErr{}) {
+		t.Errorf("Expected a MissingVariablesErr, got %v", err)
+	}
+}
+
+func TestStrictRenderFromStdinWithEverythingSupplied(t *testing.T) {
+	hydratedTemplate, err := templates.RenderFromStdin(`I love {{ .SPECIES }}`, []string{"SPECIES=HUMAN"}, templates.RenderOptions{Strict: true})
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if hydratedTemplate != "I love HUMAN" {
+		t.Errorf("Expected <I love HUMAN>, received <%s>", hydratedTemplate)
+	}
+}
+
+func TestStrictRenderFromFilesPrintsNothingOnFailure(t *testing.T) {
+	dir := t.TempDir()
+
+	err := test_helpers.WriteFiles(dir, map[string]string{
+		"complete.yaml":   `name: {{ .name }}`,
+		"incomplete.yaml": `name: {{ .name }} owner: {{ .owner }}`,
+		"variables.yaml":  `name: shop`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	complete := filepath.Join(dir, "complete.yaml")
+	incomplete := filepath.Join(dir, "incomplete.yaml")
+	variablesFile := filepath.Join(dir, "variables.yaml")
+
+	var renderErr error
+	output, err := test_helpers.CaptureStdout(func() {
+		renderErr = templates.RenderFromFiles(
+			[]string{complete, incomplete},
+			map[string][]string{complete: {variablesFile}, incomplete: {variablesFile}},
+			templates.RenderOptions{Strict: true})
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !errors.Is(renderErr, templates.MissingVariablesErr{}) {
+		t.Errorf("Expected a MissingVariablesErr, got %v", renderErr)
+	}
+
+	if output != "" {
+		t.Errorf("Strict mode should print nothing on failure, printed <%s>", output)
+	}
+}
+
+func TestStrictRenderReportsUnknownFunctions(t *testing.T) {
+	template := `upper: {{ uper .name }}
+github: {{ env.FOO }}
+jinja: {{ name | default('x') }}
+helm: {{ .Values.image | toYaml }}
+{{ if isProd .environment }}prod{{ end }}`
+
+	rendered, err := templates.RenderFromStdin(template, []string{"name=shop"}, templates.RenderOptions{})
+	if err != nil || rendered != template {
+		t.Errorf("Expected the template to be left as it is without -strict, received <%s>, %v", rendered, err)
+	}
+
+	_, err = templates.RenderFromStdin(template, []string{"name=shop"}, templates.RenderOptions{Strict: true})
+
+	var unknowns interface{ Unwrap() []error }
+	if !errors.As(err, &unknowns) {
+		t.Fatalf("Expected every unknown function to be reported, got %v", err)
+	}
+
+	var lines []int
+	for _, err := range unknowns.Unwrap() {
+		var templateErr templates.TemplateError
+		if errors.As(err, &templateErr) {
+			lines = append(lines, templateErr.Line)
+		}
+	}
+
+	if !reflect.DeepEqual(lines, []int{1, 5}) {
+		t.Errorf("Expected the actions on lines 1 and 5 to be reported, received <%v>, %v", lines, err)
+	}
+
+	if !strings.Contains(err.Error(), "uper isn't a function templ knows") {
+		t.Errorf("Expected the error to name uper, got %v", err)
+	}
+}
+
+func TestStrictRenderLeavesGuardedVariablesToTheirGuards(t *testing.T) {
+	template := `name: {{ .name | default "x" }}
+host: {{ default "y" .database.host }}
+port: {{ coalesce .port "80" }}
+{{- with $settings := .settings }}
+mode: {{ $settings.mode | default "fast" }}
+{{- end }}
+{{- if empty .debug }}
+quiet: {{ .quiet }}
+{{- end }}`
+
+	hydratedTemplate, err := templates.RenderFromStdin(template, []string{"settings.size=1", "quiet=yes"}, templates.RenderOptions{Strict: true})
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	expected := "name: x\nhost: y\nport: 80\nmode: fast\nquiet: yes"
+	if hydratedTemplate != expected {
+		t.Errorf("Expected <%s>, received <%s>", expected, hydratedTemplate)
+	}
+
+	// required fails with its own message, and a variable that's also used unguarded is still missing.
+	_, err = templates.RenderFromStdin(`{{ required "the owner is needed" .owner }}`, []string{}, templates.RenderOptions{Strict: true})
+
+	if err == nil || !strings.Contains(err.Error(), "the owner is needed") {
+		t.Errorf("Expected required's message, got %v", err)
+	}
+
+	_, err = templates.RenderFromStdin("{{ .name | default \"x\" }}\n{{ .name }}", []string{}, templates.RenderOptions{Strict: true})
+
+	var missingErr templates.MissingVariablesErr
+	if !errors.As(err, &missingErr) || !reflect.DeepEqual(missingErr.Missing, []templates.MissingVariable{{Name: "name", Lines: []int{2}}}) {
+		t.Errorf("Expected name to be missing on line 2, got %v", err)
+	}
+}
@@ -4,19 +4,16 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"io"
 	"os"
-	"path"
 	"path/filepath"
-	"regexp"
 	"runtime"
-	"slices"
-	"strconv"
 	"strings"
 	"templ/configelements"
-	"text/template"
+	"templ/output"
+	"templ/templatedirectories"
 
 	"github.com/sirupsen/logrus"
-	"gopkg.in/yaml.v3"
 )
 
 type TemplateVariableErr struct {
@@ -32,48 +29,93 @@ func (t TemplateVariableErr) Is(target error) bool {
 	return ok
 }
 
-func RenderFromStdin(template string, variableDefinitions []string) (hydratedtemplate string, err error) {
-	// If we don't receive any arguments, just pass back up the chain.
-	if len(variableDefinitions) == 0 {
-		return template, nil
-	}
+// RenderOptions change how templates are rendered.
+type RenderOptions struct {
+	// Strict refuses to render a template unless every variable it references has been supplied. The error lists all
+	// of the missing variables at once.
+	Strict bool
+	// EnvPrefix picks out the environment variables that are template variables. See LoadVariables.
+	EnvPrefix string
+	// Definitions are KEY=VALUE variables from the command line. They win over every other source.
+	Definitions []string
+	// VariablesFormat is the format of every variables file, one of VariablesFormats. When it's empty, each file's
+	// format is picked from its name.
+	VariablesFormat string
+	// LineEndings is one of LineEndings. Empty keeps the line endings the templates render with.
+	LineEndings string
+	// ByteOrderMark is one of ByteOrderMarks. Empty keeps a template's byte order mark.
+	ByteOrderMark string
+	// Output is the file or directory rendered templates are written to, instead of stdout. See emit.
+	Output string
+	// Writing says what to do about files that are already there, and whether files keep their template's mode.
+	Writing output.Options
+	// NoProvenance stops templ recording what it rendered in a ProvenanceFile beside the files it writes.
+	NoProvenance bool
+	// Hooks says whether the hooks templates declare are run once they're written. See runHooks.
+	Hooks HookOptions
+}
 
-	variables, err := convertFromArrayToKeymap(variableDefinitions)
+// RenderFromStdin renders a template with variables from the environment and the command line; variableDefinitions
+// are KEY=VALUE pairs that come after any in options.Definitions.
+func RenderFromStdin(template string, variableDefinitions []string, options RenderOptions) (hydratedtemplate string, err error) {
+	options.Definitions = append(append([]string{}, options.Definitions...), variableDefinitions...)
+	variables, err := LoadVariables(nil, options)
 
 	if err != nil {
 		return "", err
 	}
 
-	hydratedtemplate, err = renderFromString("stdin", template, variables)
+	// If we don't receive any variables, just pass back up the chain. Strict mode renders anyway, so that it can
+	// report what's missing, and so does a template with front-matter, which has to be stripped and may have defaults.
+	if len(variables.Values) == 0 && !options.Strict && !hasFrontMatter(template) {
+		return finishOutput(template, template, options), nil
+	}
+
+	hydratedtemplate, err = renderFromString("stdin", template, variables.Values, options)
 
-	return
+	if err != nil {
+		return hydratedtemplate, err
+	}
+
+	return finishOutput(template, hydratedtemplate, options), nil
 }
 
-func FindTemplateAndVariableFiles(argv []string) ([]string, map[string]string, error) {
-	// Data structures to store paths to the template files. These may optionally have an associated variables file to hydrate with.
+func FindTemplateAndVariableFiles(argv []string) ([]string, map[string][]string, error) {
+	// Data structures to store paths to the template files. These may optionally have associated variables files to hydrate with.
 	var templateFilePaths = make([]string, 0)
-	var templateVariablesFilesPaths = make(map[string]string, 0)
+	var templateVariablesFilesPaths = make(map[string][]string, 0)
 
 	for _, template := range argv {
-		// The arguments at this point either read as a name/of/template_file, or as name/of/template_file=path/to/variables.
+		// The arguments at this point either read as a name/of/template_file, or as
+		// name/of/template_file=path/to/variables[,path/to/more/variables...].
 		// In the first case, I want to store the path to the template file in an array to hand in to the renderFromFiles command.
 		// In the second case, we store the path to the template file in the same array, and also use that path as an
-		// index in a map, where the value is the variables file's path.
+		// index in a map, where the value is the variables files' paths, in the order they're merged.
 
 		// interrogate each path from args to split into either a set of strings or a path+string
 		// then use findFilesByName to find the templates associated with those strings
 		variablesFile := false
-		var templateVariablesPath string
+		var templateVariablesPaths []string
 
-		if strings.Contains(template, "=") {
+		// Split on the first '=' only; everything after it is variables files.
+		if name, variablesPaths, found := strings.Cut(template, "="); found {
 			variablesFile = true
-			templateAndVariablesPath := strings.Split(template, "=")
-			template = templateAndVariablesPath[0]
-			templateVariablesPath = templateAndVariablesPath[1]
+			template = name
+			templateVariablesPaths = strings.Split(variablesPaths, ",")
 		}
 
+		// A name like go-service@v1.4.0 is the template as it is at that tag, branch or commit.
+		name, ref := splitRef(template)
+
 		//temp variable to prevent variable shadowing.
-		t, err := findFilesByName(configelements.NewTemplDir().TemplatesDir, []string{template})
+		var t []string
+		var err error
+
+		if ref != "" {
+			t, err = findFilesAtRef(name, ref)
+		} else {
+			t, err = findFilesByName(configelements.NewTemplDir().TemplatesDir, []string{template})
+		}
 
 		if err != nil {
 			logrus.Error(err)
@@ -88,14 +130,16 @@ func FindTemplateAndVariableFiles(argv []string) ([]string, map[string]string, e
 			templateFilePaths = append(templateFilePaths, p)
 
 			if variablesFile {
-				templateVariablesFilesPaths[p] = templateVariablesPath
+				templateVariablesFilesPaths[p] = templateVariablesPaths
 			}
 		}
 	}
 	return templateFilePaths, templateVariablesFilesPaths, nil
 }
 
-func RenderFromFiles(templateFiles []string, templateVariables map[string]string) error {
+// RenderFromFiles renders each template file with its variables files, if it has any, and prints the results. Nothing
+// is printed unless every template renders.
+func RenderFromFiles(templateFiles []string, templateVariables map[string][]string, options RenderOptions) error {
 	err := validateTemplatesExist(templateFiles)
 
 	if err != nil {
@@ -105,75 +149,187 @@ func RenderFromFiles(templateFiles []string, templateVariables map[string]string
 
 	logrus.Debug("filesInArgs: ", templateFiles)
 
+	outputs := make([]string, 0, len(templateFiles))
+	values := make([]Variables, 0, len(templateFiles))
+	var renderErrs []error
+
 	for _, templatePath := range templateFiles {
-		templateVariablesFilePath := templateVariables[templatePath]
+		templateContents, err := os.ReadFile(templatePath)
+
+		if err != nil {
+			_, file, line, _ := runtime.Caller(0)
+			return fmt.Errorf("%s:%d: %v", file, line, err)
+		}
 
-		// No variables? Just print and move on.
-		if templateVariablesFilePath == "" {
-			content, err := os.ReadFile(string(templatePath))
+		logrus.Debug("Found template variables files: ", templateVariables[templatePath])
 
-			if err != nil {
-				_, file, line, _ := runtime.Caller(0)
-				return fmt.Errorf("%s:%d: %v", file, line, err)
-			}
+		// Consume the template variables, which are files, the environment and the command line, into a tree
+		// of maps, lists and scalars.
+		variables, err := LoadVariables(templateVariables[templatePath], options)
 
-			fmt.Println(string(content))
+		if err != nil {
+			_, file, line, _ := runtime.Caller(0)
+			return fmt.Errorf("%s:%d: %w", file, line, err)
+		}
 
+		// No variables? Just print and move on. Strict mode renders anyway, so that it can report what's missing, and
+		// so does a template with front-matter.
+		if len(variables.Values) == 0 && !options.Strict && !hasFrontMatter(string(templateContents)) {
+			outputs = append(outputs, finishOutput(string(templateContents), string(templateContents), options))
+			values = append(values, variables)
 			continue
 		}
 
-		logrus.Debug("Found template variables file: ", templateVariablesFilePath)
+		// Convert template file content to a string
+		templateText := string(templateContents)
+		output, err := renderFromString(templatePath, templateText, variables.Values, options)
 
-		// Consume the template variables, which are a yaml file, into a map
-		// of key value pairs.
-		templateVariables, err := getTemplateVariablesFromYamlFile(templateVariablesFilePath)
+		// Keep going when variables are missing or a template is broken, so that one error can report every template's
+		// problems.
+		if errors.Is(err, MissingVariablesErr{}) || errors.Is(err, TemplateError{}) {
+			renderErrs = append(renderErrs, err)
+			continue
+		}
 
 		if err != nil {
 			_, file, line, _ := runtime.Caller(0)
-			return fmt.Errorf("%s:%d: %v", file, line, err)
+			return fmt.Errorf("%s:%d: %w", file, line, err)
 		}
 
-		templateContents, err := os.ReadFile(templatePath)
+		outputs = append(outputs, finishOutput(templateText, output, options))
+		values = append(values, variables)
+	}
 
-		if err != nil {
-			_, file, line, _ := runtime.Caller(0)
-			return fmt.Errorf("%s:%d: %v", file, line, err)
+	if len(renderErrs) > 0 {
+		return errors.Join(renderErrs...)
+	}
+
+	return emit(templateFiles, outputs, values, options)
+}
+
+// emit prints the rendered templates, or writes them to options.Output and prints the paths it wrote. Output is a
+// file for a single template and a directory for several, where each is written under its template's name. A single
+// template goes in a directory too if Output is one already, or ends with a separator. Written templates are recorded,
+// with the variables in values, in the ProvenanceFile beside them, and then their hooks are run.
+func emit(templateFiles []string, outputs []string, values []Variables, options RenderOptions) error {
+	if options.Output == "" {
+		for _, rendered := range outputs {
+			fmt.Print(rendered)
 		}
+		return nil
+	}
 
-		// Convert template file content to a string
-		templateText := string(templateContents)
-		output, err := renderFromString(templatePath, templateText, templateVariables)
+	info, err := os.Stat(options.Output)
+	toDirectory := len(templateFiles) > 1 || (err == nil && info.IsDir()) || strings.HasSuffix(options.Output, string(filepath.Separator))
 
-		if err != nil {
-			_, file, line, _ := runtime.Caller(0)
-			return fmt.Errorf("%s:%d: %v", file, line, err)
+	var files []output.File
+	var records []provenanceRecord
+	from := make(map[string]string)
+
+	for i, templatePath := range templateFiles {
+		file := output.File{Path: options.Output, Content: []byte(outputs[i])}
+
+		if toDirectory {
+			file.Path = filepath.Join(options.Output, filepath.Base(templatePath))
 		}
 
-		fmt.Println(output)
+		if other, found := from[file.Path]; found {
+			return TemplateVariableErr{ErrorMessage: fmt.Sprintf("%s and %s would both be written to %s", other, templatePath, file.Path)}
+		}
+
+		if info, err := os.Stat(templatePath); err == nil {
+			file.Mode = info.Mode().Perm()
+		}
+
+		from[file.Path] = templatePath
+		files = append(files, file)
+		records = append(records, newProvenanceRecord(templatePath, file.Path, false, values[i], options))
 	}
 
-	return nil
+	written, err := output.Write(files, options.Writing)
+
+	for _, path := range written {
+		fmt.Println(path)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	// Templates whose files were all left as they were aren't recorded, and their hooks don't run again.
+	records = writtenRecords(records, written)
+	err = recordProvenance(records, options)
+
+	if err != nil {
+		return err
+	}
+
+	return runHooks(records, options)
 }
 
-// RetrieveVariables accepts the content of a template and returns an array of.
-// strings that match {{ FOO }} format, but not with formats like ${{ FOO }}
-func RetrieveVariables(templateContent string) []string {
-	// Regular expression for strict matches: only `{{ .Identifier }}`
-	strictRe := regexp.MustCompile(`{{\s*\.([^}\s]+)\s*}}`)
-	strictMatches := strictRe.FindAllStringSubmatch(templateContent, -1)
+// ShowVariables prints the variables a template would be rendered with, merged from its variables files, the
+// environment, the command line and the defaults in its front-matter, along with where each value came from.
+func ShowVariables(templatePath string, templateText string, variablesFiles []string, options RenderOptions, out io.Writer) error {
+	variables, err := LoadVariables(variablesFiles, options)
+
+	if err != nil {
+		_, file, line, _ := runtime.Caller(0)
+		return fmt.Errorf("%s:%d: %w", file, line, err)
+	}
+
+	metadata, _, _, err := parseFrontMatter(templateText)
 
-	matches := []string{}
+	if err != nil {
+		_, file, line, _ := runtime.Caller(0)
+		return fmt.Errorf("%s:%d: %w", file, line, err)
+	}
 
-	for _, section := range strictMatches {
-		if !slices.Contains(matches, section[1]) {
-			matches = append(matches, section[1])
+	if metadata != nil {
+		for _, variable := range metadata.Variables {
+			path := strings.Split(variable.Name, ".")
+
+			if _, found := lookupVariable(variables.Values, path); !found && variable.Default != nil {
+				values := make(map[string]interface{})
+				setVariable(values, path, variable.Default)
+				variables.Merge(values, "default in "+templatePath)
+			}
 		}
 	}
 
-	return matches
+	fmt.Fprintf(out, "%s:\n", templatePath)
+	variables.Print(out)
+
+	return nil
+}
+
+// RetrieveVariables accepts the content of a template and returns its variables, with every place the template
+// references each of them. Variables declared in the template's front-matter come first, in the order they're declared
+// and with everything the front-matter says about them. After that come the variables the template references, in the
+// order they first appear. Actions templ leaves alone, like ${{ FOO }}, don't count. See source.variables for how
+// variables are named.
+func RetrieveVariables(templateContent string) ([]Variable, error) {
+	return RetrieveTemplateVariables("stdin", templateContent)
+}
+
+// RetrieveTemplateVariables is RetrieveVariables for a template file, whose includes are found from where the file is.
+// The variables of every template it includes are part of its variables.
+func RetrieveTemplateVariables(templatePath string, templateContent string) ([]Variable, error) {
+	src, err := prepareTemplate(templatePath, templateContent)
+
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl, err := src.parse()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return src.variables(tmpl), nil
 }
 
-// renderFromString takes a string containing a template, and a map of FOO=bar definitions and returns
+// renderFromString takes a string containing a template, and a tree of variable definitions and returns
 // a rendered template.
 // Problematic workflow:
 // Templates are arbitrary files, and a lot of template formats use some variation on the {{}} delimiters to identify
@@ -190,75 +346,63 @@ func RetrieveVariables(templateContent string) []string {
 // In this file, I want templ to edit {{ .FILENAME }}, but the section ${{ env.PATTERN }} will cause an error because templ
 // isn't handing in an object called env with a PATTERN member.
 // Solution:
-// To work around this, templ splits all incoming files on the string '{{'. It then either successfully substitutes
-// a variable or it ignores any error rendering that subsection. Tada!
-func renderFromString(templatePath string, templateText string, templateVariableDefinitions map[string]string) (string, error) {
+// templ scans the file for actions first (see tokenize), and only hands the actions it owns to the template engine.
+// Everything else, like ${{ env.PATTERN }}, is text as far as the engine is concerned. The engine gets the whole file
+// as one program, so {{ if }} and {{ range }} blocks work across lines. Tada!
+func renderFromString(templatePath string, templateText string, templateVariableDefinitions map[string]interface{}, options RenderOptions) (string, error) {
+	src, err := prepareTemplate(templatePath, templateText)
+
+	if err != nil {
+		return "", err
+	}
 
-	templateSections := strings.SplitAfter(templateText, "}}")
-	var reformedTemplate bytes.Buffer
+	tmpl, err := src.parse()
 
-	templateName := path.Base(templatePath)
+	if err != nil {
+		return "", err
+	}
 
-	for i, section := range templateSections {
-		name := templateName + strconv.Itoa(i)
+	// Applied after parsing, which adds the variables declared by the templates this one extends.
+	templateVariableDefinitions, err = src.metadata.apply(templatePath, templateVariableDefinitions)
 
-		tmpl, err := template.New(name).Parse(section)
+	if err != nil {
+		return "", err
+	}
 
-		if err != nil {
-			// If the error reads like this:
-			// `variable env.FOO not defined`
-			// then we're in the error condition identified in the header comment. Ignore the error, write the contents
-			// of the template section to our template buffer and go on to the next loop.
-			if strings.Contains(err.Error(), "not defined") {
-				reformedTemplate.WriteString(section)
-				continue
-			} else if strings.Contains(err.Error(), "bad character") {
-				_, file, line, _ := runtime.Caller(0)
-				return "", fmt.Errorf("\nThe 'bad character' error from the go template engine normally means that you have a disallowed "+
-					"character inside your template variable name. \nHere's what templ was working on:\n"+
-					"%s:%d: Failed on section:\n--- %s\n---\n Error is: %v", file, line, section, err)
-			} else {
-				_, file, line, _ := runtime.Caller(0)
-				return "", fmt.Errorf("%s:%d: Failed on section:\n--- %s\n---\n Error is: %v", file, line, section, err)
+	if options.Strict {
+		missing := findMissingVariables(src.variables(tmpl), templateVariableDefinitions)
 
-			}
+		if len(missing) > 0 {
+			return "", MissingVariablesErr{Template: templatePath, Missing: missing}
 		}
 
-		// Execute the template with the data
-		var buffer bytes.Buffer
-		err = tmpl.Execute(&buffer, templateVariableDefinitions)
-
-		if err != nil {
-			_, file, line, _ := runtime.Caller(0)
-			return buffer.String(), fmt.Errorf("%s:%d: %v", file, line, err)
+		if len(src.set.unresolved) > 0 {
+			return "", src.set.unresolved[0]
 		}
 
-		reformedTemplate.Write(buffer.Bytes())
-	}
+		// An action left as it is because of a function templ doesn't know is usually a typo, like {{ uper .name }}.
+		if unknowns := src.set.unknownFunctionErrs(); len(unknowns) > 0 {
+			return "", errors.Join(unknowns...)
+		}
 
-	return reformedTemplate.String(), nil
-}
+		// Catch the variables that are only missing at execution time, like fields of list items, except the ones left
+		// to guardFunctions.
+		guardVariables(tmpl)
 
-func getTemplateVariablesFromYamlFile(templateVariablesFilePath string) (map[string]string, error) {
-	// Read the YAML file
-	yamlFile, err := os.ReadFile(templateVariablesFilePath)
-	if err != nil {
-		logrus.Error("Failed to read YAML file at path <", templateVariablesFilePath, "> Err is: ", err)
-		return nil, err
+		for _, t := range tmpl.Templates() {
+			t.Option("missingkey=error")
+		}
 	}
 
-	// Create a map to store the parsed YAML data
-	data := make(map[string]string)
-
-	// Unmarshal the YAML data into the map
-	err = yaml.Unmarshal(yamlFile, &data)
+	// Execute the template with the data
+	var buffer bytes.Buffer
+	err = tmpl.Execute(&buffer, templateVariableDefinitions)
 
 	if err != nil {
-		logrus.Error("Failed to unmarshal YAML: ", err)
-		return nil, err
+		return buffer.String(), src.templateError(tmpl, err)
 	}
 
-	return data, err
+	return buffer.String(), nil
 }
 
 func validateTemplatesExist(templateFiles []string) error {
@@ -303,6 +447,11 @@ func findFilesByName(root string, names []string) ([]string, error) {
 
 		logrus.Debug("Inside filepath.Walk function names: ", names)
 
+		// Helper files are only there to be loaded along with the templates of their repository.
+		if templatedirectories.IsHelperFile(path) {
+			return nil
+		}
+
 		// If the file's name is in the set of names
 		for _, name := range names {
 			logrus.Debug("name is <", name, "> path is <", path, ">")
@@ -320,22 +469,3 @@ func findFilesByName(root string, names []string) ([]string, error) {
 
 	return foundFiles, nil
 }
-
-func convertFromArrayToKeymap(input []string) (map[string]string, error) {
-	k := make(map[string]string)
-
-	for _, arg := range input {
-		if !strings.Contains(arg, "=") {
-			_, file, line, _ := runtime.Caller(0)
-
-			message := fmt.Sprintf("%s:%d: Argument <%s> not formatted as FOO=BAR", file, line, arg)
-			err := TemplateVariableErr{ErrorMessage: message}
-			return k, err
-		}
-
-		s := strings.Split(arg, "=")
-		k[s[0]] = s[1]
-	}
-
-	return k, nil
-}
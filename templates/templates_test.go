@@ -2,8 +2,13 @@ package templates_test
 
 import (
 	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
 	"slices"
+	"templ/output"
 	"templ/templates"
+	"templ/test_helpers"
 	"testing"
 )
 
@@ -19,7 +24,7 @@ func TestTemplateVariableErr(t *testing.T) {
 }
 
 func TestRenderFromStdinWithEmptyString(t *testing.T) {
-	hydratedTemplate, err := templates.RenderFromStdin("", []string{})
+	hydratedTemplate, err := templates.RenderFromStdin("", []string{}, templates.RenderOptions{})
 
 	if err != nil {
 		t.Errorf("%v", err)
@@ -34,7 +39,7 @@ func TestRenderFromStdingWithPlainString(t *testing.T) {
 	template := `
 I love humans
 `
-	hydratedTemplate, err := templates.RenderFromStdin(template, []string{})
+	hydratedTemplate, err := templates.RenderFromStdin(template, []string{}, templates.RenderOptions{})
 
 	if err != nil {
 		t.Errorf("%v", err)
@@ -49,7 +54,7 @@ func TestRenderFromStdinWithTemplateContainingAVariableButNoVariables(t *testing
 	template := `I love {{ .SPECIES }}`
 
 	templateVariables := []string{}
-	hydratedTemplate, err := templates.RenderFromStdin(template, templateVariables)
+	hydratedTemplate, err := templates.RenderFromStdin(template, templateVariables, templates.RenderOptions{})
 
 	if err != nil {
 		t.Errorf("%v", err)
@@ -64,7 +69,7 @@ func TestRenderFromStdinWithVariablesAndDefinitions(t *testing.T) {
 	template := `I love {{ .SPECIES }}`
 
 	templateVariables := []string{"SPECIES=HUMAN"}
-	hydratedTemplate, err := templates.RenderFromStdin(template, templateVariables)
+	hydratedTemplate, err := templates.RenderFromStdin(template, templateVariables, templates.RenderOptions{})
 
 	if err != nil {
 		t.Errorf("%v", err)
@@ -80,7 +85,7 @@ func TestRenderFromStdinWithVariablesAndInvalidDefinitions(t *testing.T) {
 	template := `I love {{ .SPECIES }}`
 
 	templateVariables := []string{"SPECIES HUMAN"}
-	_, err := templates.RenderFromStdin(template, templateVariables)
+	_, err := templates.RenderFromStdin(template, templateVariables, templates.RenderOptions{})
 
 	if !errors.Is(err, templates.TemplateVariableErr{}) {
 		t.Errorf("Using an invalid variables string should raise a templatevariableerror, actually got %v", err)
@@ -100,7 +105,7 @@ jobs:
           ref: ${{ env.GITHUB_REF }}
 `
 	templateVariables := []string{"BINARY_NAME=ROFLCOPTER"}
-	hydratedTemplate, err := templates.RenderFromStdin(template, templateVariables)
+	hydratedTemplate, err := templates.RenderFromStdin(template, templateVariables, templates.RenderOptions{})
 	if err != nil {
 		t.Errorf("%v", err)
 	}
@@ -132,7 +137,12 @@ jobs:
         with:
           ref: ${{ env.GITHUB_REF }}
 `
-	templateVariables := templates.RetrieveVariables(template)
+	templateVariables, err := variableNames(template)
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
 	expected := []string{"BINARY_NAME"}
 
 	if !slices.Equal(templateVariables, expected) {
@@ -144,10 +154,177 @@ func TestCanParseMultipleTemplateVariablesFromASingleLine(t *testing.T) {
 	template := `
 {{ .ONE }} {{ .TWO }}
 `
-	templateVariables := templates.RetrieveVariables(template)
+	templateVariables, err := variableNames(template)
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
 	expected := []string{"ONE", "TWO"}
 
 	if !slices.Equal(templateVariables, expected) {
 		t.Errorf("Expected <%s>, received <%s>", expected, templateVariables)
 	}
 }
+
+func TestLoadVariablesFileKeepsStructure(t *testing.T) {
+	dir := t.TempDir()
+	variablesFile := filepath.Join(dir, "variables.yaml")
+
+	err := os.WriteFile(variablesFile, []byte(`---
+name: shop
+replicas: 3
+debug: false
+services:
+  - api
+  - worker
+database:
+  host: db.internal
+  port: 5432
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	variables, err := templates.LoadVariablesFile(variablesFile, "")
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	expected := map[string]interface{}{
+		"name":     "shop",
+		"replicas": 3,
+		"debug":    false,
+		"services": []interface{}{"api", "worker"},
+		"database": map[string]interface{}{"host": "db.internal", "port": 5432},
+	}
+
+	if !reflect.DeepEqual(variables, expected) {
+		t.Errorf("Expected <%v>, received <%v>", expected, variables)
+	}
+}
+
+func TestRenderFromFilesWithStructuredVariables(t *testing.T) {
+	dir := t.TempDir()
+	templateFile := filepath.Join(dir, "deployment.yaml")
+	variablesFile := filepath.Join(dir, "variables.yaml")
+
+	err := test_helpers.WriteFiles(dir, map[string]string{
+		"deployment.yaml": `db: {{ .database.host }}:{{ .database.port }}
+{{- range .services }}
+- {{ . }}
+{{- end }}`,
+		"variables.yaml": `database: {host: db.internal, port: 5432}
+services: [api, worker]
+`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var renderErr error
+	output, err := test_helpers.CaptureStdout(func() {
+		renderErr = templates.RenderFromFiles([]string{templateFile}, map[string][]string{templateFile: {variablesFile}}, templates.RenderOptions{})
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if renderErr != nil {
+		t.Fatalf("%v", renderErr)
+	}
+
+	expected := "db: db.internal:5432\n- api\n- worker"
+	if output != expected {
+		t.Errorf("Expected <%s>, received <%s>", expected, output)
+	}
+}
+
+// variableNames retrieves the variables of a template and returns just their names.
+func variableNames(template string) ([]string, error) {
+	variables, err := templates.RetrieveVariables(template)
+
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, variable := range variables {
+		names = append(names, variable.Name)
+	}
+
+	return names, nil
+}
+
+func TestRenderFromFilesToOutput(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "deploy.sh")
+	config := filepath.Join(dir, "config.yaml")
+
+	err := test_helpers.WriteFiles(dir, map[string]string{
+		"deploy.sh":   "#!/bin/sh\nkubectl apply -n {{ .namespace }}",
+		"config.yaml": "namespace: {{ .namespace }}\n",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.Chmod(script, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	options := templates.RenderOptions{Definitions: []string{"namespace=shop"}, Writing: output.Options{KeepMode: true}}
+
+	// One template goes to a file, several to a directory.
+	options.Output = filepath.Join(dir, "out", "deploy")
+	var renderErr error
+	_, err = test_helpers.CaptureStdout(func() {
+		renderErr = templates.RenderFromFiles([]string{script}, nil, options)
+	})
+	if err != nil || renderErr != nil {
+		t.Fatalf("%v, %v", err, renderErr)
+	}
+
+	options.Output = filepath.Join(dir, "all")
+	_, err = test_helpers.CaptureStdout(func() {
+		renderErr = templates.RenderFromFiles([]string{script, config}, nil, options)
+	})
+	if err != nil || renderErr != nil {
+		t.Fatalf("%v, %v", err, renderErr)
+	}
+
+	expected := map[string]string{
+		"out/deploy":      "#!/bin/sh\nkubectl apply -n shop",
+		"all/deploy.sh":   "#!/bin/sh\nkubectl apply -n shop",
+		"all/config.yaml": "namespace: shop\n",
+	}
+
+	for path, want := range expected {
+		content, err := os.ReadFile(filepath.Join(dir, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(content) != want {
+			t.Errorf("%s: expected <%s>, received <%s>", path, want, content)
+		}
+	}
+
+	if info, err := os.Stat(filepath.Join(dir, "out", "deploy")); err != nil || info.Mode().Perm() != 0755 {
+		t.Errorf("Expected the rendered script to be executable, got %v, %v", info.Mode(), err)
+	}
+
+	_, err = test_helpers.CaptureStdout(func() {
+		renderErr = templates.RenderFromFiles([]string{config}, nil, options)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !errors.Is(renderErr, output.ExistsErr{}) {
+		t.Errorf("Expected rendering over a file to fail by default, got %v", renderErr)
+	}
+}
@@ -0,0 +1,508 @@
+package templates
+
+import (
+	"fmt"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Delimiters handed to the go template engine once the scanner has decided which {{ }} actions belong to templ.
+// They are the same width as "{{" and "}}", so line and column numbers reported by the engine still point at the
+// original template text. Every action templ does not own keeps its braces and is therefore plain text to the engine.
+const (
+	leftDelim  = "\x02\x02"
+	rightDelim = "\x03\x03"
+)
+
+// builtinFunctions are the functions text/template always provides.
+var builtinFunctions = []string{
+	"and", "call", "html", "index", "slice", "js", "len", "not", "or", "print", "printf", "println", "urlquery",
+	"eq", "ne", "lt", "le", "gt", "ge",
+}
+
+var templateKeywords = []string{
+	"if", "else", "end", "range", "with", "define", "template", "block", "break", "continue", "nil", "true", "false",
+}
+
+// helmRoots are the top level objects Helm hands to its charts. Actions that reach into them are Helm's to render.
+var helmRoots = []string{"Values", "Release", "Chart", "Capabilities", "Files", "Subcharts"}
+
+// delimiterRestorer turns the private delimiters back into braces in messages that come out of the template engine.
+var delimiterRestorer = strings.NewReplacer(
+	leftDelim, "{{", rightDelim, "}}",
+	fmt.Sprintf("%q", leftDelim)[1:9], "{{", fmt.Sprintf("%q", rightDelim)[1:9], "}}",
+)
+
+// tokenize scans a template for {{ }} actions and decides which of them are templ's. Templ's actions have their
+// delimiters rewritten to leftDelim and rightDelim; everything else is left untouched, so the engine treats it as text.
+// An action is left alone when:
+// - it is prefixed with '$', like GitHub Actions' ${{ env.FOO }};
+// - it uses syntax the go template language doesn't have, like Jinja's {{ name | default('x') }};
+// - it calls a function templ does not know, like {{ env.FOO }};
+// - it reaches into one of Helm's top level objects, like {{ .Values.image }};
+// - it is the else or end of a block that was itself left alone;
+// - it is a define or block whose actions, other than comments and ${{ }}, were all left alone, like Helm's
+// {{ define "mychart.fullname" }}{{ .Release.Name }}{{ end }}, or is inside one;
+// - it is a template or include of a define that was left alone;
+// - it is a comment in a template whose other actions were all left alone.
+// The result is a complete program, so {{ if }} and {{ range }} blocks can span as many lines as they like. The actions
+// that were left alone only because of a function templ doesn't know, while they use the template's data, are returned
+// too, since they're probably typos.
+func tokenize(templateText string) (string, []unknownFunction, error) {
+	if strings.Contains(templateText, leftDelim) || strings.Contains(templateText, rightDelim) {
+		_, file, line, _ := runtime.Caller(0)
+		return "", nil, fmt.Errorf("%s:%d: template contains the control characters templ reserves for its delimiters", file, line)
+	}
+
+	actions := scanActions(templateText)
+	leaveForeignDefinesAlone(actions)
+
+	var program strings.Builder
+	var unknowns []unknownFunction
+	pos := 0
+
+	for _, a := range actions {
+		program.WriteString(templateText[pos:a.start])
+		action := templateText[a.start:a.end]
+
+		if a.ours {
+			program.WriteString(leftDelim)
+			program.WriteString(action[2 : len(action)-2])
+			program.WriteString(rightDelim)
+		} else {
+			if a.unknown != "" {
+				unknowns = append(unknowns, unknownFunction{offset: program.Len(), name: a.unknown})
+			}
+			program.WriteString(action)
+		}
+
+		pos = a.end
+	}
+
+	program.WriteString(templateText[pos:])
+
+	return program.String(), unknowns, nil
+}
+
+// scannedAction is a {{ }} action of a template, from start up to end, and what tokenize has decided about it.
+type scannedAction struct {
+	start int
+	end   int
+	ours  bool
+	// unknown is the function the action would have been templ's but for, as for scanAction.
+	unknown string
+	keyword string
+	// name is the template a define, block, template or include action names.
+	name string
+	// comment and dollar actions, like {{/* notes */}} and ${{ github.ref }}, don't say whose a define is.
+	comment bool
+	dollar  bool
+	// opener is the action that opens the block an else or end action belongs to, and closer is the end action that
+	// closes the block an if, range, with, define or block action opens. Both are -1 when there isn't one.
+	opener int
+	closer int
+}
+
+// scanActions finds the actions of a template, in order, and decides which of them are templ's on their own merits,
+// keeping track of the blocks that are open so that else and end actions follow the block they close.
+func scanActions(templateText string) []scannedAction {
+	var actions []scannedAction
+	// The actions that open the blocks that are open.
+	var blocks []int
+	pos := 0
+
+	for {
+		i := strings.Index(templateText[pos:], "{{")
+
+		if i < 0 {
+			return actions
+		}
+
+		start := pos + i
+		end := actionEnd(templateText, start)
+
+		// No closing braces; this is just text that happens to contain "{{".
+		if end < 0 {
+			pos = start + 2
+			continue
+		}
+
+		pos = end
+		a := scannedAction{start: start, end: end, opener: -1, closer: -1}
+
+		if start > 0 && templateText[start-1] == '$' {
+			a.dollar = true
+			actions = append(actions, a)
+			continue
+		}
+
+		content := templateText[start+2 : end-2]
+		words, ours, unknown := scanAction(content)
+		a.ours, a.unknown = ours, unknown
+		a.comment = ours && len(words) == 0 && strings.HasPrefix(strings.TrimSpace(strings.TrimPrefix(content, "-")), "/*")
+
+		if len(words) > 0 {
+			a.keyword = words[0]
+		}
+
+		switch a.keyword {
+		case "if", "range", "with", "define", "block":
+			blocks = append(blocks, len(actions))
+		case "else", "end":
+			a.ours, a.unknown = false, ""
+
+			if len(blocks) > 0 {
+				a.opener = blocks[len(blocks)-1]
+				a.ours = actions[a.opener].ours
+			}
+
+			if a.keyword == "end" && len(blocks) > 0 {
+				actions[a.opener].closer = len(actions)
+				blocks = blocks[:len(blocks)-1]
+			}
+		}
+
+		switch a.keyword {
+		case "define", "block", "template", "include":
+			a.name = actionName(content, a.keyword)
+		}
+
+		actions = append(actions, a)
+	}
+}
+
+// leaveForeignDefinesAlone leaves alone, along with everything in them, the defines and blocks whose actions, other
+// than comments and ${{ }} expressions, were all left alone, since they're somebody else's, like the defines of a Helm
+// chart's _helpers.tpl. Then it leaves alone the template and include actions that call them, which can leave more
+// defines to somebody else.
+func leaveForeignDefinesAlone(actions []scannedAction) {
+	foreign := make(map[string]bool)
+
+	for changed := true; changed; {
+		changed = false
+
+		for i, a := range actions {
+			if !a.ours {
+				continue
+			}
+
+			switch a.keyword {
+			case "define", "block":
+				if a.closer < 0 || !allForeign(actions[i+1:a.closer]) {
+					continue
+				}
+
+				for j := i; j <= a.closer; j++ {
+					actions[j].ours = false
+				}
+
+				foreign[a.name] = true
+				changed = true
+			case "template", "include":
+				if a.name != "" && foreign[a.name] {
+					actions[i].ours = false
+					changed = true
+				}
+			}
+		}
+	}
+
+	// The same goes for the whole template: one whose actions are all somebody else's keeps its comments too.
+	if allForeign(actions) {
+		for i := range actions {
+			actions[i].ours = false
+		}
+	}
+}
+
+// allForeign reports whether there's at least one action in actions that says whose a define is, and none of them are
+// templ's.
+func allForeign(actions []scannedAction) bool {
+	found := false
+
+	for _, a := range actions {
+		if a.comment || a.dollar {
+			continue
+		}
+
+		if a.ours {
+			return false
+		}
+
+		found = true
+	}
+
+	return found
+}
+
+// actionName returns the quoted template name that follows the keyword of an action's content, like mychart.fullname
+// in {{ template "mychart.fullname" . }}, or "" if there isn't one.
+func actionName(content string, keyword string) string {
+	s := strings.TrimSpace(strings.TrimPrefix(content, "-"))
+	s = strings.TrimLeft(strings.TrimPrefix(s, keyword), " \t\r\n")
+
+	if s == "" || (s[0] != '"' && s[0] != '`') {
+		return ""
+	}
+
+	closing := quoteEnd(s, 0)
+
+	if closing < 0 {
+		return ""
+	}
+
+	name, err := strconv.Unquote(s[:closing+1])
+
+	if err != nil {
+		return ""
+	}
+
+	return name
+}
+
+// unknownFunction is an action tokenize left alone only because it calls a function templ doesn't know, like
+// {{ uper .name }}. offset is where the action starts in the program, which is where it starts in the template too.
+type unknownFunction struct {
+	offset int
+	name   string
+}
+
+// actionEnd returns the index just past the "}}" closing the action that opens at start, or -1 if the action is never
+// closed. Braces inside quoted strings and comments do not close an action.
+func actionEnd(text string, start int) int {
+	i := start + 2
+	body := strings.TrimLeft(strings.TrimPrefix(text[i:], "-"), " \t\r\n")
+
+	if strings.HasPrefix(body, "/*") {
+		commentStart := len(text) - len(body)
+		commentEnd := strings.Index(text[commentStart+2:], "*/")
+
+		if commentEnd < 0 {
+			return -1
+		}
+
+		i = commentStart + 2 + commentEnd + 2
+	}
+
+	for i < len(text) {
+		switch c := text[i]; c {
+		case '"', '`', '\'':
+			closing := quoteEnd(text, i)
+
+			// An unbalanced quote in somebody else's syntax; fall back to the first closing braces.
+			if closing < 0 {
+				closeAt := strings.Index(text[i:], "}}")
+				if closeAt < 0 {
+					return -1
+				}
+				return i + closeAt + 2
+			}
+
+			i = closing + 1
+		case '{':
+			if strings.HasPrefix(text[i:], "{{") {
+				return -1
+			}
+			i++
+		case '}':
+			if strings.HasPrefix(text[i:], "}}") {
+				return i + 2
+			}
+			i++
+		default:
+			i++
+		}
+	}
+
+	return -1
+}
+
+// quoteEnd returns the index of the quote closing the string that opens at start, or -1. Interpreted strings and
+// character constants stop at the end of the line, raw strings don't.
+func quoteEnd(text string, start int) int {
+	quote := text[start]
+
+	for i := start + 1; i < len(text); i++ {
+		switch {
+		case text[i] == quote:
+			return i
+		case quote != '`' && text[i] == '\\':
+			i++
+		case quote != '`' && text[i] == '\n':
+			return -1
+		}
+	}
+
+	return -1
+}
+
+// scanAction runs a light lexer over the content of an action. It returns the bare identifiers it found, in order, and
+// whether everything in the action is go template syntax that templ knows how to evaluate. When the only thing in an
+// action that isn't is a function templ doesn't know, and the action uses the template's data, like {{ uper .name }},
+// unknown is the function.
+func scanAction(content string) (words []string, ours bool, unknown string) {
+	s := content
+
+	if strings.HasPrefix(s, "-") && len(s) > 1 && isSpace(s[1]) {
+		s = s[1:]
+	}
+
+	if strings.HasSuffix(s, "-") && len(s) > 1 && isSpace(s[len(s)-2]) {
+		s = s[:len(s)-1]
+	}
+
+	s = strings.TrimSpace(s)
+
+	if s == "" {
+		return nil, false, ""
+	}
+
+	if strings.HasPrefix(s, "/*") && strings.HasSuffix(s, "*/") {
+		return nil, true, ""
+	}
+
+	// Whether the previous token can be followed by a field chain, as in $x.Field or (index . 1).Field.
+	chainable := false
+	// Whether a field of the data is used, rather than a field of something else, as in env.FOO.
+	usesData := false
+	// Where the last identifier that isn't a function ends, so that a field chained to it can be told apart.
+	unknownEnd := -1
+
+	for i := 0; i < len(s); {
+		c := s[i]
+
+		switch {
+		case isSpace(c):
+			chainable = false
+			i++
+		case c == '"' || c == '`':
+			closing := quoteEnd(s, i)
+			if closing < 0 {
+				return words, false, ""
+			}
+			chainable = false
+			i = closing + 1
+		case c == '\'':
+			closing := quoteEnd(s, i)
+			if closing < 0 || !isCharConstant(s[i+1:closing]) {
+				return words, false, ""
+			}
+			chainable = false
+			i = closing + 1
+		case c == '.':
+			name, next := scanIdentifier(s, i+1)
+			chained := chainable || i == unknownEnd
+
+			if !chained && slices.Contains(helmRoots, name) {
+				return words, false, ""
+			}
+
+			usesData = usesData || !chained
+			chainable = name != ""
+			i = next
+		case c == '$':
+			// A bare $ is the root of the data, so whatever follows it is a top level field like any other.
+			name, next := scanIdentifier(s, i+1)
+			chainable = name != ""
+			i = next
+		case isDigit(c) || ((c == '-' || c == '+') && i+1 < len(s) && isDigit(s[i+1])):
+			i++
+			for i < len(s) && (isIdentifierByte(s[i]) || s[i] == '.' || isExponentSign(s, i)) {
+				i++
+			}
+			chainable = false
+		case isIdentifierStart(s, i):
+			word, next := scanIdentifier(s, i)
+
+			// The rest of the action is scanned all the same, to tell a typo from somebody else's syntax.
+			if !slices.Contains(templateKeywords, word) && !isTemplFunction(word) {
+				unknownEnd = next
+
+				if unknown == "" {
+					unknown = word
+				}
+			} else if unknown == "" {
+				words = append(words, word)
+			}
+
+			chainable = false
+			i = next
+		case c == '(':
+			chainable = false
+			i++
+		case c == ')':
+			chainable = true
+			i++
+		case c == '|' || c == ',':
+			chainable = false
+			i++
+		case c == ':' && strings.HasPrefix(s[i:], ":="):
+			chainable = false
+			i += 2
+		case c == '=' && !strings.HasPrefix(s[i:], "=="):
+			chainable = false
+			i++
+		default:
+			return words, false, ""
+		}
+	}
+
+	if unknown != "" && !usesData {
+		return words, false, ""
+	}
+
+	return words, unknown == "", unknown
+}
+
+// isTemplFunction reports whether name is a function templates can call.
+func isTemplFunction(name string) bool {
+	_, found := templateFunctions[name]
+	return found || slices.Contains(builtinFunctions, name)
+}
+
+func scanIdentifier(s string, start int) (string, int) {
+	i := start
+	for i < len(s) {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			break
+		}
+		i += size
+	}
+	return s[start:i], i
+}
+
+func isIdentifierStart(s string, i int) bool {
+	r, _ := utf8.DecodeRuneInString(s[i:])
+	return r == '_' || unicode.IsLetter(r)
+}
+
+func isIdentifierByte(c byte) bool {
+	return c == '_' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || isDigit(c)
+}
+
+// isExponentSign reports whether the byte at i is the sign of an exponent, as in 1e-3 or 0x1p+2.
+func isExponentSign(s string, i int) bool {
+	return (s[i] == '-' || s[i] == '+') && strings.ContainsRune("eEpP", rune(s[i-1]))
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
+
+// isCharConstant reports whether the text between single quotes is a go character constant rather than, say, a Jinja
+// or GitHub Actions string.
+func isCharConstant(quoted string) bool {
+	if strings.HasPrefix(quoted, "\\") {
+		return len(quoted) > 1
+	}
+	return utf8.RuneCountInString(quoted) == 1
+}
@@ -0,0 +1,205 @@
+package templates_test
+
+import (
+	"errors"
+	"strings"
+	"templ/templates"
+	"testing"
+)
+
+func TestRenderBlockAcrossLines(t *testing.T) {
+	template := `services:
+{{- with .SERVICE }}
+  - name: {{ . }}
+    image: {{ printf "%s:latest" . }}
+{{- end }}
+`
+	hydratedTemplate, err := templates.RenderFromStdin(template, []string{"SERVICE=api"}, templates.RenderOptions{})
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	expected := `services:
+  - name: api
+    image: api:latest
+`
+	if hydratedTemplate != expected {
+		t.Errorf("Expected <%s>, received <%s>", expected, hydratedTemplate)
+	}
+}
+
+func TestRenderConditionalContainingForeignBraces(t *testing.T) {
+	template := `{{ if eq .ENVIRONMENT "prod" }}
+ref: ${{ github.ref }}
+{{ else }}
+ref: main
+{{ end }}`
+
+	hydratedTemplate, err := templates.RenderFromStdin(template, []string{"ENVIRONMENT=prod"}, templates.RenderOptions{})
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	expected := `
+ref: ${{ github.ref }}
+`
+	if hydratedTemplate != expected {
+		t.Errorf("Expected <%s>, received <%s>", expected, hydratedTemplate)
+	}
+}
+
+func TestRenderLeavesJinjaAlone(t *testing.T) {
+	template := `Hello {{ name | default('world') }} and {{ user }}, from {{ .SENDER }}{% if x %}!{% endif %}`
+
+	hydratedTemplate, err := templates.RenderFromStdin(template, []string{"SENDER=templ"}, templates.RenderOptions{})
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	expected := `Hello {{ name | default('world') }} and {{ user }}, from templ{% if x %}!{% endif %}`
+	if hydratedTemplate != expected {
+		t.Errorf("Expected <%s>, received <%s>", expected, hydratedTemplate)
+	}
+}
+
+func TestRenderLeavesHelmBlocksAlone(t *testing.T) {
+	template := `name: {{ .NAME }}
+{{- if .Values.ingress.enabled }}
+host: {{ .Values.ingress.host | quote }}
+{{- end }}
+`
+	hydratedTemplate, err := templates.RenderFromStdin(template, []string{"NAME=chart"}, templates.RenderOptions{})
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	expected := `name: chart
+{{- if .Values.ingress.enabled }}
+host: {{ .Values.ingress.host | quote }}
+{{- end }}
+`
+	if hydratedTemplate != expected {
+		t.Errorf("Expected <%s>, received <%s>", expected, hydratedTemplate)
+	}
+}
+
+func TestRenderBracesInsideStrings(t *testing.T) {
+	template := `{{ printf "%s}}" .WORD }} {{ "{{" }}`
+
+	hydratedTemplate, err := templates.RenderFromStdin(template, []string{"WORD=braces"}, templates.RenderOptions{})
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	expected := `braces}} {{`
+	if hydratedTemplate != expected {
+		t.Errorf("Expected <%s>, received <%s>", expected, hydratedTemplate)
+	}
+}
+
+func TestRenderUnclosedBracesAreText(t *testing.T) {
+	template := `{{ .A }} and {{ never closed`
+
+	hydratedTemplate, err := templates.RenderFromStdin(template, []string{"A=a"}, templates.RenderOptions{})
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	expected := `a and {{ never closed`
+	if hydratedTemplate != expected {
+		t.Errorf("Expected <%s>, received <%s>", expected, hydratedTemplate)
+	}
+}
+
+// helmHelpers is the _helpers.tpl helm create makes, trimmed down.
+const helmHelpers = `{{/*
+Expand the name of the chart.
+*/}}
+{{- define "mychart.name" -}}
+{{- default .Chart.Name .Values.nameOverride | trunc 63 | trimSuffix "-" }}
+{{- end }}
+
+{{/*
+Create a default fully qualified app name.
+*/}}
+{{- define "mychart.fullname" -}}
+{{- if .Values.fullnameOverride }}
+{{- .Values.fullnameOverride | trunc 63 | trimSuffix "-" }}
+{{- else }}
+{{- $name := default .Chart.Name .Values.nameOverride }}
+{{- if contains $name .Release.Name }}
+{{- .Release.Name | trunc 63 | trimSuffix "-" }}
+{{- else }}
+{{- printf "%s-%s" .Release.Name $name | trunc 63 | trimSuffix "-" }}
+{{- end }}
+{{- end }}
+{{- end }}
+
+{{/*
+Common labels
+*/}}
+{{- define "mychart.labels" -}}
+app.kubernetes.io/name: {{ include "mychart.name" . }}
+app.kubernetes.io/instance: {{ .Release.Name }}
+{{- end }}
+`
+
+func TestRenderLeavesHelmDefinesAlone(t *testing.T) {
+	t.Setenv("TEMPL_DIR", t.TempDir())
+
+	hydratedTemplate, err := templates.RenderFromStdin(helmHelpers, []string{"NAME=chart"}, templates.RenderOptions{})
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if hydratedTemplate != helmHelpers {
+		t.Errorf("Expected <%s>, received <%s>", helmHelpers, hydratedTemplate)
+	}
+
+	// A chart's template calls the defines of its _helpers.tpl, which templ doesn't have, along with templ's variables.
+	template := `{{- define "mychart.short" -}}{{ .Release.Name }}-{{ .Chart.Name }}{{- end }}
+metadata:
+  name: {{ template "mychart.fullname" . }}
+  short: {{ template "mychart.short" . }}
+  owner: {{ .OWNER }}
+  labels:
+    {{- include "mychart.labels" . | nindent 4 }}
+`
+
+	hydratedTemplate, err = templates.RenderFromStdin(template, []string{"OWNER=platform"}, templates.RenderOptions{})
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	expected := strings.Replace(template, "{{ .OWNER }}", "platform", 1)
+	if hydratedTemplate != expected {
+		t.Errorf("Expected <%s>, received <%s>", expected, hydratedTemplate)
+	}
+	_, err = templates.RenderFromStdin(`name: {{ template "mychart.fullname" . }}`, nil, templates.RenderOptions{Strict: true})
+
+	if !errors.Is(err, templates.TemplateError{}) || !strings.Contains(err.Error(), `can't find template "mychart.fullname"`) {
+		t.Errorf("Expected strict mode to refuse an unknown template, got %v", err)
+	}
+}
+
+func TestRenderUsesTemplDefines(t *testing.T) {
+	template := `{{ define "greeting" }}Hello {{ .NAME }}{{ end }}{{ define "plain" }}-{{ end }}{{ template "greeting" . }}{{ template "plain" }}`
+
+	hydratedTemplate, err := templates.RenderFromStdin(template, []string{"NAME=templ"}, templates.RenderOptions{})
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if hydratedTemplate != "Hello templ-" {
+		t.Errorf("Expected <Hello templ->, received <%s>", hydratedTemplate)
+	}
+}
@@ -0,0 +1,333 @@
+package templates
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"templ/configelements"
+	"templ/output"
+	"templ/repository"
+)
+
+// ConflictErr is files that templ upgrade couldn't merge the template's changes into cleanly. Text files are written
+// with conflict markers; binary files and symbolic links are left as they are.
+type ConflictErr struct {
+	Paths []string
+}
+
+func (e ConflictErr) Error() string {
+	return fmt.Sprintf("your changes and the template's conflict in %s. Look for <<<<<<< in them", strings.Join(e.Paths, ", "))
+}
+
+func (e ConflictErr) Is(target error) bool {
+	_, ok := target.(ConflictErr)
+	return ok
+}
+
+// upgradeChange is what upgrading did to a file, for the summary.
+type upgradeChange struct {
+	path     string
+	what     string
+	note     string
+	conflict bool
+}
+
+// Upgrade brings the files recorded in the ProvenanceFile of dir up to date with their templates, without losing the
+// changes made to them since. Each template is rendered twice: as it was, from the recorded commit, which is read from
+// the objects of its repository in the templates directory, and with the recorded variables; and as it is now, like
+// Regenerate does. What changed between the two is merged into the files as they are, line by line, and where it
+// conflicts with changes of yours, both are written with conflict markers, as git merge does.
+//
+// A summary of what happened to each file is written to summary. Files the template doesn't make any more are left as
+// they are. A template whose files still have conflict markers isn't upgraded until they're resolved. With
+// options.Writing.DiffTo or options.Writing.Check, the merged files are compared rather than written, as usual. The
+// ProvenanceFile is brought up to date, and if anything conflicts, the error is a ConflictErr.
+func Upgrade(dir string, options RenderOptions, summary io.Writer) error {
+	provenance, err := ReadProvenance(dir)
+
+	if err != nil {
+		return err
+	}
+
+	var files []output.File
+	var changes []upgradeChange
+	var records []provenanceRecord
+	var renderErrs []error
+
+	for _, rendering := range provenance.Renders {
+		old, err := renderRecordedVersion(dir, rendering, options)
+
+		if isRenderProblem(err) {
+			renderErrs = append(renderErrs, err)
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		current, record, err := regenerate(dir, rendering, options)
+
+		if isRenderProblem(err) {
+			renderErrs = append(renderErrs, err)
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		// Merging again into files whose conflicts haven't been resolved would nest the markers, so the whole rendering
+		// waits, recorded as it is, until they are.
+		unresolved, err := unresolvedConflicts(current)
+
+		if err != nil {
+			return err
+		}
+
+		if len(unresolved) > 0 {
+			for _, path := range unresolved {
+				changes = append(changes, upgradeChange{path: path, what: "conflict", note: " (it still has conflict markers; resolve them and upgrade again)", conflict: true})
+			}
+
+			record.rendering = rendering
+			records = append(records, record)
+			continue
+		}
+
+		labels := output.MergeLabels{
+			Yours:  "yours",
+			Base:   "template at " + shortCommit(rendering.Commit),
+			Theirs: "template at " + shortCommit(record.rendering.Commit),
+		}
+
+		merged, entryChanges, err := mergeUpgrade(old, current, labels)
+
+		if err != nil {
+			return err
+		}
+
+		files = append(files, merged...)
+		changes = append(changes, entryChanges...)
+		records = append(records, record)
+	}
+
+	if len(renderErrs) > 0 {
+		return errors.Join(renderErrs...)
+	}
+
+	// Everything that's written has been merged already.
+	writing := options.Writing
+	writing.Policy = output.Overwrite
+	writing.KeepMode = true
+
+	_, err = output.Write(files, writing)
+
+	if err != nil && !errors.Is(err, output.DriftErr{}) {
+		return err
+	}
+
+	var conflicts []string
+
+	for _, change := range changes {
+		fmt.Fprintf(summary, "%-9s %s%s\n", change.what, change.path, change.note)
+
+		if change.conflict {
+			conflicts = append(conflicts, change.path)
+		}
+	}
+
+	if len(changes) == 0 {
+		fmt.Fprintln(summary, "Everything is up to date")
+	}
+
+	if err != nil {
+		return err
+	}
+
+	err = recordProvenance(records, options)
+
+	if err != nil {
+		return err
+	}
+
+	if len(conflicts) > 0 {
+		return ConflictErr{Paths: conflicts}
+	}
+
+	return nil
+}
+
+// renderRecordedVersion renders a Rendering of the ProvenanceFile in dir as it was first rendered: from the recorded
+// commit of its template and with the recorded variables. The commit is read from the objects of the template's
+// repository and exported, like a ref is, so that the repository's worktree is left alone, and its includes and
+// helpers are the ones of that commit too.
+func renderRecordedVersion(dir string, rendering Rendering, options RenderOptions) ([]output.File, error) {
+	// Upgrading writes over the files that are there, so it goes no further than regenerating does.
+	err := rendering.checkPaths(dir)
+
+	if err != nil {
+		return nil, err
+	}
+
+	target := filepath.Join(dir, filepath.FromSlash(rendering.Output))
+
+	if rendering.Commit == "" {
+		return nil, TemplateVariableErr{ErrorMessage: fmt.Sprintf("%s wasn't recorded with the commit of its template, so there's no old version to merge from. templ regen renders it again instead", target)}
+	}
+
+	repositoryDir := filepath.Join(configelements.NewTemplDir().TemplatesDir, filepath.FromSlash(rendering.Repository))
+	_, err = repository.OpenRepository(repositoryDir)
+
+	if err != nil {
+		return nil, TemplateVariableErr{ErrorMessage: fmt.Sprintf("%s was rendered from %s, which isn't a git repository any more: %v", target, repositoryDir, err)}
+	}
+
+	exported, err := exportRef(repositoryDir, rendering.Commit)
+
+	if errors.Is(err, repository.ErrUnknownRevision{}) {
+		return nil, TemplateVariableErr{ErrorMessage: fmt.Sprintf("%s was rendered from commit %s, which %s doesn't have any more", target, rendering.Commit, repositoryDir)}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	templatePath := filepath.Join(exported, filepath.FromSlash(rendering.Template))
+	info, err := os.Lstat(templatePath)
+
+	if err != nil {
+		return nil, TemplateVariableErr{ErrorMessage: fmt.Sprintf("commit %s of %s doesn't have %s", rendering.Commit, repositoryDir, rendering.Template)}
+	}
+
+	variables := NewVariables()
+	variables.Merge(rendering.Variables, filepath.Join(dir, ProvenanceFile))
+
+	return renderRecorded(templatePath, info, target, rendering, variables.Values, options)
+}
+
+// mergeUpgrade merges the changes between the old and current renders of a template into the files that are there
+// now. It returns the files to write, and what happened to each file that changed.
+func mergeUpgrade(old []output.File, current []output.File, labels output.MergeLabels) ([]output.File, []upgradeChange, error) {
+	base := make(map[string]output.File)
+
+	for _, file := range old {
+		base[file.Path] = file
+	}
+
+	var files []output.File
+	var changes []upgradeChange
+	made := make(map[string]bool)
+
+	for _, theirs := range current {
+		made[theirs.Path] = true
+		original, hadBase := base[theirs.Path]
+		yours, exists, err := readOutput(theirs.Path)
+
+		if err != nil {
+			_, file, line, _ := runtime.Caller(0)
+			return nil, nil, fmt.Errorf("%s:%d: %v", file, line, err)
+		}
+
+		switch {
+		case !exists && !hadBase:
+			files = append(files, theirs)
+			changes = append(changes, upgradeChange{path: theirs.Path, what: "added"})
+		case !exists:
+			if !sameOutput(original, theirs) {
+				changes = append(changes, upgradeChange{path: theirs.Path, what: "conflict", note: " (you deleted it and the template changed it; it's left deleted)", conflict: true})
+			}
+		case sameOutput(yours, theirs):
+		case hadBase && sameOutput(yours, original):
+			files = append(files, theirs)
+			changes = append(changes, upgradeChange{path: theirs.Path, what: "updated"})
+		case hadBase && sameOutput(theirs, original):
+		case theirs.Link != "" || yours.Link != "" || isBinary(theirs.Content) || isBinary(yours.Content) || (hadBase && isBinary(original.Content)):
+			changes = append(changes, upgradeChange{path: theirs.Path, what: "conflict", note: " (yours is left as it is, since it can't be merged)", conflict: true})
+		default:
+			merged, conflicts := output.Merge(original.Content, yours.Content, theirs.Content, labels)
+			theirs.Content = merged
+			files = append(files, theirs)
+
+			if conflicts > 0 {
+				changes = append(changes, upgradeChange{path: theirs.Path, what: "conflict", note: fmt.Sprintf(" (%d of them)", conflicts), conflict: true})
+			} else {
+				changes = append(changes, upgradeChange{path: theirs.Path, what: "merged"})
+			}
+		}
+	}
+
+	for _, file := range old {
+		if made[file.Path] {
+			continue
+		}
+
+		if _, err := os.Lstat(file.Path); err == nil {
+			changes = append(changes, upgradeChange{path: file.Path, what: "orphaned", note: " (the template doesn't make it any more; it's left as it is)"})
+		}
+	}
+
+	return files, changes, nil
+}
+
+// unresolvedConflicts returns the paths of the files that are there now, of those rendered, that still have conflict
+// markers.
+func unresolvedConflicts(rendered []output.File) ([]string, error) {
+	var unresolved []string
+
+	for _, file := range rendered {
+		there, exists, err := readOutput(file.Path)
+
+		if err != nil {
+			_, file, line, _ := runtime.Caller(0)
+			return nil, fmt.Errorf("%s:%d: %v", file, line, err)
+		}
+
+		if exists && there.Link == "" && output.HasConflictMarkers(there.Content) {
+			unresolved = append(unresolved, file.Path)
+		}
+	}
+
+	return unresolved, nil
+}
+
+// readOutput reads what's at the path of a rendered file now, and whether there's anything there.
+func readOutput(path string) (output.File, bool, error) {
+	info, err := os.Lstat(path)
+
+	if errors.Is(err, fs.ErrNotExist) {
+		return output.File{}, false, nil
+	}
+
+	if err != nil {
+		return output.File{}, false, err
+	}
+
+	if info.Mode()&fs.ModeSymlink != 0 {
+		link, err := os.Readlink(path)
+		return output.File{Path: path, Link: link}, true, err
+	}
+
+	content, err := os.ReadFile(path)
+
+	return output.File{Path: path, Content: content}, true, err
+}
+
+// sameOutput reports whether two rendered files have the same contents, or are links to the same place.
+func sameOutput(a output.File, b output.File) bool {
+	return a.Link == b.Link && bytes.Equal(a.Content, b.Content)
+}
+
+// shortCommit is a commit's hash, short enough to read.
+func shortCommit(commit string) string {
+	if len(commit) > 7 {
+		return commit[:7]
+	}
+	return commit
+}
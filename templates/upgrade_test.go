@@ -0,0 +1,228 @@
+package templates_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"templ/output"
+	"templ/templates"
+	"templ/test_helpers"
+	"testing"
+)
+
+func TestUpgrade(t *testing.T) {
+	// The old versions are exported to the cache directory.
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+
+	repository, first := gitRepository(t, map[string]string{
+		"skeleton/README.md":   "# {{ .name }}\n\n2\n3\n4\n5\n6\n",
+		"skeleton/config.yaml": "a: 1\nb: 2\n",
+		"skeleton/old.txt":     "old\n",
+		"skeleton/same.txt":    "same\n",
+	})
+
+	outputDir := t.TempDir()
+	_, err := templates.RenderDirectory(filepath.Join(repository, "skeleton"), nil, outputDir, templates.RenderOptions{Definitions: []string{"name=shop"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// You change the project...
+	edits := map[string]string{
+		"README.md":   "# shop\n\nmy 2\n3\n4\n5\n6\n",
+		"config.yaml": "a: 1\nb: mine\n",
+	}
+
+	for path, content := range edits {
+		err = os.WriteFile(filepath.Join(outputDir, path), []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// ...and the template moves on.
+	changes := map[string]string{
+		"skeleton/README.md":   "# {{ .name }}\n\n2\n3\n4\n5\n6 for {{ .name }}\n",
+		"skeleton/config.yaml": "a: 1\nb: template\n",
+		"skeleton/new.txt":     "new in {{ .name }}\n",
+	}
+
+	for path, content := range changes {
+		err = os.WriteFile(filepath.Join(repository, path), []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = os.Remove(filepath.Join(repository, "skeleton/old.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second := commitAll(t, repository)
+
+	// Checking writes nothing.
+	var summary strings.Builder
+	err = templates.Upgrade(outputDir, templates.RenderOptions{Writing: output.Options{Check: true}}, &summary)
+	if !errors.Is(err, output.DriftErr{}) {
+		t.Errorf("Expected checking an upgrade to be a DriftErr, got %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(outputDir, "new.txt")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected checking to write nothing, got %v", err)
+	}
+
+	summary.Reset()
+	err = templates.Upgrade(outputDir, templates.RenderOptions{}, &summary)
+
+	var conflicts templates.ConflictErr
+	if !errors.As(err, &conflicts) || !reflect.DeepEqual(conflicts.Paths, []string{filepath.Join(outputDir, "config.yaml")}) {
+		t.Errorf("Expected config.yaml to conflict, got %v", err)
+	}
+
+	expected := map[string]string{
+		"README.md":   "# shop\n\nmy 2\n3\n4\n5\n6 for shop\n",
+		"config.yaml": "a: 1\n<<<<<<< yours\nb: mine\n||||||| template at " + first[:7] + "\nb: 2\n=======\nb: template\n>>>>>>> template at " + second[:7] + "\n",
+		"new.txt":     "new in shop\n",
+		"old.txt":     "old\n",
+		"same.txt":    "same\n",
+	}
+
+	for path, want := range expected {
+		content, err := os.ReadFile(filepath.Join(outputDir, path))
+		if err != nil || string(content) != want {
+			t.Errorf("%s: expected <%s>, received <%s>, %v", path, want, content, err)
+		}
+	}
+
+	lines := strings.Split(strings.TrimSpace(summary.String()), "\n")
+	for i, line := range lines {
+		lines[i] = strings.Join(strings.Fields(strings.Replace(line, outputDir+string(filepath.Separator), "", 1)), " ")
+	}
+
+	expectedSummary := []string{
+		"merged README.md",
+		"conflict config.yaml (1 of them)",
+		"added new.txt",
+		"orphaned old.txt (the template doesn't make it any more; it's left as it is)",
+	}
+
+	if !reflect.DeepEqual(lines, expectedSummary) {
+		t.Errorf("Expected the summary <%v>, received <%v>", expectedSummary, lines)
+	}
+
+	provenance, err := templates.ReadProvenance(outputDir)
+	if err != nil || provenance.Renders[0].Commit != second {
+		t.Errorf("Expected the record to be at %s, got <%+v>, %v", second, provenance, err)
+	}
+
+	// While config.yaml still has conflict markers, the template isn't upgraded again.
+	err = os.WriteFile(filepath.Join(repository, "skeleton/README.md"), []byte("# {{ .name }}\n\n2\n3\n4\n5\n6 for {{ .name }}!\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	third := commitAll(t, repository)
+
+	summary.Reset()
+	err = templates.Upgrade(outputDir, templates.RenderOptions{}, &summary)
+	if !errors.Is(err, templates.ConflictErr{}) || !strings.Contains(summary.String(), "config.yaml (it still has conflict markers") {
+		t.Errorf("Expected config.yaml to still conflict, got <%s>, %v", summary.String(), err)
+	}
+
+	for path, want := range expected {
+		content, err := os.ReadFile(filepath.Join(outputDir, path))
+		if err != nil || string(content) != want {
+			t.Errorf("%s: expected <%s> to be left as it is, received <%s>, %v", path, want, content, err)
+		}
+	}
+
+	provenance, err = templates.ReadProvenance(outputDir)
+	if err != nil || provenance.Renders[0].Commit != second {
+		t.Errorf("Expected the record to stay at %s, got <%+v>, %v", second, provenance, err)
+	}
+
+	// Once they're resolved, it is.
+	err = os.WriteFile(filepath.Join(outputDir, "config.yaml"), []byte("a: 1\nb: mine\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	summary.Reset()
+	err = templates.Upgrade(outputDir, templates.RenderOptions{}, &summary)
+	if err != nil || !strings.Contains(summary.String(), "merged") || strings.Contains(summary.String(), "config.yaml") {
+		t.Errorf("Expected only README.md to be merged, got <%s>, %v", summary.String(), err)
+	}
+
+	readme, err := os.ReadFile(filepath.Join(outputDir, "README.md"))
+	if err != nil || string(readme) != "# shop\n\nmy 2\n3\n4\n5\n6 for shop!\n" {
+		t.Errorf("Expected README.md to be merged, received <%s>, %v", readme, err)
+	}
+
+	provenance, err = templates.ReadProvenance(outputDir)
+	if err != nil || provenance.Renders[0].Commit != third {
+		t.Errorf("Expected the record to be at %s, got <%+v>, %v", third, provenance, err)
+	}
+
+	// Once it's upgraded, there's nothing more to do.
+	summary.Reset()
+	err = templates.Upgrade(outputDir, templates.RenderOptions{}, &summary)
+	if err != nil || summary.String() != "Everything is up to date\n" {
+		t.Errorf("Expected another upgrade to change nothing, got <%s>, %v", summary.String(), err)
+	}
+}
+
+func TestUpgradeWithoutACommit(t *testing.T) {
+	repository := includeRepository(t, map[string]string{
+		"notes.txt": "{{ .name }}'s notes\n",
+	})
+
+	outputDir := t.TempDir()
+	err := renderQuietly([]string{filepath.Join(repository, "notes.txt")}, templates.RenderOptions{Definitions: []string{"name=shop"}, Output: outputDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = templates.Upgrade(outputDir, templates.RenderOptions{}, &strings.Builder{})
+	if !errors.Is(err, templates.TemplateVariableErr{}) || !strings.Contains(err.Error(), "templ regen") {
+		t.Errorf("Expected a TemplateVariableErr that points at templ regen, got %v", err)
+	}
+}
+
+func TestUpgradeOnlyWritesIntoItsDirectory(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+
+	_, commit := gitRepository(t, map[string]string{
+		"notes.txt": "{{ .name }}'s notes\n",
+	})
+
+	tests := map[string]string{
+		"an output outside the directory":        "repository: templates-repo\n    output: ../victim/rc",
+		"a repository outside the templates dir": "repository: ../templates-repo\n    output: notes.txt",
+	}
+
+	for name, entry := range tests {
+		base := t.TempDir()
+		projectDir, victim := filepath.Join(base, "project"), filepath.Join(base, "victim", "rc")
+
+		err := test_helpers.WriteFiles(base, map[string]string{
+			"victim/rc":                           "mine\n",
+			"project/" + templates.ProvenanceFile: "renders:\n  - template: notes.txt\n    commit: " + commit + "\n    " + entry + "\n    variables:\n      name: shop\n",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = templates.Upgrade(projectDir, templates.RenderOptions{}, &strings.Builder{})
+		if !errors.Is(err, templates.TemplateVariableErr{}) {
+			t.Errorf("%s: expected a TemplateVariableErr, got %v", name, err)
+		}
+
+		content, err := os.ReadFile(victim)
+		if err != nil || string(content) != "mine\n" {
+			t.Errorf("%s: expected %s to be left alone, received <%s>, %v", name, victim, content, err)
+		}
+	}
+}
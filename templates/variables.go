@@ -0,0 +1,258 @@
+package templates
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// Variables is a variables tree merged together from several layers, and where each value in it came from.
+type Variables struct {
+	Values map[string]interface{}
+	// Sources maps the dotted path of every value in the tree, like database.host, to the layer it came from.
+	Sources map[string]string
+}
+
+// NewVariables returns an empty variables tree.
+func NewVariables() Variables {
+	return Variables{Values: make(map[string]interface{}), Sources: make(map[string]string)}
+}
+
+// Merge deep merges values into the tree, recording source as the origin of everything it sets. Maps are merged key
+// by key; lists and scalars replace whatever was there before.
+func (v Variables) Merge(values map[string]interface{}, source string) {
+	v.merge(v.Values, values, "", source)
+}
+
+func (v Variables) merge(into map[string]interface{}, values map[string]interface{}, prefix string, source string) {
+	for key, value := range values {
+		path := prefix + key
+
+		if m := asMap(value); len(m) > 0 {
+			existing, isMap := into[key].(map[string]interface{})
+
+			if !isMap {
+				v.forget(path)
+				existing = make(map[string]interface{})
+				into[key] = existing
+			}
+
+			v.merge(existing, m, path+".", source)
+			continue
+		}
+
+		v.forget(path)
+		into[key] = value
+		v.Sources[path] = source
+	}
+}
+
+// forget drops the sources recorded for path and everything underneath it.
+func (v Variables) forget(path string) {
+	for recorded := range v.Sources {
+		if recorded == path || strings.HasPrefix(recorded, path+".") {
+			delete(v.Sources, recorded)
+		}
+	}
+}
+
+// recorded copies the values to write down in a ProvenanceFile. Values from the environment are left out, since that's
+// where secrets like tokens are usually passed; they're given again from the environment when the file is rendered
+// again.
+func (v Variables) recorded() map[string]interface{} {
+	recorded := copyVariables(v.Values)
+
+	for path, source := range v.Sources {
+		if strings.HasPrefix(source, "env ") {
+			removeVariable(recorded, strings.Split(path, "."))
+		}
+	}
+
+	return recorded
+}
+
+// Print writes every value in the tree, one per line and sorted by path, along with where it came from.
+func (v Variables) Print(out io.Writer) {
+	paths := make([]string, 0, len(v.Sources))
+	for path := range v.Sources {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		value, _ := lookupVariable(v.Values, strings.Split(path, "."))
+		fmt.Fprintf(out, "%s = %v (%s)\n", path, value, v.Sources[path])
+	}
+}
+
+// LoadVariables layers the variables for a render, from the lowest precedence to the highest:
+// - each variables file, in order, read in options.VariablesFormat;
+// - environment variables whose name starts with options.EnvPrefix. The prefix is removed and a double underscore
+// nests, so TEMPL_VAR_database__host sets database.host;
+// - options.Definitions, KEY=VALUE definitions from the command line, in order. See convertFromArrayToKeymap for
+// their grammar.
+// An empty EnvPrefix turns off the environment layer.
+func LoadVariables(variablesFiles []string, options RenderOptions) (Variables, error) {
+	variables := NewVariables()
+
+	err := variables.mergeFiles(variablesFiles, options.VariablesFormat)
+
+	if err != nil {
+		return variables, err
+	}
+
+	err = variables.mergeOverrides(options)
+
+	return variables, err
+}
+
+// mergeFiles merges each variables file into the tree, in order.
+func (v Variables) mergeFiles(variablesFiles []string, format string) error {
+	for _, variablesFile := range variablesFiles {
+		values, err := LoadVariablesFile(variablesFile, format)
+
+		if err != nil {
+			_, file, line, _ := runtime.Caller(0)
+			return fmt.Errorf("%s:%d: %v", file, line, err)
+		}
+
+		v.Merge(values, variablesFile)
+	}
+
+	return nil
+}
+
+// mergeOverrides merges the layers that win over variables files: the environment and then the command line.
+func (v Variables) mergeOverrides(options RenderOptions) error {
+	if options.EnvPrefix != "" {
+		environment := os.Environ()
+		sort.Strings(environment)
+
+		for _, entry := range environment {
+			name, value, _ := strings.Cut(entry, "=")
+
+			if !strings.HasPrefix(name, options.EnvPrefix) || name == options.EnvPrefix {
+				continue
+			}
+
+			values := make(map[string]interface{})
+			setVariable(values, strings.Split(strings.TrimPrefix(name, options.EnvPrefix), "__"), value)
+			v.Merge(values, "env "+name)
+		}
+	}
+
+	if len(options.Definitions) > 0 {
+		values, err := convertFromArrayToKeymap(options.Definitions)
+
+		if err != nil {
+			return err
+		}
+
+		v.Merge(values, "command line")
+	}
+
+	return nil
+}
+
+func asMap(value interface{}) map[string]interface{} {
+	switch m := value.(type) {
+	case map[string]interface{}:
+		return m
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(m))
+		for k, v := range m {
+			converted[fmt.Sprint(k)] = v
+		}
+		return converted
+	}
+	return nil
+}
+
+// lookupVariable follows a path, like [database host], down a variables tree.
+func lookupVariable(variables map[string]interface{}, path []string) (interface{}, bool) {
+	var current interface{} = variables
+
+	for _, key := range path {
+		switch m := current.(type) {
+		case map[string]interface{}:
+			value, ok := m[key]
+			if !ok {
+				return nil, false
+			}
+			current = value
+		case map[interface{}]interface{}:
+			value, ok := m[key]
+			if !ok {
+				return nil, false
+			}
+			current = value
+		default:
+			return nil, false
+		}
+	}
+
+	return current, true
+}
+
+// setVariable puts value at the end of path in a variables tree, creating maps along the way. Anything in the way
+// that isn't a map is replaced.
+func setVariable(variables map[string]interface{}, path []string, value interface{}) {
+	current := variables
+
+	for _, key := range path[:len(path)-1] {
+		next, ok := current[key].(map[string]interface{})
+
+		if !ok {
+			next = make(map[string]interface{})
+
+			if legacy, isLegacy := current[key].(map[interface{}]interface{}); isLegacy {
+				for k, v := range legacy {
+					next[fmt.Sprint(k)] = v
+				}
+			}
+
+			current[key] = next
+		}
+
+		current = next
+	}
+
+	current[path[len(path)-1]] = value
+}
+
+// removeVariable deletes the end of path from a variables tree, along with the maps on the way that it leaves empty.
+func removeVariable(variables map[string]interface{}, path []string) {
+	if len(path) > 1 {
+		next, ok := variables[path[0]].(map[string]interface{})
+
+		if !ok {
+			return
+		}
+
+		removeVariable(next, path[1:])
+
+		if len(next) > 0 {
+			return
+		}
+	}
+
+	delete(variables, path[0])
+}
+
+// copyVariables copies the maps of a variables tree, so that it can be changed without changing the original. Lists
+// and scalars are shared.
+func copyVariables(variables map[string]interface{}) map[string]interface{} {
+	copied := make(map[string]interface{}, len(variables))
+
+	for key, value := range variables {
+		if m, ok := value.(map[string]interface{}); ok {
+			value = copyVariables(m)
+		}
+		copied[key] = value
+	}
+
+	return copied
+}
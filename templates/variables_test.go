@@ -0,0 +1,108 @@
+package templates_test
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"templ/templates"
+	"templ/test_helpers"
+	"testing"
+)
+
+func TestLoadVariablesLayersInOrder(t *testing.T) {
+	dir := t.TempDir()
+
+	err := test_helpers.WriteFiles(dir, map[string]string{
+		"base.yaml": `name: shop
+replicas: 1
+database:
+  host: localhost
+  port: 5432
+services: [api, worker]
+`,
+		"prod.yaml": `replicas: 3
+database:
+  host: db.internal
+services: [api]
+`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	base := filepath.Join(dir, "base.yaml")
+	prod := filepath.Join(dir, "prod.yaml")
+
+	t.Setenv("TESTING_TEMPL_owner", "platform")
+	t.Setenv("TESTING_TEMPL_database__port", "6432")
+
+	variables, err := templates.LoadVariables([]string{base, prod}, templates.RenderOptions{EnvPrefix: "TESTING_TEMPL_", Definitions: []string{"replicas=5"}})
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	expectedValues := map[string]interface{}{
+		"name":     "shop",
+		"replicas": "5",
+		"owner":    "platform",
+		"database": map[string]interface{}{"host": "db.internal", "port": "6432"},
+		"services": []interface{}{"api"},
+	}
+
+	if !reflect.DeepEqual(variables.Values, expectedValues) {
+		t.Errorf("Expected <%v>, received <%v>", expectedValues, variables.Values)
+	}
+
+	expectedSources := map[string]string{
+		"name":          base,
+		"replicas":      "command line",
+		"owner":         "env TESTING_TEMPL_owner",
+		"database.host": prod,
+		"database.port": "env TESTING_TEMPL_database__port",
+		"services":      prod,
+	}
+
+	if !reflect.DeepEqual(variables.Sources, expectedSources) {
+		t.Errorf("Expected <%v>, received <%v>", expectedSources, variables.Sources)
+	}
+}
+
+func TestShowVariablesIncludesDefaults(t *testing.T) {
+	template := `---
+templ:
+  variables:
+    REPLICAS:
+      default: 2
+---
+{{ .NAME }} {{ .REPLICAS }}`
+
+	var out strings.Builder
+	err := templates.ShowVariables("stdin", template, nil, templates.RenderOptions{Definitions: []string{"NAME=shop"}}, &out)
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	expected := `stdin:
+NAME = shop (command line)
+REPLICAS = 2 (default in stdin)
+`
+	if out.String() != expected {
+		t.Errorf("Expected <%s>, received <%s>", expected, out.String())
+	}
+}
+
+func TestRenderFromStdinUsesEnvironmentVariables(t *testing.T) {
+	t.Setenv("TESTING_TEMPL_SPECIES", "HUMAN")
+
+	hydratedTemplate, err := templates.RenderFromStdin(`I love {{ .SPECIES }}`, []string{}, templates.RenderOptions{EnvPrefix: "TESTING_TEMPL_"})
+
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if hydratedTemplate != "I love HUMAN" {
+		t.Errorf("Expected <I love HUMAN>, received <%s>", hydratedTemplate)
+	}
+}
@@ -0,0 +1,167 @@
+package templates
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"templ/configelements"
+	"templ/output"
+)
+
+// DiffVersions renders two versions of a template with the same variables, and writes a unified diff of what they
+// render to out. Each version is a template name like build.yaml@v1.4.0 or go-service@main: a template file, or a
+// directory template, as it is at a git tag, branch or commit, or as it's checked out if there's no ref. The
+// variables come from variablesFiles, the environment and options.Definitions, as for LoadVariables. It returns whether
+// the two render differently.
+func DiffVersions(oldName string, newName string, variablesFiles []string, options RenderOptions, out io.Writer) (bool, error) {
+	variables, err := LoadVariables(variablesFiles, options)
+
+	if err != nil {
+		_, file, line, _ := runtime.Caller(0)
+		return false, fmt.Errorf("%s:%d: %w", file, line, err)
+	}
+
+	oldFiles, oldIsDir, err := renderVersion(oldName, variables.Values, options)
+
+	if err != nil {
+		return false, err
+	}
+
+	newFiles, newIsDir, err := renderVersion(newName, variables.Values, options)
+
+	if err != nil {
+		return false, err
+	}
+
+	if oldIsDir != newIsDir {
+		return false, TemplateVariableErr{ErrorMessage: fmt.Sprintf("one of %s and %s is a directory and the other is a file, so there's nothing to compare", oldName, newName)}
+	}
+
+	old, new := make(map[string]output.File), make(map[string]output.File)
+	var paths []string
+
+	for _, file := range oldFiles {
+		old[file.Path] = file
+		paths = append(paths, file.Path)
+	}
+
+	for _, file := range newFiles {
+		new[file.Path] = file
+
+		if _, found := old[file.Path]; !found {
+			paths = append(paths, file.Path)
+		}
+	}
+
+	slices.Sort(paths)
+	changed := false
+
+	for _, path := range paths {
+		oldFile, inOld := old[path]
+		newFile, inNew := new[path]
+		oldLabel, newLabel := os.DevNull, os.DevNull
+
+		if inOld {
+			oldLabel = filepath.ToSlash(filepath.Join(oldName, path))
+		}
+
+		if inNew {
+			newLabel = filepath.ToSlash(filepath.Join(newName, path))
+		}
+
+		oldContent, oldMode := versionContent(oldFile)
+		newContent, newMode := versionContent(newFile)
+
+		if inOld && inNew && oldMode != newMode {
+			changed = true
+			fmt.Fprintf(out, "%s: mode %v changes to %v\n", path, oldMode, newMode)
+		}
+
+		diff := output.UnifiedDiff(oldLabel, newLabel, oldContent, newContent)
+
+		if diff != "" {
+			changed = true
+			fmt.Fprint(out, diff)
+		}
+	}
+
+	return changed, nil
+}
+
+// renderVersion finds a version of a template by name and renders it, without writing anything. A directory template's
+// files have their paths in the directory; a template file's one file has no path.
+func renderVersion(name string, variables map[string]interface{}, options RenderOptions) ([]output.File, bool, error) {
+	templateDir, err := FindTemplateDirectory(name)
+
+	if err == nil {
+		files, err := renderDirectory(templateDir, variables, options)
+		return files, true, err
+	}
+
+	if !errors.Is(err, TemplateVariableErr{}) {
+		return nil, false, err
+	}
+
+	templatePath, err := findVersionFile(name)
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	info, err := os.Lstat(templatePath)
+
+	if err != nil {
+		_, file, line, _ := runtime.Caller(0)
+		return nil, false, fmt.Errorf("%s:%d: %v", file, line, err)
+	}
+
+	file, err := renderFile(templatePath, info, variables, options)
+
+	return []output.File{file}, false, err
+}
+
+// findVersionFile finds the one template file a name like build.yaml@v1.4.0 is. A name that matches more than one
+// file is an error.
+func findVersionFile(name string) (string, error) {
+	base, ref := splitRef(name)
+
+	if ref == "" && isFile(name) {
+		return name, nil
+	}
+
+	var found []string
+	var err error
+
+	if ref != "" {
+		found, err = findFilesAtRef(base, ref)
+	} else {
+		found, err = findFilesByName(configelements.NewTemplDir().TemplatesDir, []string{name})
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	switch len(found) {
+	case 0:
+		return "", TemplateVariableErr{ErrorMessage: fmt.Sprintf("can't find a template called %s", name)}
+	case 1:
+		return found[0], nil
+	}
+
+	return "", TemplateVariableErr{ErrorMessage: fmt.Sprintf("template %s could be any of %s", name, strings.Join(found, ", "))}
+}
+
+// versionContent is what a rendered file is compared by: its contents and mode, or its target for a symbolic link.
+func versionContent(file output.File) ([]byte, os.FileMode) {
+	if file.Link != "" {
+		return []byte(file.Link), os.ModeSymlink
+	}
+
+	return file.Content, file.Mode.Perm()
+}
@@ -0,0 +1,76 @@
+package templates_test
+
+import (
+	"errors"
+	"strings"
+	"templ/templates"
+	"testing"
+)
+
+func TestDiffVersions(t *testing.T) {
+	refRepository(t)
+	options := templates.RenderOptions{Definitions: []string{"name=shop"}}
+
+	tests := map[string]struct {
+		old      string
+		new      string
+		expected string
+	}{
+		"a file and its include": {"build.yaml@v1", "build.yaml@master", `--- build.yaml@v1
++++ build.yaml@master
+@@ -1,2 +1,2 @@
+-version: 1
+-name: shop
++version: 2
++name: shop!
+`},
+		"a ref and what's checked out": {"build.yaml@v1", "build.yaml", `--- build.yaml@v1
++++ build.yaml
+@@ -1,2 +1,2 @@
+-version: 1
+-name: shop
++version: 2
++name: shop!
+`},
+		"a directory": {"skeleton@v1", "skeleton@master", `--- skeleton@v1/README.md
++++ skeleton@master/README.md
+@@ -1 +1 @@
+-# shop 1
++# shop 2
+`},
+		"the same version": {"skeleton@v1", "skeleton@v1", ""},
+	}
+
+	for name, test := range tests {
+		var diff strings.Builder
+		changed, err := templates.DiffVersions(test.old, test.new, nil, options, &diff)
+
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+
+		if diff.String() != test.expected || changed != (test.expected != "") {
+			t.Errorf("%s: expected\n%s\nreceived, changed %v,\n%s", name, test.expected, changed, diff.String())
+		}
+	}
+}
+
+func TestDiffVersionsProblems(t *testing.T) {
+	refRepository(t)
+	options := templates.RenderOptions{Definitions: []string{"name=shop"}}
+
+	problems := map[string][2]string{
+		"a ref that isn't there":    {"build.yaml@v9", "build.yaml"},
+		"a file and a directory":    {"build.yaml@v1", "skeleton@v1"},
+		"a template that isn't one": {"nothing.yaml@v1", "build.yaml"},
+	}
+
+	for name, versions := range problems {
+		_, err := templates.DiffVersions(versions[0], versions[1], nil, options, &strings.Builder{})
+
+		if !errors.Is(err, templates.TemplateVariableErr{}) {
+			t.Errorf("%s: expected a TemplateVariableErr, got %v", name, err)
+		}
+	}
+}
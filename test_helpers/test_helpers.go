@@ -2,6 +2,7 @@ package test_helpers
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -113,3 +114,54 @@ func CreateFileSystem(paths []string) (tempDir string, err error) {
 	}
 	return tempDir, err
 }
+
+// CaptureStdout runs f and returns everything it wrote to stdout.
+func CaptureStdout(f func()) (string, error) {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		_, file, line, _ := runtime.Caller(0)
+		return "", fmt.Errorf("%s:%d: %v", file, line, err)
+	}
+
+	stdout := os.Stdout
+	os.Stdout = writer
+
+	output := make(chan string)
+	go func() {
+		var buffer strings.Builder
+		_, _ = io.Copy(&buffer, reader)
+		output <- buffer.String()
+	}()
+
+	f()
+
+	os.Stdout = stdout
+	err = writer.Close()
+	if err != nil {
+		_, file, line, _ := runtime.Caller(0)
+		return "", fmt.Errorf("%s:%d: %v", file, line, err)
+	}
+
+	return <-output, nil
+}
+
+// WriteFiles creates each file in contents, keyed by its path relative to dir, creating directories as needed.
+func WriteFiles(dir string, contents map[string]string) error {
+	for p, content := range contents {
+		fullPath := filepath.Join(dir, p)
+
+		err := os.MkdirAll(filepath.Dir(fullPath), 0755)
+		if err != nil {
+			_, file, line, _ := runtime.Caller(0)
+			return fmt.Errorf("%s:%d: %v", file, line, err)
+		}
+
+		err = os.WriteFile(fullPath, []byte(content), 0644)
+		if err != nil {
+			_, file, line, _ := runtime.Caller(0)
+			return fmt.Errorf("%s:%d: %v", file, line, err)
+		}
+	}
+
+	return nil
+}